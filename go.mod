@@ -1,3 +1,3 @@
 module github.com/motoki317/lisp-interpreter
 
-go 1.15
+go 1.16
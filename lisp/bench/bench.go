@@ -0,0 +1,120 @@
+// Package bench provides classic Scheme benchmark programs, to track the performance of the evaluator
+// through the public API of the interpreter.
+package bench
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/printer"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"strconv"
+	"strings"
+)
+
+// Program is a benchmark program.
+type Program struct {
+	// Name is the name of the benchmark.
+	Name string
+	// Setup defines the functions used by Expr, evaluated once for each interpreter.
+	Setup string
+	// Expr is the expression evaluated repeatedly.
+	Expr string
+	// Want is the expected result of Expr, as written by write.
+	Want string
+}
+
+// Programs are the benchmark programs.
+var Programs = []Program{
+	{
+		Name:  "fib",
+		Setup: "(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))",
+		Expr:  "(fib 20)",
+		Want:  "6765",
+	},
+	{
+		Name:  "tak",
+		Setup: "(define (tak x y z) (if (not (< y x)) z (tak (tak (- x 1) y z) (tak (- y 1) z x) (tak (- z 1) x y))))",
+		Expr:  "(tak 18 12 6)",
+		Want:  "7",
+	},
+	{
+		Name: "ackermann",
+		Setup: `(define (ack m n)
+  (cond ((= m 0) (+ n 1))
+        ((= n 0) (ack (- m 1) 1))
+        (else (ack (- m 1) (ack m (- n 1))))))`,
+		Expr: "(ack 3 5)",
+		Want: "253",
+	},
+	{
+		Name: "nqueens",
+		Setup: `(define (queens board-size)
+  (define (ok? row dist placed)
+    (or (null? placed)
+        (and (not (= (car placed) (+ row dist)))
+             (not (= (car placed) (- row dist)))
+             (not (= (car placed) row))
+             (ok? row (+ dist 1) (cdr placed)))))
+  (define (try row placed k)
+    (cond ((= k 0) 1)
+          ((> row board-size) 0)
+          (else (+ (if (ok? row 1 placed) (try 1 (cons row placed) (- k 1)) 0)
+                   (try (+ row 1) placed k)))))
+  (try 1 '() board-size))`,
+		Expr: "(queens 8)",
+		Want: "92",
+	},
+	{
+		Name:  "string-append",
+		Setup: "(define (build n acc) (if (= n 0) acc (build (- n 1) (string-append acc (number->string n)))))",
+		Expr:  `(build 500 "")`,
+		Want:  strconv.Quote(countdown(500)),
+	},
+	{
+		Name: "list",
+		Setup: `(define (make-list* n) (if (= n 0) '() (cons n (make-list* (- n 1)))))
+(define (sum lst) (if (null? lst) 0 (+ (car lst) (sum (cdr lst)))))`,
+		Expr: "(sum (reverse (map (lambda (x) (* x 2)) (append (make-list* 5000) (iota 5000)))))",
+		Want: "50000000",
+	},
+}
+
+// countdown returns the concatenation of the numbers from n down to 1.
+func countdown(n int) string {
+	var sb strings.Builder
+	for ; n > 0; n-- {
+		sb.WriteString(strconv.Itoa(n))
+	}
+	return sb.String()
+}
+
+// Runner evaluates the expression of a program repeatedly.
+type Runner struct {
+	p Program
+	i *lisp.Interpreter
+}
+
+// NewRunner makes an interpreter with the options, and evaluates the setup of the program.
+func NewRunner(p Program, opts ...lisp.Option) (*Runner, error) {
+	i := lisp.NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, opts...)
+	if _, err := i.EvalString(context.Background(), p.Setup); err != nil {
+		return nil, fmt.Errorf("%v: setup: %w", p.Name, err)
+	}
+	return &Runner{p: p, i: i}, nil
+}
+
+// Run evaluates the expression of the program once, and checks the result.
+func (r *Runner) Run(ctx context.Context) (object.Object, error) {
+	res, err := r.i.EvalString(ctx, r.p.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", r.p.Name, err)
+	}
+	if got := printer.Write(res); got != r.p.Want {
+		return nil, fmt.Errorf("%v: got %v, want %v", r.p.Name, got, r.p.Want)
+	}
+	return res, nil
+}
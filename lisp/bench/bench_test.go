@@ -0,0 +1,50 @@
+package bench
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp"
+	"testing"
+)
+
+var engines = []lisp.Engine{lisp.EngineClosure, lisp.EngineVM}
+
+func TestPrograms(t *testing.T) {
+	for _, engine := range engines {
+		for _, p := range Programs {
+			p := p
+			engine := engine
+			t.Run(engine.String()+"/"+p.Name, func(t *testing.T) {
+				t.Parallel()
+				r, err := NewRunner(p, lisp.WithEngine(engine))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := r.Run(context.Background()); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPrograms(b *testing.B) {
+	for _, p := range Programs {
+		for _, engine := range engines {
+			p := p
+			engine := engine
+			b.Run(p.Name+"/"+engine.String(), func(b *testing.B) {
+				r, err := NewRunner(p, lisp.WithEngine(engine))
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := r.Run(context.Background()); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
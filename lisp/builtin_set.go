@@ -0,0 +1,63 @@
+package lisp
+
+import (
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"strconv"
+)
+
+// BuiltinSet is a named set of builtins available to programs.
+type BuiltinSet int
+
+const (
+	// BuiltinsPure provides only functions without side effects outside the program,
+	// such as arithmetic, list, string and stream functions.
+	BuiltinsPure BuiltinSet = iota
+	// BuiltinsIO provides ports in addition to BuiltinsPure, including reading from the console with read.
+	BuiltinsIO
+	// BuiltinsFull provides all builtins in addition to BuiltinsIO, including threads and channels,
+	// file access if enabled by WithFileRoot, and importing libraries other than (scheme ...) and (srfi ...).
+	BuiltinsFull
+)
+
+func (b BuiltinSet) String() string {
+	switch b {
+	case BuiltinsPure:
+		return "pure"
+	case BuiltinsIO:
+		return "io"
+	case BuiltinsFull:
+		return "full"
+	}
+	return strconv.Itoa(int(b))
+}
+
+// WithBuiltins sets the builtins available to programs. BuiltinsFull is used by default.
+func WithBuiltins(b BuiltinSet) Option {
+	return func(i *Interpreter) {
+		i.builtinSet = b
+	}
+}
+
+// WithEnv uses the given environment as the global environment, instead of one made from the builtin set.
+// Use NewEnv to make an environment with only the allowed builtins.
+func WithEnv(env *object.Env) Option {
+	return func(i *Interpreter) {
+		i.globalEnv = env
+	}
+}
+
+// NewEnv makes a new global environment with only the builtins of the given names.
+// The names must be of builtins in BuiltinsPure not depending on the state of an interpreter,
+// i.e. other than random and random-seed.
+func NewEnv(names ...string) (*object.Env, error) {
+	global := object.EmptyFrame()
+	for _, name := range names {
+		v, ok := defaultEnv[name]
+		if !ok {
+			return nil, fmt.Errorf("no pure builtin named %v", name)
+		}
+		global[name] = v
+	}
+	return object.NewGlobalEnv(global), nil
+}
@@ -0,0 +1,255 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"reflect"
+	"time"
+)
+
+// channelEnv is the environment of channel functions, available to programs with threads.
+var channelEnv = make(map[string]object.Object)
+
+func init() {
+	channelEnv["channel?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Channel)
+		}))
+	channelEnv["make-channel"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (make-channel [capacity])
+		if len(objects) > 1 {
+			return object.NewErrorObject(fmt.Sprintf("make-channel: expected 0 or 1 arguments, but got %v", len(objects)))
+		}
+		capacity := 0
+		if len(objects) == 1 {
+			var errObj object.Object
+			if capacity, errObj = indexArg("make-channel", 0, objects[0]); errObj != nil {
+				return errObj
+			}
+		}
+		return object.NewChannelObject(capacity)
+	})
+	channelEnv["channel-send"] = object.NewWrappedFunctionObject(
+		makeBinary(makeChannel("channel-send", func(ctx context.Context, c *object.Channel, objects []object.Object) object.Object {
+			if err := c.Send(ctx, objects[1]); err == object.ErrChannelClosed {
+				return object.NewErrorObject(fmt.Sprintf("channel-send: %v", err))
+			} else if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", err))
+			}
+			return object.VoidObj
+		})))
+	channelEnv["channel-receive"] = object.NewWrappedFunctionObject(
+		makeUnary(makeChannel("channel-receive", func(ctx context.Context, c *object.Channel, _ []object.Object) object.Object {
+			// returns the eof object if closed
+			value, ok, err := c.Receive(ctx)
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", err))
+			}
+			if !ok {
+				return object.EOFObj
+			}
+			return value
+		})))
+	channelEnv["channel-close"] = object.NewWrappedFunctionObject(
+		makeUnary(makeChannel("channel-close", func(_ context.Context, c *object.Channel, _ []object.Object) object.Object {
+			if err := c.Close(); err != nil {
+				return object.NewErrorObject(fmt.Sprintf("channel-close: %v", err))
+			}
+			return object.VoidObj
+		})))
+}
+
+func makeChannel(name string, next func(ctx context.Context, c *object.Channel, objects []object.Object) object.Object) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		c, ok := objects[0].(*object.Channel)
+		if !ok {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected channel, but got %v", name, objects[0]))
+		}
+		return next(ctx, c, objects)
+	}
+}
+
+// selectClause is a compiled clause of select.
+type selectClause struct {
+	// op is the channel operation: receive, send, timeout or else
+	op string
+	// args are the arguments of the operation
+	args []value
+	// variable is the name to bind the received value to, empty if none
+	variable string
+	// s is the scope of the body, binding the variable if any
+	s *scope
+	// body is nil for a clause without body
+	body code
+}
+
+// compileSelect compiles select, which waits for one of the channel operations to proceed, like select in Go.
+//
+//	(select
+//	  ((receive ch x) body ...) ; receives from ch, binding the value (or the eof object if closed) to x
+//	  ((send ch value) body ...) ; sends value to ch
+//	  ((timeout seconds) body ...) ; proceeds after the timeout
+//	  (else body ...)) ; proceeds if no other clauses can proceed immediately
+//
+// Channels and values are evaluated in order before waiting, and the value of the last body expression
+// of the chosen clause is returned. An empty body returns the received value of the clause.
+func compileSelect(n *node.Node, s *scope) code {
+	if len(n.Children) == 1 {
+		return errorCode("bad syntax: select needs at least 1 clause, but got 0")
+	}
+
+	clauses := make([]selectClause, len(n.Children)-1)
+	hasElse := false
+	for i, clause := range n.Children[1:] {
+		if clause.Type != node.Branch || len(clause.Children) == 0 {
+			return errorCode("bad syntax: select clause needs to be a list, but got %v", clause)
+		}
+		op := clause.Children[0]
+		if op.Type == node.Keyword && op.Str == "else" {
+			if hasElse {
+				return errorCode("bad syntax: select can have only 1 else clause")
+			}
+			hasElse = true
+			clauses[i].op = "else"
+			continue
+		}
+		if op.Type != node.Branch || len(op.Children) == 0 || op.Children[0].Type != node.Identifier {
+			return errorCode("bad syntax: expected channel operation in select clause, but got %v", op)
+		}
+
+		name, args := op.Children[0].Str, op.Children[1:]
+		switch {
+		case name == "receive" && (len(args) == 1 || len(args) == 2):
+			if len(args) == 2 {
+				if args[1].Type != node.Identifier {
+					return errorCode("bad syntax: expected identifier to bind the received value, but got %v", args[1])
+				}
+				clauses[i].variable = args[1].Str
+				args = args[:1]
+			}
+		case name == "send" && len(args) == 2:
+		case name == "timeout" && len(args) == 1:
+		case name == "receive" || name == "send" || name == "timeout":
+			return errorCode("bad syntax: wrong number of arguments to %v in select clause: %v", name, op)
+		default:
+			return errorCode("bad syntax: unknown channel operation in select clause: %v", name)
+		}
+		clauses[i].op = name
+		clauses[i].args = compileValues(args, s)
+	}
+	for i, clause := range n.Children[1:] {
+		if len(clause.Children) > 1 {
+			var names []string
+			if clauses[i].variable != "" {
+				names = []string{clauses[i].variable}
+			}
+			clauses[i].s = newScope(names, clause.Children[1:], s)
+			clauses[i].body = compileBody(clause.Children[1:], clauses[i].s)
+		}
+	}
+
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return evalSelect(ctx, clauses, env)
+	}
+}
+
+func evalSelect(ctx context.Context, clauses []selectClause, env *object.Env) (object.Object, code, *object.Env) {
+	const (
+		received = iota
+		receivedClosed
+		sent
+		sentClosed
+		timedOut
+	)
+	// the first case is for cancellation
+	cases := []reflect.SelectCase{{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}}
+	type caseInfo struct {
+		clause int
+		kind   int
+		c      *object.Channel
+	}
+	infos := []caseInfo{{}}
+	elseClause := -1
+	for i, clause := range clauses {
+		switch clause.op {
+		case "else":
+			elseClause = i
+		case "receive", "send":
+			co := clause.args[0](ctx, env)
+			c, ok := co.(*object.Channel)
+			if !ok {
+				return object.NewErrorObject(fmt.Sprintf("select: expected channel, but got %v", co)), nil, nil
+			}
+			if clause.op == "receive" {
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Chan())},
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Done())})
+				infos = append(infos, caseInfo{clause: i, kind: received, c: c}, caseInfo{clause: i, kind: receivedClosed, c: c})
+			} else {
+				// a send to a closed channel with space in its buffer would otherwise proceed at random
+				select {
+				case <-c.Done():
+					return object.NewErrorObject(fmt.Sprintf("select: %v", object.ErrChannelClosed)), nil, nil
+				default:
+				}
+				value := clause.args[1](ctx, env)
+				cases = append(cases,
+					reflect.SelectCase{Dir: reflect.SelectSend, Chan: reflect.ValueOf(c.Chan()), Send: reflect.ValueOf(&value).Elem()},
+					reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Done())})
+				infos = append(infos, caseInfo{clause: i, kind: sent, c: c}, caseInfo{clause: i, kind: sentClosed, c: c})
+			}
+		case "timeout":
+			timeout, errObj := timeoutArg("select", []object.Object{clause.args[0](ctx, env)}, 0)
+			if errObj != nil {
+				return errObj, nil, nil
+			}
+			if timeout < 0 {
+				return object.NewErrorObject("select: expected timeout, but got #f"), nil, nil
+			}
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(timer.C)})
+			infos = append(infos, caseInfo{clause: i, kind: timedOut})
+		}
+	}
+	if elseClause >= 0 {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+
+	chosen, recv, _ := reflect.Select(cases)
+	if chosen == 0 {
+		return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", ctx.Err())), nil, nil
+	}
+	var clause selectClause
+	var value object.Object = object.VoidObj
+	if chosen == len(infos) {
+		clause = clauses[elseClause]
+	} else {
+		info := infos[chosen]
+		clause = clauses[info.clause]
+		switch info.kind {
+		case received:
+			value = recv.Interface().(object.Object)
+		case receivedClosed:
+			// receive values sent before closing
+			var ok bool
+			if value, ok = info.c.TryReceive(); !ok {
+				value = object.EOFObj
+			}
+		case sentClosed:
+			return object.NewErrorObject(fmt.Sprintf("select: %v", object.ErrChannelClosed)), nil, nil
+		}
+	}
+
+	if clause.body == nil {
+		return value, nil, nil
+	}
+	slots := clause.s.slots()
+	if clause.variable != "" {
+		slots[0] = value
+	}
+	return clause.body(ctx, env.NewEnv(clause.s.names, slots))
+}
@@ -0,0 +1,857 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/macro"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+// code is a node compiled into a Go closure, which evaluates the node in the given environment.
+// Special forms are resolved when compiling, so that evaluating the code does not dispatch on the node again.
+// Returns the result obj, nil continuation, and nil newEnv.
+// Otherwise, returns nil, continuation code, and newEnv to evaluate with for tail call optimization.
+type code func(ctx context.Context, env *object.Env) (obj object.Object, continuation code, newEnv *object.Env)
+
+// value is a node compiled into a Go closure, which evaluates the node to the end in the given environment.
+type value func(ctx context.Context, env *object.Env) object.Object
+
+// errorCode returns code resulting in an error object of the message, for a node which cannot be evaluated.
+func errorCode(format string, a ...interface{}) code {
+	msg := fmt.Sprintf(format, a...)
+	return func(_ context.Context, _ *object.Env) (object.Object, code, *object.Env) {
+		return object.NewErrorObject(msg), nil, nil
+	}
+}
+
+// constant returns the object of a self-evaluating node.
+func constant(n *node.Node) (object.Object, bool) {
+	switch n.Type {
+	case node.Number:
+		if n.Inexact {
+			return object.NewInexactNumberObject(n.Num), true
+		}
+		return object.NewNumberObject(n.Num), true
+	case node.Boolean:
+		return object.NewBooleanObject(n.B), true
+	case node.String:
+		return object.NewStringObject(n.Str), true
+	case node.Char:
+		return object.NewCharObject(n.Ch), true
+	}
+	return nil, false
+}
+
+// compileValue compiles the node not in tail position.
+// Constants and variables are evaluated directly, without going through evalCode.
+func compileValue(n *node.Node, s *scope) value {
+	if obj, ok := constant(n); ok {
+		return func(_ context.Context, _ *object.Env) object.Object {
+			return obj
+		}
+	}
+	if n.Type == node.Identifier {
+		return compileVariable(n.Str, s)
+	}
+	c := compile(n, s)
+	return func(ctx context.Context, env *object.Env) object.Object {
+		return evalCode(ctx, c, env)
+	}
+}
+
+func compileValues(nodes []*node.Node, s *scope) []value {
+	values := make([]value, len(nodes))
+	for i, n := range nodes {
+		values[i] = compileValue(n, s)
+	}
+	return values
+}
+
+// compileVariable compiles the reference to the variable.
+// Variables in local environments are resolved to slots, and others are looked up in the global environment by name.
+func compileVariable(name string, s *scope) value {
+	unbound := func() object.Object {
+		return object.NewErrorObject(fmt.Sprintf("unbound identifier: %v", name))
+	}
+	depth, index, mutable, ok := s.resolve(name)
+	switch {
+	case !ok:
+		sym := node.Intern(name)
+		return func(_ context.Context, env *object.Env) object.Object {
+			if obj, ok := env.LookupGlobal(sym); ok {
+				return obj
+			}
+			return unbound()
+		}
+	case mutable:
+		return func(_ context.Context, env *object.Env) object.Object {
+			if obj := env.LoadSlot(depth, index); obj != nil {
+				return obj
+			}
+			return unbound()
+		}
+	default:
+		return func(_ context.Context, env *object.Env) object.Object {
+			if obj := env.Slot(depth, index); obj != nil {
+				return obj
+			}
+			return unbound()
+		}
+	}
+}
+
+// compileBody compiles the sentences evaluated in order, where the last one is in tail position.
+// Expects at least one sentence.
+func compileBody(sentences []*node.Node, s *scope) code {
+	if len(sentences) == 1 {
+		return compile(sentences[0], s)
+	}
+	values := compileValues(sentences[:len(sentences)-1], s)
+	last := compile(sentences[len(sentences)-1], s)
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		for _, v := range values {
+			v(ctx, env)
+		}
+		return last(ctx, env)
+	}
+}
+
+func compileAnd(n *node.Node, s *scope) code {
+	values := compileValues(n.Children[1:], s)
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		// Short circuit evaluation
+		res := object.NewBooleanObject(true)
+		for _, v := range values {
+			res = v(ctx, env)
+			if !res.IsTruthy() {
+				return object.NewBooleanObject(false), nil, nil
+			}
+		}
+		return res, nil, nil
+	}
+}
+
+func compileOr(n *node.Node, s *scope) code {
+	values := compileValues(n.Children[1:], s)
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		// Short circuit evaluation
+		for _, v := range values {
+			res := v(ctx, env)
+			if res.IsTruthy() {
+				return res, nil, nil
+			}
+		}
+		return object.NewBooleanObject(false), nil, nil
+	}
+}
+
+func compileIf(n *node.Node, s *scope) code {
+	if len(n.Children) != 3 && len(n.Children) != 4 {
+		return errorCode("bad syntax: if needs 2 or 3 arguments, but got %v", len(n.Children)-1)
+	}
+
+	test := compileValue(n.Children[1], s)
+	then := compile(n.Children[2], s)
+	if len(n.Children) == 3 {
+		return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+			if test(ctx, env).IsTruthy() {
+				return then(ctx, env)
+			}
+			return object.VoidObj, nil, nil
+		}
+	}
+	els := compile(n.Children[3], s)
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		if test(ctx, env).IsTruthy() {
+			return then(ctx, env)
+		}
+		return els(ctx, env)
+	}
+}
+
+func compileLet(n *node.Node, s *scope) code {
+	if len(n.Children) <= 2 {
+		return errorCode("bad syntax: let needs at least 2 arguments, but got %v", len(n.Children)-1)
+	}
+
+	pairs := n.Children[1]
+	keys := make([]string, len(pairs.Children))
+	values := make([]value, len(pairs.Children))
+	for i, pair := range pairs.Children {
+		if len(pair.Children) != 2 {
+			return errorCode("bad syntax: let bind pair needs a list of length 2, but got length %v", len(pair.Children))
+		}
+		if pair.Children[0].Type != node.Identifier {
+			return errorCode("bad syntax: let bind pair requires identifier, but got %v", pair.Children[0].Type)
+		}
+
+		keys[i] = pair.Children[0].Str
+		values[i] = compileValue(pair.Children[1], s)
+	}
+	bodyScope := newScope(keys, n.Children[2:], s)
+	body := compileBody(n.Children[2:], bodyScope)
+
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		slots := bodyScope.slots()
+		for i, v := range values {
+			slots[i] = v(ctx, env)
+		}
+		return body(ctx, env.NewEnv(bodyScope.names, slots))
+	}
+}
+
+func compileLetSeq(n *node.Node, s *scope) code {
+	if len(n.Children) <= 2 {
+		return errorCode("bad syntax: let* needs at least 2 arguments, but got %v", len(n.Children)-1)
+	}
+
+	pairs := n.Children[1]
+	// all bindings are in the same environment, where each value can refer to the previous bindings
+	s = newScope(nil, n.Children[1:], s)
+	indices := make([]int, len(pairs.Children))
+	values := make([]value, len(pairs.Children))
+	for i, pair := range pairs.Children {
+		if len(pair.Children) != 2 {
+			return errorCode("bad syntax: let* bind pair needs a list of length 2, but got length %v", len(pair.Children))
+		}
+		if pair.Children[0].Type != node.Identifier {
+			return errorCode("bad syntax: let* bind pair requires identifier, but got %v", pair.Children[0].Type)
+		}
+
+		values[i] = compileValue(pair.Children[1], s)
+		indices[i] = s.add(pair.Children[0].Str, false)
+	}
+	body := compileBody(n.Children[2:], s)
+
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		env = env.NewEnv(s.names, s.slots())
+		for i, v := range values {
+			env.StoreSlot(0, indices[i], v(ctx, env))
+		}
+		return body(ctx, env)
+	}
+}
+
+// condClause is a compiled clause of cond.
+type condClause struct {
+	// test is nil for the else clause
+	test value
+	// body is nil for a clause only with the test, which returns the value of the test
+	body code
+}
+
+func compileCond(n *node.Node, s *scope) code {
+	if len(n.Children) == 1 {
+		return errorCode("bad syntax: cond needs at least 1 argument, but got 0")
+	}
+
+	clauses := make([]condClause, len(n.Children)-1)
+	for i, branch := range n.Children[1:] {
+		if branch.Type != node.Branch || len(branch.Children) == 0 {
+			return errorCode("bad syntax: cond bad branch")
+		}
+
+		test := branch.Children[0]
+		if test.Type == node.Keyword && test.Str == "else" {
+			if len(branch.Children) == 1 {
+				return errorCode("bad syntax: cond else branch needs at least 1 expression")
+			}
+		} else {
+			clauses[i].test = compileValue(test, s)
+		}
+		if len(branch.Children) > 1 {
+			clauses[i].body = compileBody(branch.Children[1:], s)
+		}
+	}
+
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		for _, clause := range clauses {
+			var res object.Object
+			if clause.test != nil {
+				if res = clause.test(ctx, env); !res.IsTruthy() {
+					continue
+				}
+			}
+			if clause.body == nil {
+				// (cond (test)) returns the value of test
+				return res, nil, nil
+			}
+			return clause.body(ctx, env)
+		}
+		// no cond match
+		return object.VoidObj, nil, nil
+	}
+}
+
+func compileSet(n *node.Node, s *scope) code {
+	if len(n.Children) != 3 {
+		return errorCode("set! exactly needs 2 arguments, but got %v", len(n.Children)-1)
+	}
+	if n.Children[1].Type != node.Identifier {
+		return errorCode("1st argument of set! needs to be identifier, but got %v", n.Children[1].Type)
+	}
+	key := n.Children[1].Str
+	v := compileValue(n.Children[2], s)
+	depth, index, _, ok := s.resolve(key)
+	if !ok {
+		sym := node.Intern(key)
+		return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+			if ok := env.SetGlobal(sym, v(ctx, env)); !ok {
+				return object.NewErrorObject(fmt.Sprintf("set!: %v is not defined yet", key)), nil, nil
+			}
+			return object.VoidObj, nil, nil
+		}
+	}
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		obj := v(ctx, env)
+		if env.LoadSlot(depth, index) == nil {
+			return object.NewErrorObject(fmt.Sprintf("set!: %v is not defined yet", key)), nil, nil
+		}
+		env.StoreSlot(depth, index, obj)
+		return object.VoidObj, nil, nil
+	}
+}
+
+func compileQuote(n *node.Node) code {
+	if len(n.Children) != 2 {
+		return errorCode("quote needs exactly 1 argument, but got %v", len(n.Children)-1)
+	}
+	obj := evalQuote(n.Children[1])
+	return func(_ context.Context, _ *object.Env) (object.Object, code, *object.Env) {
+		return obj, nil, nil
+	}
+}
+
+func evalQuote(n *node.Node) object.Object {
+	var q quoter
+	return q.quote(n)
+}
+
+// quoter converts quoted nodes to objects, keeping track of the objects labelled by datum labels.
+type quoter struct {
+	labels map[string]object.Object
+}
+
+func (q *quoter) quote(n *node.Node) object.Object {
+	if obj, ok := constant(n); ok {
+		return obj
+	}
+	switch n.Type {
+	case node.Identifier:
+		return object.NewSymbolObjectOf(n.Symbol())
+	case node.Keyword:
+		return object.NewSymbolObject(n.Str)
+	case node.Branch:
+		return q.list(n.Children)
+	case node.DatumLabel:
+		return q.label(n)
+	case node.DatumReference:
+		if obj, ok := q.labels[n.Str]; ok {
+			return obj
+		}
+		return object.NewErrorObject(fmt.Sprintf("quote: undefined datum label: %v", n))
+	}
+	return object.NewErrorObject(fmt.Sprintf("quote: node type not implemented: %v", n.Type))
+}
+
+// list converts the elements of a branch to a list, where the elements may end with ". cdr".
+func (q *quoter) list(children []*node.Node) object.Object {
+	if len(children) == 0 {
+		return object.NullObj
+	}
+	pair := object.NewConsObject(nil, nil)
+	q.fill(pair, children)
+	return pair
+}
+
+// fill sets the car and cdr of the pair from the non-empty elements of a branch.
+func (q *quoter) fill(pair object.Object, children []*node.Node) {
+	p := pair.Pair()
+	p[0] = q.quote(children[0])
+	if rest := children[1:]; len(rest) == 2 && rest[0].Type == node.Keyword && rest[0].Str == "." {
+		p[1] = q.quote(rest[1])
+	} else {
+		p[1] = q.list(rest)
+	}
+}
+
+// label converts the labelled datum. A pair is labelled before its elements are converted,
+// so that the references in them make shared or circular structure.
+func (q *quoter) label(n *node.Node) object.Object {
+	if q.labels == nil {
+		q.labels = make(map[string]object.Object)
+	}
+	// a datum may have multiple labels, as in #0=#1=(a)
+	labels := []string{n.Str}
+	datum := n.Children[0]
+	for datum.Type == node.DatumLabel {
+		labels = append(labels, datum.Str)
+		datum = datum.Children[0]
+	}
+	if datum.Type == node.Branch && len(datum.Children) > 0 {
+		pair := object.NewConsObject(nil, nil)
+		for _, label := range labels {
+			q.labels[label] = pair
+		}
+		q.fill(pair, datum.Children)
+		return pair
+	}
+	obj := q.quote(datum)
+	for _, label := range labels {
+		q.labels[label] = obj
+	}
+	return obj
+}
+
+// desugarDefine rewrites the function definition to the normal define of a lambda.
+// Returns n as is if not a function definition.
+func desugarDefine(n *node.Node) (*node.Node, error) {
+	// define syntax sugar
+	// (define (func-name arg1 arg2) ...)
+	// = (define func-name (lambda (arg1 arg2) ...))
+	if len(n.Children) < 2 || n.Children[1].Type != node.Branch {
+		return n, nil
+	}
+	if len(n.Children[1].Children) == 0 || n.Children[1].Children[0].Type != node.Identifier {
+		return nil, errors.New("bad syntax: function definition requires function name")
+	}
+
+	funcName := n.Children[1].Children[0]
+	argNames := n.Children[1].Children[1:]
+	sentences := n.Children[2:]
+
+	// specific define syntax sugar for variadic arguments function
+	// (define (func-name . x) ...)
+	// = (define func-name (lambda x ...))
+	var args *node.Node
+	if len(argNames) == 2 &&
+		argNames[0].Type == node.Keyword && argNames[0].Str == "." &&
+		argNames[1].Type == node.Identifier {
+		args = argNames[1]
+	} else {
+		args = &node.Node{Type: node.Branch, Children: argNames}
+	}
+
+	// Rewrite AST
+	lambda := append([]*node.Node{
+		{Type: node.Keyword, Str: "lambda"},
+		args,
+	}, sentences...)
+	return &node.Node{Type: node.Branch, Children: []*node.Node{
+		{Type: node.Keyword, Str: "define"},
+		funcName,
+		{Type: node.Branch, Children: lambda},
+	}}, nil
+}
+
+func compileDefine(n *node.Node, s *scope) code {
+	if len(n.Children) == 1 {
+		return errorCode("bad syntax: define takes exactly 2 arguments, but got 0")
+	}
+	n, err := desugarDefine(n)
+	if err != nil {
+		return errorCode("%v", err)
+	}
+
+	// Normal define
+	if len(n.Children) != 3 {
+		return errorCode("bad syntax: define takes exactly 2 arguments, but got %v", len(n.Children)-1)
+	}
+
+	if n.Children[1].Type != node.Identifier {
+		return errorCode("bad syntax: expected 1st argument of define to be identifier, but got %v", n.Children[1])
+	}
+
+	key := n.Children[1].Str
+	var v value
+	if isLambda(n.Children[2]) {
+		// named for the profiler
+		lambda := compileNamedLambda(n.Children[2], key, s)
+		v = func(ctx context.Context, env *object.Env) object.Object {
+			obj, _, _ := lambda(ctx, env)
+			return obj
+		}
+	} else {
+		v = compileValue(n.Children[2], s)
+	}
+	if s == nil {
+		return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+			env.Define(key, v(ctx, env))
+			return object.VoidObj, nil, nil
+		}
+	}
+	// internal defines have their slots in the scope of the body
+	index := s.index(key)
+	if index < 0 {
+		return errorCode("bad syntax: define of %v not allowed here", key)
+	}
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		env.StoreSlot(0, index, v(ctx, env))
+		return object.VoidObj, nil, nil
+	}
+}
+
+// signature is the arguments of a lambda, bound to the first slots of the scope of its body.
+type signature struct {
+	// params is the number of the required arguments
+	params int
+	// variadic is true if the rest arguments are bound as a list, to the slot next to the required arguments
+	variadic bool
+	// s is the scope of the body
+	s *scope
+	// name is the name of the function defined by define, or empty if anonymous
+	name string
+	// lambda is the lambda form, to make the function again from the source when restoring a snapshot
+	lambda *node.Node
+}
+
+// isLambda returns true if n is a lambda form.
+func isLambda(n *node.Node) bool {
+	return n.Type == node.Branch && len(n.Children) > 0 &&
+		n.Children[0].Type == node.Keyword && n.Children[0].Str == "lambda"
+}
+
+// newSignature parses the arguments of the lambda n of the given name, and makes the scope of its body.
+func newSignature(n *node.Node, name string, s *scope) (signature, error) {
+	if len(n.Children) < 3 {
+		return signature{}, fmt.Errorf("bad syntax: lambda takes 2 or more arguments, but got %v", len(n.Children)-1)
+	}
+
+	var params []string
+	var rest string
+	if n.Children[1].Type == node.Identifier {
+		// Variadic length arguments
+		// (lambda x ...)
+		rest = n.Children[1].Str
+	} else if n.Children[1].Type != node.Branch {
+		return signature{}, fmt.Errorf("bad syntax: 1st argument of lambda needs to be a list of arguments, but got %v", n.Children[1])
+	} else {
+		inputArgs := n.Children[1].Children
+		// Variadic length arguments with leading arguments
+		// (lambda (x y . z) ...)
+		if len(inputArgs) >= 3 &&
+			inputArgs[len(inputArgs)-2].Type == node.Keyword &&
+			inputArgs[len(inputArgs)-2].Str == "." &&
+			inputArgs[len(inputArgs)-1].Type == node.Identifier {
+			rest = inputArgs[len(inputArgs)-1].Str
+			inputArgs = inputArgs[:len(inputArgs)-2]
+		}
+
+		params = make([]string, len(inputArgs))
+		for i, arg := range inputArgs {
+			if arg.Type != node.Identifier {
+				return signature{}, fmt.Errorf("bad syntax: expected %v-th argument of lambda function to be identifier, but got %v", i, arg.Type)
+			}
+			params[i] = arg.Str
+		}
+	}
+
+	names := params
+	if rest != "" {
+		names = append(names, rest)
+	}
+	return signature{params: len(params), variadic: rest != "", s: newScope(names, n.Children[2:], s), name: name, lambda: n}, nil
+}
+
+// bind returns the new environment on top of env to evaluate the body with, binding the arguments.
+// If owned is true, objects may be used as the slots of the environment.
+func (sig signature) bind(ctx context.Context, env *object.Env, objects []object.Object, owned bool) (*object.Env, object.Object) {
+	if !sig.variadic {
+		if len(objects) != sig.params {
+			return nil, object.NewErrorObject(fmt.Sprintf("expected length of arguments to be %v, but got %v", sig.params, len(objects)))
+		}
+		if owned && len(objects) == len(sig.s.names) {
+			return env.NewEnv(sig.s.names, objects), nil
+		}
+		slots := sig.s.slots()
+		copy(slots, objects)
+		return env.NewEnv(sig.s.names, slots), nil
+	}
+
+	if len(objects) < sig.params {
+		return nil, object.NewErrorObject(fmt.Sprintf("expected length of arguments to be greater than or equal to %v, but got %v", sig.params, len(objects)))
+	}
+	if errObj := allocate(ctx, len(objects)-sig.params, 0); errObj != nil {
+		return nil, errObj
+	}
+	slots := sig.s.slots()
+	copy(slots, objects[:sig.params])
+	slots[sig.params] = list(objects[sig.params:])
+	return env.NewEnv(sig.s.names, slots), nil
+}
+
+// closure is a function made by lambda.
+// Calls to closures, including those through apply, are evaluated directly by the compiled code of applications,
+// while other builtins call them through F.
+type closure struct {
+	object.Object
+	signature
+	body code
+	env  *object.Env
+}
+
+func newClosure(sig signature, body code, env *object.Env) *closure {
+	c := &closure{signature: sig, body: body, env: env}
+	c.Object = object.NewFunctionObject(func(ctx context.Context, objects []object.Object) (object.Object, *node.Node, *object.Env) {
+		newEnv, errObj := c.bind(ctx, c.env, objects, false)
+		if errObj != nil {
+			return errObj, nil, nil
+		}
+		if profiling() {
+			t := profileTrackOf(ctx)
+			defer t.set(t.call(c.name))
+		}
+		return evalCode(ctx, c.body, newEnv), nil, nil
+	})
+	return c
+}
+
+func compileLambda(n *node.Node, s *scope) code {
+	return compileNamedLambda(n, "", s)
+}
+
+// compileNamedLambda compiles the lambda of the function defined as name, so that the profiler can tell calls to it.
+func compileNamedLambda(n *node.Node, name string, s *scope) code {
+	sig, err := newSignature(n, name, s)
+	if err != nil {
+		return errorCode("%v", err)
+	}
+	body := compileBody(n.Children[2:], sig.s)
+	return func(_ context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return newClosure(sig, body, env), nil, nil
+	}
+}
+
+func compileBegin(n *node.Node, s *scope) code {
+	if len(n.Children) <= 1 {
+		return errorCode("begin needs at least 1 argument, but got 0")
+	}
+	return compileBody(n.Children[1:], s)
+}
+
+func compileMacro(n *node.Node) code {
+	m, err := macro.NewMacro(n)
+	if err != nil {
+		return errorCode("bad macro syntax: %v", err)
+	}
+	return func(_ context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		env.DefineGlobalMacro(m)
+		return object.VoidObj, nil, nil
+	}
+}
+
+func compileDelay(n *node.Node, s *scope) code {
+	if len(n.Children) != 2 {
+		return errorCode("delay needs exactly 1 argument, but got %v", len(n.Children)-1)
+	}
+	toDelay := compile(n.Children[1], s)
+	return func(_ context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+			return evalCode(ctx, toDelay, env)
+		}), nil, nil
+	}
+}
+
+func compileDelayForce(n *node.Node, s *scope) code {
+	if len(n.Children) != 2 {
+		return errorCode("delay-force needs exactly 1 argument, but got %v", len(n.Children)-1)
+	}
+	toDelay := compile(n.Children[1], s)
+	return func(_ context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return object.NewGoDelayForceObject(func(ctx context.Context) object.Object {
+			return evalCode(ctx, toDelay, env)
+		}), nil, nil
+	}
+}
+
+func compileStreamCons(n *node.Node, s *scope) code {
+	if len(n.Children) != 3 {
+		return errorCode("stream-cons needs exactly 2 arguments, but got %v", len(n.Children)-1)
+	}
+	car := compile(n.Children[1], s)
+	cdr := compile(n.Children[2], s)
+	return func(_ context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return object.NewStreamPairObject(object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+			return evalCode(ctx, car, env)
+		}), object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+			return evalCode(ctx, cdr, env)
+		})), nil, nil
+	}
+}
+
+func compileParameterize(n *node.Node, s *scope) code {
+	if len(n.Children) <= 2 {
+		return errorCode("bad syntax: parameterize needs at least 2 arguments, but got %v", len(n.Children)-1)
+	}
+
+	pairs := n.Children[1]
+	if pairs.Type != node.Branch {
+		return errorCode("bad syntax: 1st argument of parameterize needs to be a list of bind pairs, but got %v", pairs)
+	}
+	paramValues := make([]value, len(pairs.Children))
+	values := make([]value, len(pairs.Children))
+	for i, pair := range pairs.Children {
+		if len(pair.Children) != 2 {
+			return errorCode("bad syntax: parameterize bind pair needs a list of length 2, but got length %v", len(pair.Children))
+		}
+		paramValues[i] = compileValue(pair.Children[0], s)
+		values[i] = compileValue(pair.Children[1], s)
+	}
+	sentences := compileValues(n.Children[2:], s)
+
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		params := make([]*object.Parameter, len(paramValues))
+		objects := make([]object.Object, len(values))
+		for i := range paramValues {
+			p := paramValues[i](ctx, env)
+			param, ok := p.(*object.Parameter)
+			if !ok {
+				return object.NewErrorObject(fmt.Sprintf("parameterize: expected parameter object, but got %v", p)), nil, nil
+			}
+			obj := values[i](ctx, env)
+			if converter := param.Converter(); converter != nil {
+				obj = callWithTailOptimization(ctx, converter.F, []object.Object{obj})
+			}
+			params[i], objects[i] = param, obj
+		}
+
+		ctx = object.WithParameterValues(ctx, params, objects)
+		var res object.Object
+		for _, sentence := range sentences {
+			res = sentence(ctx, env)
+		}
+		return res, nil, nil
+	}
+}
+
+func compileApplication(n *node.Node, s *scope) code {
+	values := compileValues(n.Children, s)
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		objects := make([]object.Object, len(values))
+		for idx, v := range values {
+			objects[idx] = v(ctx, env)
+			if objects[idx].Type() == object_type.Err {
+				// stop at once, rather than wrapping the error at each level of deep recursion
+				if errObj := limitError(ctx); errObj != nil {
+					return errObj, nil, nil
+				}
+			}
+		}
+		fn, args, errObj := unwrapApply(objects[0], objects[1:])
+		if errObj != nil {
+			return errObj, nil, nil
+		}
+		if c, ok := fn.(*closure); ok {
+			newEnv, errObj := c.bind(ctx, c.env, args, true)
+			if errObj != nil {
+				return errObj, nil, nil
+			}
+			if profiling() {
+				// restored by evalCode evaluating the application
+				profileTrackOf(ctx).call(c.name)
+			}
+			return nil, c.body, newEnv
+		}
+		if fn.Type() != object_type.Function {
+			return object.NewErrorObject(fmt.Sprintf("expected function in 0-th argument, but got %v", fn)), nil, nil
+		}
+		res, next, newEnv := apply(ctx, n, fn, args)
+		if res != nil {
+			return res, nil, nil
+		}
+		c, newEnv := compileIn(next, newEnv)
+		return nil, c, newEnv
+	}
+}
+
+// compileIn compiles the node to be evaluated in the environment not known at compile time,
+// such as the environment of a continuation returned by a function.
+// Variables are resolved by the names of the slots of the environment, and internal defines
+// of the node are made in a new environment, which is returned with the code.
+func compileIn(n *node.Node, env *object.Env) (code, *object.Env) {
+	s := scopeOf(env)
+	if s != nil && len(definedNames(n, nil)) > 0 {
+		s = newScope(nil, []*node.Node{n}, s)
+		env = env.NewEnv(s.names, s.slots())
+	}
+	return compile(n, s), env
+}
+
+// compile compiles the node into code, which can be evaluated any number of times in environments of the scope.
+// Errors in the syntax of special forms are reported when the code is evaluated, rather than when compiled.
+func compile(n *node.Node, s *scope) code {
+	// Base cases
+	if obj, ok := constant(n); ok {
+		return func(_ context.Context, _ *object.Env) (object.Object, code, *object.Env) {
+			return obj, nil, nil
+		}
+	}
+	switch n.Type {
+	case node.Keyword:
+		return errorCode("unexpected keyword")
+	case node.Identifier:
+		v := compileVariable(n.Str, s)
+		return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+			return v(ctx, env), nil, nil
+		}
+	}
+	if n.Type != node.Branch {
+		return errorCode("node type not implemented: %v", n.Type)
+	}
+
+	// assert n.Type == node.Branch
+	if len(n.Children) == 0 {
+		return errorCode("bad syntax: empty sentence (\"()\") cannot be evaluated")
+	}
+
+	// Special forms
+	if n.Children[0].Type == node.Keyword {
+		switch n.Children[0].Str {
+		case "and":
+			return compileAnd(n, s)
+		case "or":
+			return compileOr(n, s)
+		case "if":
+			return compileIf(n, s)
+		case "let":
+			return compileLet(n, s)
+		case "let*":
+			return compileLetSeq(n, s)
+		case "cond":
+			return compileCond(n, s)
+		case "set!":
+			return compileSet(n, s)
+		case "quote":
+			return compileQuote(n)
+		case "define":
+			return compileDefine(n, s)
+		case "lambda":
+			return compileLambda(n, s)
+		case "begin":
+			// begin is not technically special form, but for tail optimization
+			return compileBegin(n, s)
+		case "define-syntax":
+			return compileMacro(n)
+		case "delay":
+			return compileDelay(n, s)
+		case "delay-force":
+			return compileDelayForce(n, s)
+		case "stream-cons":
+			return compileStreamCons(n, s)
+		case "parameterize":
+			return compileParameterize(n, s)
+		case "select":
+			return compileSelect(n, s)
+		case "profile":
+			return compileProfile(n, s)
+		case "define-library", "import":
+			return errorCode("bad syntax: %v is only allowed at top level", n.Children[0].Str)
+		}
+	}
+
+	// Function application
+	return compileApplication(n, s)
+}
@@ -1,29 +1,34 @@
 package lisp
 
 import (
+	"context"
 	"fmt"
 	"github.com/motoki317/lisp-interpreter/lisp/object"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 	"math"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"unicode/utf8"
 )
 
-var defaultEnv map[string]object.Object
+var defaultEnv = make(map[string]object.Object)
 
-func init() {
-	defaultEnv = make(map[string]object.Object)
+// gensymCounter is the counter of the symbols made by gensym, to give them unique names.
+var gensymCounter int64
 
+func init() {
 	defaultEnv["+"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			var res float64
 			for _, in := range input {
 				res += in
 			}
 			return object.NewNumberObject(res)
-		}))
+		})))
 	defaultEnv["-"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			if len(input) == 0 {
 				return object.NewErrorObject("expected at least one argument")
 			}
@@ -32,17 +37,17 @@ func init() {
 				res -= in
 			}
 			return object.NewNumberObject(res)
-		}))
+		})))
 	defaultEnv["*"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			var res float64 = 1
 			for _, in := range input {
 				res *= in
 			}
 			return object.NewNumberObject(res)
-		}))
+		})))
 	defaultEnv["/"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			if len(input) == 0 {
 				return object.NewErrorObject("expected at least one argument")
 			}
@@ -54,7 +59,7 @@ func init() {
 				res /= in
 			}
 			return object.NewNumberObject(res)
-		}))
+		})))
 
 	defaultEnv[">"] = object.NewWrappedFunctionObject(
 		makeBinary(makeNumbers(func(input []float64) object.Object {
@@ -78,7 +83,7 @@ func init() {
 		})))
 
 	defaultEnv["max"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			if len(input) == 0 {
 				return object.NewErrorObject("max: expected at least one input")
 			}
@@ -89,9 +94,9 @@ func init() {
 				}
 			}
 			return object.NewNumberObject(max)
-		}))
+		})))
 	defaultEnv["min"] = object.NewWrappedFunctionObject(
-		makeNumbers(func(input []float64) object.Object {
+		makeInexact(makeNumbers(func(input []float64) object.Object {
 			if len(input) == 0 {
 				return object.NewErrorObject("min: expected at least one input")
 			}
@@ -102,7 +107,7 @@ func init() {
 				}
 			}
 			return object.NewNumberObject(min)
-		}))
+		})))
 
 	defaultEnv["zero?"] = object.NewWrappedFunctionObject(
 		makeUnary(makeNumbers(func(input []float64) object.Object {
@@ -117,8 +122,13 @@ func init() {
 			return object.NewBooleanObject(input[0] == math.Trunc(input[0]) && int64(input[0])%2 == 1)
 		})))
 
+	// modulo truncates the operands to integers, and the result has the sign of the dividend.
+	// Use floor-remainder for the modulo of R7RS.
 	defaultEnv["modulo"] = object.NewWrappedFunctionObject(
 		makeBinary(makeNumbers(func(input []float64) object.Object {
+			if int64(input[1]) == 0 {
+				return object.NewErrorObject("division by 0")
+			}
 			return object.NewNumberObject(float64(int64(input[0]) % int64(input[1])))
 		})))
 
@@ -128,25 +138,23 @@ func init() {
 			return object.NewBooleanObject(!booleans[0])
 		})))
 
-	defaultEnv["sqrt"] = object.NewWrappedFunctionObject(
-		makeUnary(makeNumbers(func(input []float64) object.Object {
-			return object.NewNumberObject(math.Sqrt(input[0]))
-		})))
-
 	defaultEnv["cons"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(ctx context.Context, objects []object.Object) object.Object {
+			if errObj := allocate(ctx, 1, 0); errObj != nil {
+				return errObj
+			}
 			return object.NewConsObject(objects[0], objects[1])
 		}))
-	defaultEnv["list"] = object.NewWrappedFunctionObject(list)
+	defaultEnv["list"] = object.NewWrappedFunctionObject(allocateList)
 
-	car := makeUnary(func(objects []object.Object) object.Object {
+	car := makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 		o := objects[0]
 		if o.Type() != object_type.Cons {
 			return object.NewErrorObject(fmt.Sprintf("car: expected cons but got %v", o.Type()))
 		}
 		return o.Pair()[0]
 	})
-	cdr := makeUnary(func(objects []object.Object) object.Object {
+	cdr := makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 		o := objects[0]
 		if o.Type() != object_type.Cons {
 			return object.NewErrorObject(fmt.Sprintf("cdr: expected cons but got %v", o))
@@ -181,7 +189,7 @@ func init() {
 	}
 
 	defaultEnv["set-car!"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
 			pair := objects[0]
 			value := objects[1]
 			if pair.Type() != object_type.Cons {
@@ -191,7 +199,7 @@ func init() {
 			return object.VoidObj
 		}))
 	defaultEnv["set-cdr!"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
 			pair := objects[0]
 			value := objects[1]
 			if pair.Type() != object_type.Cons {
@@ -202,165 +210,262 @@ func init() {
 		}))
 
 	defaultEnv["equal?"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
 			o1, o2 := objects[0], objects[1]
 			return object.NewBooleanObject(o1.Equals(o2))
 		}))
 	defaultEnv["eq?"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
 			o1, o2 := objects[0], objects[1]
-			return object.NewBooleanObject(o1.Equals(o2))
+			return object.NewBooleanObject(object.Eq(o1, o2))
 		}))
 	defaultEnv["eqv?"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
 			o1, o2 := objects[0], objects[1]
-			return object.NewBooleanObject(o1.Equals(o2))
+			return object.NewBooleanObject(object.Eqv(o1, o2))
 		}))
 	defaultEnv["number?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].Type() == object_type.Number)
 		}))
 	defaultEnv["boolean?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].Type() == object_type.Boolean)
 		}))
 	defaultEnv["symbol?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].Type() == object_type.Symbol)
 		}))
 	defaultEnv["list?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].IsList())
 		}))
 	defaultEnv["null?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].Type() == object_type.Null)
 		}))
 	defaultEnv["string?"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
 			return object.NewBooleanObject(objects[0].Type() == object_type.Str)
 		}))
 
-	defaultEnv["apply"] = object.NewFunctionObject(func(objects []object.Object) (object.Object, *node.Node, *object.Env) {
-		if len(objects) != 2 {
-			return object.NewErrorObject(fmt.Sprintf("expected argument length to be 2, but got %v", len(objects))), nil, nil
+	defaultEnv["char?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Char)
+		}))
+	defaultEnv["char->integer"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			o := objects[0]
+			if o.Type() != object_type.Char {
+				return object.NewErrorObject(fmt.Sprintf("expected 1st argument of char->integer to be char, but got %v", o.Type()))
+			}
+			return object.NewNumberObject(float64([]rune(o.Str())[0]))
+		}))
+	defaultEnv["integer->char"] = object.NewWrappedFunctionObject(
+		makeUnary(makeIntegers(func(input []int64) object.Object {
+			if !utf8.ValidRune(rune(input[0])) {
+				return object.NewErrorObject(fmt.Sprintf("integer->char: invalid code point %v", input[0]))
+			}
+			return object.NewCharObject(rune(input[0]))
+		})))
+
+	defaultEnv["make-parameter"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (make-parameter value [converter])
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("make-parameter: expected 1 or 2 arguments, but got %v", len(objects)))
 		}
-		f := objects[0]
-		args := objects[1]
-		if f.Type() != object_type.Function {
-			return object.NewErrorObject(fmt.Sprintf("expected 1st argument of apply to be a function, but got %v", f)), nil, nil
+		value := objects[0]
+		if len(objects) == 1 {
+			return object.NewParameterObject(value, nil)
 		}
-		if !args.IsList() {
-			return object.NewErrorObject(fmt.Sprintf("expected 2nd argument of apply to be a list, but got %v", args)), nil, nil
+		converter := objects[1]
+		if converter.Type() != object_type.Function {
+			return object.NewErrorObject(fmt.Sprintf("make-parameter: expected converter to be a function, but got %v", converter))
 		}
-		return f.F(args.ListElements())
+		return object.NewParameterObject(callWithTailOptimization(ctx, converter.F, []object.Object{value}), converter)
 	})
-	defaultEnv["map"] = object.NewWrappedFunctionObject(
-		makeBinary(func(objects []object.Object) object.Object {
-			f := objects[0]
-			lst := objects[1]
-			if f.Type() != object_type.Function {
-				return object.NewErrorObject(fmt.Sprintf("expected 1st argument of map to be a function, but got %v", f))
-			}
-			if !lst.IsList() {
-				return object.NewErrorObject(fmt.Sprintf("expected 2nd argument of map to be a list, but got %v", lst))
-			}
-			elements := lst.ListElements()
-			for i, elt := range elements {
-				elements[i] = callWithTailOptimization(f.F, []object.Object{elt})
+
+	defaultEnv["apply"] = object.NewTailApplyFunctionObject(applyArgs)
+	defaultEnv["map"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (map f list1 list2 ...)
+		f, lists, errObj := functionAndLists("map", objects)
+		if errObj != nil {
+			return errObj
+		}
+		res := make([]object.Object, 0)
+		for _, args := range zipLists(lists) {
+			elt := callWithTailOptimization(ctx, f.F, args)
+			if elt.Type() == object_type.Err {
+				return elt
 			}
-			return list(elements)
-		}))
+			res = append(res, elt)
+		}
+		return allocateList(ctx, res)
+	})
 
-	defaultEnv["force"] = object.NewFunctionObject(func(objects []object.Object) (object.Object, *node.Node, *object.Env) {
+	defaultEnv["force"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
 		if len(objects) != 1 {
-			return object.NewErrorObject(fmt.Sprintf("force needs exactly 1 argument, but got %v", len(objects))), nil, nil
+			return object.NewErrorObject(fmt.Sprintf("force needs exactly 1 argument, but got %v", len(objects)))
 		}
 		o := objects[0]
 		if o.Type() != object_type.Promise {
-			return object.NewErrorObject(fmt.Sprintf("force takes promise object as argument, but got %v", o.Type())), nil, nil
+			return object.NewErrorObject(fmt.Sprintf("force takes promise object as argument, but got %v", o.Type()))
 		}
-		return o.F(nil)
+		return force(ctx, o)
 	})
+	defaultEnv["make-promise"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			o := objects[0]
+			if o.Type() == object_type.Promise {
+				return o
+			}
+			return object.NewForcedPromiseObject(o)
+		}))
+	defaultEnv["promise?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Promise)
+		}))
 
 	defaultEnv["symbol->string"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
 			o := objects[0]
 			if o.Type() != object_type.Symbol {
 				return object.NewErrorObject(fmt.Sprintf("expected 1st argument of symbol->string to be symbol, but got %v", o.Type()))
 			}
-			return object.NewStringObject(o.Str())
+			return allocateString(ctx, o.Str())
 		}))
 	defaultEnv["string->symbol"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
 			o := objects[0]
 			if o.Type() != object_type.Str {
 				return object.NewErrorObject(fmt.Sprintf("expected 1st argument of string->symbol to be string, but got %v", o.Type()))
 			}
-			return object.NewSymbolObject(o.Str())
-		}))
-	defaultEnv["string-append"] = object.NewWrappedFunctionObject(
-		makeStrings(func(input []string) object.Object {
-			return object.NewStringObject(strings.Join(input, ""))
+			return allocateSymbol(ctx, o.Str())
 		}))
+	gensym := func(_ context.Context, objects []object.Object) object.Object {
+		// (gensym [prefix])
+		if len(objects) > 1 {
+			return object.NewErrorObject(fmt.Sprintf("gensym: expected 0 or 1 arguments, but got %v", len(objects)))
+		}
+		prefix := "g"
+		if len(objects) == 1 {
+			o := objects[0]
+			if o.Type() != object_type.Str && o.Type() != object_type.Symbol {
+				return object.NewErrorObject(fmt.Sprintf("gensym: expected 1st argument to be string or symbol, but got %v", o))
+			}
+			prefix = o.Str()
+		}
+		return object.NewUninternedSymbolObject(prefix + strconv.FormatInt(atomic.AddInt64(&gensymCounter, 1), 10))
+	}
+	defaultEnv["gensym"] = object.NewWrappedFunctionObject(gensym)
+	defaultEnv["generate-uninterned-symbol"] = object.NewWrappedFunctionObject(gensym)
+	defaultEnv["string-append"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		return makeStrings(func(input []string) object.Object {
+			return allocateString(ctx, strings.Join(input, ""))
+		})(ctx, objects)
+	})
 }
 
-type generalFunc func(objects []object.Object) object.Object
+// applyArgs returns the function and the arguments to call it with, of (apply f arg1 ... args).
+func applyArgs(objects []object.Object) (object.Object, []object.Object, object.Object) {
+	if len(objects) < 2 {
+		return nil, nil, object.NewErrorObject(fmt.Sprintf("expected argument length to be at least 2, but got %v", len(objects)))
+	}
+	f := objects[0]
+	lst := objects[len(objects)-1]
+	if f.Type() != object_type.Function {
+		return nil, nil, object.NewErrorObject(fmt.Sprintf("expected 1st argument of apply to be a function, but got %v", f))
+	}
+	if !lst.IsList() {
+		return nil, nil, object.NewErrorObject(fmt.Sprintf("expected last argument of apply to be a list, but got %v", lst))
+	}
+	args := make([]object.Object, 0, len(objects)-2)
+	args = append(args, objects[1:len(objects)-1]...)
+	return f, append(args, lst.ListElements()...), nil
+}
 
-func callWithTailOptimization(f func(objects []object.Object) (object.Object, *node.Node, *object.Env), objects []object.Object) object.Object {
-	obj, n, e := f(objects)
+// unwrapApply returns the function and the arguments finally called by calling fn with args,
+// unwrapping calls to tail-apply functions such as apply (see object.NewTailApplyFunctionObject),
+// so that the engines can evaluate calls to closures through them directly, keeping tail calls in the trampoline.
+func unwrapApply(fn object.Object, args []object.Object) (object.Object, []object.Object, object.Object) {
+	for resolve := object.TailApply(fn); resolve != nil; resolve = object.TailApply(fn) {
+		var errObj object.Object
+		if fn, args, errObj = resolve(args); errObj != nil {
+			return nil, nil, errObj
+		}
+	}
+	return fn, args, nil
+}
+
+type generalFunc func(ctx context.Context, objects []object.Object) object.Object
+
+// callWithTailOptimization calls the function f from a builtin, re-entering evaluation until the result is obtained.
+func callWithTailOptimization(ctx context.Context, f func(ctx context.Context, objects []object.Object) (object.Object, *node.Node, *object.Env), objects []object.Object) object.Object {
+	s, errObj := reenter(ctx)
+	if errObj != nil {
+		return errObj
+	}
+	defer s.leaveReentry()
+	obj, n, e := f(ctx, objects)
 	if obj != nil {
 		return obj
 	}
-	return evalWithTailOptimization(n, e)
+	return evalWithTailOptimization(ctx, n, e)
 }
 
-func list(objects []object.Object) object.Object {
-	if len(objects) == 0 {
-		return object.NullObj
+// force forces the given promise object, re-entering evaluation if the promise has not been forced yet.
+func force(ctx context.Context, p object.Object) object.Object {
+	s, errObj := reenter(ctx)
+	if errObj != nil {
+		return errObj
 	}
-	return object.NewConsObject(objects[0], list(objects[1:]))
+	defer s.leaveReentry()
+	return object.Force(ctx, p)
+}
+
+func list(objects []object.Object) object.Object {
+	return listWithTail(objects, object.NullObj)
 }
 
 // composeFuncs composes the given functions, applying from the LAST to the FIRST.
 func composeFuncs(funcs ...generalFunc) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(ctx context.Context, objects []object.Object) object.Object {
 		for i := len(funcs) - 1; i >= 0; i-- {
-			objects = []object.Object{funcs[i](objects)}
+			objects = []object.Object{funcs[i](ctx, objects)}
 		}
 		return objects[0]
 	}
 }
 
 func makeNullary(next generalFunc) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(ctx context.Context, objects []object.Object) object.Object {
 		if len(objects) != 0 {
 			return object.NewErrorObject(fmt.Sprintf("expected length of argument to be 0, but got %v", len(objects)))
 		}
-		return next(objects)
+		return next(ctx, objects)
 	}
 }
 
 func makeUnary(next generalFunc) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(ctx context.Context, objects []object.Object) object.Object {
 		if len(objects) != 1 {
 			return object.NewErrorObject(fmt.Sprintf("expected length of argument to be 1, but got %v", len(objects)))
 		}
-		return next(objects)
+		return next(ctx, objects)
 	}
 }
 
 func makeBinary(next generalFunc) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(ctx context.Context, objects []object.Object) object.Object {
 		if len(objects) != 2 {
 			return object.NewErrorObject(fmt.Sprintf("expected length of argument to be 2, but got %v", len(objects)))
 		}
-		return next(objects)
+		return next(ctx, objects)
 	}
 }
 
 func makeNumbers(next func(input []float64) object.Object) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(_ context.Context, objects []object.Object) object.Object {
 		nums := make([]float64, len(objects))
 		for i, obj := range objects {
 			if obj.Type() != object_type.Number {
@@ -373,8 +478,24 @@ func makeNumbers(next func(input []float64) object.Object) generalFunc {
 	}
 }
 
+// makeInexact makes the number resulted from next inexact, if any of the arguments is an inexact number.
+func makeInexact(next generalFunc) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		res := next(ctx, objects)
+		if res.Type() != object_type.Number || !object.IsExact(res) {
+			return res
+		}
+		for _, obj := range objects {
+			if obj.Type() == object_type.Number && !object.IsExact(obj) {
+				return object.NewInexactNumberObject(res.Number())
+			}
+		}
+		return res
+	}
+}
+
 func makeBooleans(next func(input []bool) object.Object) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(_ context.Context, objects []object.Object) object.Object {
 		booleans := make([]bool, len(objects))
 		for i, obj := range objects {
 			if obj.Type() != object_type.Boolean {
@@ -388,7 +509,7 @@ func makeBooleans(next func(input []bool) object.Object) generalFunc {
 }
 
 func makeStrings(next func(input []string) object.Object) generalFunc {
-	return func(objects []object.Object) object.Object {
+	return func(_ context.Context, objects []object.Object) object.Object {
 		inputs := make([]string, len(objects))
 		for i, obj := range objects {
 			if obj.Type() != object_type.Str {
@@ -0,0 +1,341 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Define defines a global variable of the given name, overriding any existing definition.
+func (i *Interpreter) Define(name string, value object.Object) {
+	i.globalEnv.Define(name, value)
+}
+
+// Lookup returns the value of the global variable of the given name.
+func (i *Interpreter) Lookup(name string) (object.Object, bool) {
+	return i.globalEnv.Lookup(name)
+}
+
+// RegisterFunc defines a Go function as a global Lisp function of the given name.
+// Arguments are converted to the parameter types of fn as in ToGoValue, and return values are converted as in FromGo.
+// fn can return no values, a single value, or a value and an error. A non-nil error is returned to the Lisp program
+// as an error object.
+func (i *Interpreter) RegisterFunc(name string, fn interface{}) error {
+	f, err := goFunc(name, reflect.ValueOf(fn))
+	if err != nil {
+		return err
+	}
+	i.Define(name, f)
+	return nil
+}
+
+// FromGo converts a Go value to an object.
+//
+// Booleans, numbers and strings are converted to the corresponding objects, slices and arrays to lists,
+// maps to association lists sorted by keys, pointers to the objects of the values they point to,
+// and functions to Lisp functions as in Interpreter.RegisterFunc. nil is converted to the empty list,
+// and objects are returned as they are.
+func FromGo(v interface{}) (object.Object, error) {
+	if v == nil {
+		return object.NullObj, nil
+	}
+	return fromGoValue(reflect.ValueOf(v))
+}
+
+func fromGoValue(v reflect.Value) (object.Object, error) {
+	if v.Type().Implements(objectType) && v.Kind() != reflect.Interface {
+		return v.Interface().(object.Object), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return object.NewBooleanObject(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return object.NewNumberObject(float64(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return object.NewNumberObject(float64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return object.NewNumberObject(v.Float()), nil
+	case reflect.String:
+		return object.NewStringObject(v.String()), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return object.NullObj, nil
+		}
+		elements := make([]object.Object, v.Len())
+		for idx := range elements {
+			o, err := fromGoValue(v.Index(idx))
+			if err != nil {
+				return nil, err
+			}
+			elements[idx] = o
+		}
+		return list(elements), nil
+	case reflect.Map:
+		return fromGoMap(v)
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return object.NullObj, nil
+		}
+		return fromGoValue(v.Elem())
+	case reflect.Func:
+		if v.IsNil() {
+			return object.NullObj, nil
+		}
+		return goFunc("go function", v)
+	default:
+		return nil, fmt.Errorf("cannot convert Go value of type %v to object", v.Type())
+	}
+}
+
+// fromGoMap converts a map to an association list, sorted by the written form of the keys.
+func fromGoMap(v reflect.Value) (object.Object, error) {
+	type entry struct {
+		key, value object.Object
+		written    string
+	}
+	entries := make([]entry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := fromGoValue(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := fromGoValue(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{key: key, value: value, written: key.String()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].written < entries[j].written
+	})
+	pairs := make([]object.Object, len(entries))
+	for idx, e := range entries {
+		pairs[idx] = object.NewConsObject(e.key, e.value)
+	}
+	return list(pairs), nil
+}
+
+// ToGo converts an object to a Go value.
+//
+// Numbers are converted to float64, booleans to bool, strings and symbols to string, characters to rune,
+// proper lists to []interface{}, and the void object to nil. Other objects such as functions cannot be converted.
+// Use ToGoValue to convert to a specific type, such as maps.
+func ToGo(o object.Object) (interface{}, error) {
+	switch o.Type() {
+	case object_type.Number:
+		return o.Number(), nil
+	case object_type.Boolean:
+		return o.Bool(), nil
+	case object_type.Str, object_type.Symbol:
+		return o.Str(), nil
+	case object_type.Char:
+		return []rune(o.Str())[0], nil
+	case object_type.Void:
+		return nil, nil
+	case object_type.Null, object_type.Cons:
+		if !o.IsList() {
+			return nil, fmt.Errorf("cannot convert improper or circular list %v to Go value", o)
+		}
+		elements := o.ListElements()
+		ret := make([]interface{}, len(elements))
+		for idx, e := range elements {
+			v, err := ToGo(e)
+			if err != nil {
+				return nil, err
+			}
+			ret[idx] = v
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %v to Go value", o)
+	}
+}
+
+// ToGoValue converts an object and stores the result in the value pointed to by ptr, like json.Unmarshal.
+//
+// Numbers can be stored to numeric types if representable, strings and symbols to string, lists to slices,
+// association lists to maps, and any object to object.Object. Values are stored to interface{} as in ToGo.
+func ToGoValue(o object.Object, ptr interface{}) error {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("ToGoValue: expected non-nil pointer")
+	}
+	converted, err := toGoValue(o, v.Elem().Type())
+	if err != nil {
+		return err
+	}
+	v.Elem().Set(converted)
+	return nil
+}
+
+func toGoValue(o object.Object, t reflect.Type) (reflect.Value, error) {
+	if t == objectType {
+		return reflect.ValueOf(&o).Elem(), nil
+	}
+	mismatch := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot convert %v to Go value of type %v", o, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return mismatch()
+		}
+		v, err := ToGo(o)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if v == nil {
+			return reflect.Zero(t), nil
+		}
+		return reflect.ValueOf(v), nil
+	case reflect.Bool:
+		if o.Type() != object_type.Boolean {
+			return mismatch()
+		}
+		return reflect.ValueOf(o.Bool()).Convert(t), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if o.Type() != object_type.Number {
+			return mismatch()
+		}
+		n, ok := toInteger(o.Number())
+		v := reflect.New(t).Elem()
+		if !ok || v.OverflowInt(n) {
+			return mismatch()
+		}
+		v.SetInt(n)
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if o.Type() != object_type.Number {
+			return mismatch()
+		}
+		n, ok := toInteger(o.Number())
+		v := reflect.New(t).Elem()
+		if !ok || n < 0 || v.OverflowUint(uint64(n)) {
+			return mismatch()
+		}
+		v.SetUint(uint64(n))
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		if o.Type() != object_type.Number {
+			return mismatch()
+		}
+		return reflect.ValueOf(o.Number()).Convert(t), nil
+	case reflect.String:
+		if o.Type() != object_type.Str && o.Type() != object_type.Symbol {
+			return mismatch()
+		}
+		return reflect.ValueOf(o.Str()).Convert(t), nil
+	case reflect.Slice:
+		if !o.IsList() {
+			return mismatch()
+		}
+		elements := o.ListElements()
+		v := reflect.MakeSlice(t, len(elements), len(elements))
+		for idx, e := range elements {
+			ev, err := toGoValue(e, t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.Index(idx).Set(ev)
+		}
+		return v, nil
+	case reflect.Map:
+		// association list
+		if !o.IsList() {
+			return mismatch()
+		}
+		v := reflect.MakeMap(t)
+		for _, e := range o.ListElements() {
+			if e.Type() != object_type.Cons {
+				return mismatch()
+			}
+			pair := e.Pair()
+			key, err := toGoValue(pair[0], t.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			value, err := toGoValue(pair[1], t.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v.SetMapIndex(key, value)
+		}
+		return v, nil
+	case reflect.Ptr:
+		elem, err := toGoValue(o, t.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	default:
+		return mismatch()
+	}
+}
+
+// goFunc wraps a Go function as a Lisp function, converting arguments and return values.
+func goFunc(name string, fn reflect.Value) (object.Object, error) {
+	if fn.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%v: expected a function, but got %v", name, fn.Type())
+	}
+	t := fn.Type()
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType:
+		return nil, fmt.Errorf("%v: function must return at most one value and optionally an error, but got %v", name, t)
+	}
+
+	return object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		numIn := t.NumIn()
+		if t.IsVariadic() {
+			if len(objects) < numIn-1 {
+				return object.NewErrorObject(fmt.Sprintf("%v: expected at least %v arguments, but got %v", name, numIn-1, len(objects)))
+			}
+		} else if len(objects) != numIn {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected %v arguments, but got %v", name, numIn, len(objects)))
+		}
+
+		args := make([]reflect.Value, len(objects))
+		for idx, o := range objects {
+			var argType reflect.Type
+			if t.IsVariadic() && idx >= numIn-1 {
+				argType = t.In(numIn - 1).Elem()
+			} else {
+				argType = t.In(idx)
+			}
+			arg, err := toGoValue(o, argType)
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("%v: %v-th argument: %v", name, idx, err))
+			}
+			args[idx] = arg
+		}
+
+		out := fn.Call(args)
+		if len(out) > 0 && out[len(out)-1].Type() == errorType {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return object.NewErrorObject(fmt.Sprintf("%v: %v", name, err.Interface()))
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return object.VoidObj
+		}
+		res, err := fromGoValue(out[0])
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("%v: %v", name, err))
+		}
+		return res
+	}), nil
+}
@@ -0,0 +1,247 @@
+package lisp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	inputs := []string{
+		"(http-status 404)",
+		"(http-status 1.5)",
+		"(sum)",
+		"(sum 1 2 3)",
+		"(sum 1 'po)",
+		"(checked-div 6 3)",
+		"(checked-div 1 0)",
+		"(counts '(\"a\" \"b\" \"a\"))",
+		"(keys '((b . 2) (a . 1)))",
+		"(identity (lambda (x) x))",
+		"answer",
+		"(http-status)",
+	}
+	outputs := []string{
+		"\"Not Found\"",
+		"error: http-status: 0-th argument: cannot convert 1.5 to Go value of type int",
+		"0",
+		"6",
+		"error: sum: 1-th argument: cannot convert po to Go value of type float64",
+		"2",
+		"error: checked-div: division by zero",
+		"((\"a\" . 2) (\"b\" . 1))",
+		"(\"a\" \"b\")",
+		"<function>",
+		"42",
+		"error: http-status: expected 1 arguments, but got 0",
+	}
+
+	out := &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(inputs, "\n")))), out, false, 0)
+	funcs := map[string]interface{}{
+		"http-status": func(code int) string {
+			if code == 404 {
+				return "Not Found"
+			}
+			return "OK"
+		},
+		"sum": func(xs ...float64) float64 {
+			sum := 0.0
+			for _, x := range xs {
+				sum += x
+			}
+			return sum
+		},
+		"checked-div": func(a, b int) (int, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"counts": func(words []string) map[string]int {
+			counts := make(map[string]int)
+			for _, w := range words {
+				counts[w]++
+			}
+			return counts
+		},
+		"keys": func(m map[string]int) []string {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			return keys
+		},
+		"identity": func(o object.Object) object.Object {
+			return o
+		},
+	}
+	for name, f := range funcs {
+		if err := interpreter.RegisterFunc(name, f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	interpreter.Define("answer", object.NewNumberObject(42))
+	interpreter.ReadLoop()
+
+	expectOut := strings.Join(outputs, "\n") + "\n"
+	if gotOut := out.String(); gotOut != expectOut {
+		t.Errorf("gotOut %v, want %v", gotOut, expectOut)
+	}
+
+	if err := interpreter.RegisterFunc("po", 1); err == nil {
+		t.Errorf("expected error on registering non-function")
+	}
+	if err := interpreter.RegisterFunc("po", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("expected error on registering function with invalid return values")
+	}
+}
+
+func TestConversion(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       interface{}
+		written string
+		toGo    interface{}
+	}{
+		{
+			name:    "number",
+			v:       3,
+			written: "3",
+			toGo:    3.0,
+		},
+		{
+			name:    "string",
+			v:       "po",
+			written: "\"po\"",
+			toGo:    "po",
+		},
+		{
+			name:    "nested slice",
+			v:       [][]interface{}{{true, 1.5}, nil},
+			written: "((#t 1.5) ())",
+			toGo:    []interface{}{[]interface{}{true, 1.5}, []interface{}{}},
+		},
+		{
+			name:    "pointer",
+			v:       &[]int{1, 2},
+			written: "(1 2)",
+			toGo:    []interface{}{1.0, 2.0},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			o, err := FromGo(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := o.String(); got != tt.written {
+				t.Errorf("FromGo() = %v, want %v", got, tt.written)
+			}
+			v, err := ToGo(o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(v, tt.toGo) {
+				t.Errorf("ToGo() = %#v, want %#v", v, tt.toGo)
+			}
+		})
+	}
+
+	o, err := FromGo(map[string][]int{"a": {1}, "b": {2, 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string][]uint8
+	if err := ToGoValue(o, &m); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string][]uint8{"a": {1}, "b": {2, 3}}; !reflect.DeepEqual(m, want) {
+		t.Errorf("ToGoValue() = %v, want %v", m, want)
+	}
+	var n uint8
+	if err := ToGoValue(object.NewNumberObject(256), &n); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	if _, err := ToGo(object.NewConsObject(object.NewNumberObject(1), object.NewNumberObject(2))); err == nil {
+		t.Errorf("expected error on improper list")
+	}
+}
+
+func TestRecoverPanic(t *testing.T) {
+	inputs := []string{
+		"(boom)",
+		"(map (lambda (x) (boom)) '(1))",
+		"(+ 1 2)",
+	}
+	outputs := []string{
+		"error: internal error in (boom): boom",
+		"error: internal error in (boom): boom",
+		"3",
+	}
+
+	out := &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(inputs, "\n")))), out, false, 0)
+	if err := interpreter.RegisterFunc("boom", func() { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	interpreter.ReadLoop()
+
+	expectOut := strings.Join(outputs, "\n") + "\n"
+	if gotOut := out.String(); gotOut != expectOut {
+		t.Errorf("gotOut %v, want %v", gotOut, expectOut)
+	}
+
+	_, err := interpreter.EvalString(context.Background(), "(define x 1)\n(boom)")
+	if want := "2:1: runtime error: internal error in (boom): boom"; err == nil || err.Error() != want {
+		t.Errorf("EvalString() error = %v, want %v", err, want)
+	}
+}
+
+func TestTailApplyFunction(t *testing.T) {
+	for _, engine := range []Engine{EngineClosure, EngineVM} {
+		engine := engine
+		t.Run(engine.String(), func(t *testing.T) {
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0,
+				WithEngine(engine), WithLimits(Limits{MaxDepth: 100, MaxFrames: 100}))
+			interpreter.Define("call", object.NewTailApplyFunctionObject(func(objects []object.Object) (object.Object, []object.Object, object.Object) {
+				return objects[0], objects[1:], nil
+			}))
+			src := "(define (loop n) (if (= n 0) 'done (call apply loop (list (- n 1)))))\n(loop 10000)"
+			res, err := interpreter.EvalString(context.Background(), src)
+			if err != nil {
+				t.Fatalf("EvalString() error = %v", err)
+			}
+			if got, want := res.String(), "done"; got != want {
+				t.Errorf("EvalString() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestLookupDoesNotIntern(t *testing.T) {
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	name := "name looked up but never interned"
+	if _, ok := i.Lookup(name); ok {
+		t.Errorf("Lookup(%q) found a value", name)
+	}
+	if i.globalEnv.Set(name, object.VoidObj) {
+		t.Errorf("Set(%q) set a value", name)
+	}
+	if _, ok := node.Interned(name); ok {
+		t.Errorf("%q is interned by lookup", name)
+	}
+	i.Define(name, object.VoidObj)
+	if _, ok := i.Lookup(name); !ok {
+		t.Errorf("Lookup(%q) did not find the defined value", name)
+	}
+}
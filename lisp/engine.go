@@ -0,0 +1,45 @@
+package lisp
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/node"
+	"strconv"
+)
+
+// Engine is the execution engine evaluating programs.
+type Engine int
+
+const (
+	// EngineClosure compiles each top-level form into Go closures, and evaluates them on the Go stack.
+	EngineClosure Engine = iota
+	// EngineVM compiles each top-level form into bytecode, and evaluates it with a stack-based virtual machine.
+	// Calls between functions compiled into bytecode do not grow the Go stack,
+	// so that deep non-tail recursion is only limited by Limits.MaxFrames.
+	EngineVM
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineClosure:
+		return "closure"
+	case EngineVM:
+		return "vm"
+	}
+	return strconv.Itoa(int(e))
+}
+
+// WithEngine sets the execution engine. EngineClosure is used by default.
+func WithEngine(e Engine) Option {
+	return func(i *Interpreter) {
+		i.engine = e
+	}
+}
+
+// eval compiles and evaluates the top-level node in the global environment.
+func (e Engine) eval(ctx context.Context, n *node.Node, env *object.Env) object.Object {
+	if e == EngineVM {
+		return runVM(ctx, compileVM(n), env)
+	}
+	return evalCode(ctx, compile(n, nil), env)
+}
@@ -2,6 +2,7 @@ package lisp
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
@@ -10,16 +11,16 @@ import (
 	"testing"
 )
 
-func setUpInterpreter(b *testing.B, preEval string) (*bytes.Buffer, *Interpreter) {
+func setUpInterpreter(b *testing.B, preEval string, opts ...Option) (*bytes.Buffer, *Interpreter) {
 	b.Helper()
 
 	input := bytes.NewBufferString("")
 	parser := node.NewParser(token.NewTokenizer(input))
 	out := &bytes.Buffer{}
-	interpreter := NewInterpreter(parser, out, false, 0)
+	interpreter := NewInterpreter(parser, out, false, 0, opts...)
 
 	input.WriteString(preEval)
-	_, cont, _ := interpreter.evalNext()
+	_, cont, _ := interpreter.evalNext(context.Background())
 	if !cont {
 		b.Fatalf("not continued")
 	}
@@ -32,7 +33,7 @@ func BenchmarkEvalSumN(b *testing.B) {
 
 	input.WriteString("(sum " + strconv.Itoa(b.N) + ")")
 	b.ResetTimer()
-	obj, cont, _ := interpreter.evalNext()
+	obj, cont, _ := interpreter.evalNext(context.Background())
 	b.StopTimer()
 	if !cont {
 		panic("not continued")
@@ -47,7 +48,7 @@ func BenchmarkEvalSumTailN(b *testing.B) {
 
 	input.WriteString("(sum-tail " + strconv.Itoa(b.N) + " 0)")
 	b.ResetTimer()
-	obj, cont, _ := interpreter.evalNext()
+	obj, cont, _ := interpreter.evalNext(context.Background())
 	b.StopTimer()
 	if !cont {
 		panic("not continued")
@@ -64,7 +65,7 @@ func BenchmarkEvalSum(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		input.WriteString("(sum 10000)")
 		b.StartTimer()
-		obj, cont, _ := interpreter.evalNext()
+		obj, cont, _ := interpreter.evalNext(context.Background())
 		b.StopTimer()
 		if !cont {
 			panic("not continued")
@@ -82,7 +83,7 @@ func BenchmarkEvalSumTail(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		input.WriteString("(sum-tail 10000 0)")
 		b.StartTimer()
-		obj, cont, _ := interpreter.evalNext()
+		obj, cont, _ := interpreter.evalNext(context.Background())
 		b.StopTimer()
 		if !cont {
 			panic("not continued")
@@ -92,3 +93,51 @@ func BenchmarkEvalSumTail(b *testing.B) {
 		}
 	}
 }
+
+// benchmarkProgram benchmarks the evaluation of src with each engine.
+func benchmarkProgram(b *testing.B, preEval string, src string, expected string) {
+	for _, engine := range []Engine{EngineClosure, EngineVM} {
+		engine := engine
+		b.Run(engine.String(), func(b *testing.B) {
+			input, interpreter := setUpInterpreter(b, preEval, WithEngine(engine))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				input.WriteString(src)
+				b.StartTimer()
+				obj, cont, _ := interpreter.evalNext(context.Background())
+				b.StopTimer()
+				if !cont {
+					panic("not continued")
+				}
+				if obj.String() != expected {
+					panic(fmt.Sprintf("unexpected object: %v", obj))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEvalFib(b *testing.B) {
+	benchmarkProgram(b, "(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))", "(fib 20)", "6765")
+}
+
+func BenchmarkEvalTak(b *testing.B) {
+	benchmarkProgram(b, "(define (tak x y z) (if (not (< y x)) z (tak (tak (- x 1) y z) (tak (- y 1) z x) (tak (- z 1) x y))))", "(tak 18 12 6)", "7")
+}
+
+func BenchmarkEvalNQueens(b *testing.B) {
+	benchmarkProgram(b, `(define (queens board-size)
+  (define (ok? row dist placed)
+    (or (null? placed)
+        (and (not (= (car placed) (+ row dist)))
+             (not (= (car placed) (- row dist)))
+             (not (= (car placed) row))
+             (ok? row (+ dist 1) (cdr placed)))))
+  (define (try row placed k)
+    (cond ((= k 0) 1)
+          ((> row board-size) 0)
+          (else (+ (if (ok? row 1 placed) (try 1 (cons row placed) (- k 1)) 0)
+                   (try (+ row 1) placed k)))))
+  (try 1 '() board-size))`, "(queens 7)", "40")
+}
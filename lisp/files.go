@@ -0,0 +1,280 @@
+package lisp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrFileAccessDisabled = errors.New("file access is disabled")
+	ErrOutsideFileRoot    = errors.New("path is outside of the file root")
+	ErrModifyFileRoot     = errors.New("cannot modify the file root itself")
+)
+
+// fileRoot restricts file access to files under the root directory.
+type fileRoot struct {
+	root string
+}
+
+// newFileRoot returns a new file root of the given directory.
+func newFileRoot(root string) *fileRoot {
+	if abs, err := filepath.Abs(root); err == nil {
+		root = abs
+	}
+	return &fileRoot{root: filepath.Clean(root)}
+}
+
+// within returns true if path is the base directory or under the directory.
+func within(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// maxSymlinks is the maximum number of symbolic links followed to resolve a path.
+const maxSymlinks = 40
+
+// evalSymlinks resolves symbolic links in the given absolute path. Links which do not exist yet are resolved
+// as far as they exist, and dangling links are followed to their targets, so that creating the file at the
+// resulting path does not follow any link.
+func evalSymlinks(path string) (string, error) {
+	return evalSymlinksN(path, 0)
+}
+
+func evalSymlinksN(path string, links int) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	resolvedParent, err := evalSymlinksN(parent, links)
+	if err != nil {
+		return "", err
+	}
+	resolved = filepath.Join(resolvedParent, filepath.Base(path))
+	info, err := os.Lstat(resolved)
+	if os.IsNotExist(err) {
+		return resolved, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return resolved, nil
+	}
+	// dangling symbolic link
+	if links >= maxSymlinks {
+		return "", errors.New("too many levels of symbolic links")
+	}
+	target, err := os.Readlink(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(resolvedParent, target)
+	}
+	return evalSymlinksN(target, links+1)
+}
+
+// resolve resolves the given path in Lisp programs to a path in the host file system, with symbolic links resolved.
+// Relative paths are resolved against the root, and paths outside the root (including via symbolic links) are rejected.
+func (f *fileRoot) resolve(name string) (string, error) {
+	if f == nil {
+		return "", ErrFileAccessDisabled
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(f.root, path)
+	}
+	path = filepath.Clean(path)
+	if !within(f.root, path) {
+		return "", ErrOutsideFileRoot
+	}
+
+	realRoot, err := f.realRoot()
+	if err != nil {
+		return "", err
+	}
+	realPath, err := evalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !within(realRoot, realPath) {
+		return "", ErrOutsideFileRoot
+	}
+	return realPath, nil
+}
+
+// resolveTarget is like resolve, but also rejects the root itself, for operations modifying the file at the path.
+func (f *fileRoot) resolveTarget(name string) (string, error) {
+	path, err := f.resolve(name)
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := f.realRoot()
+	if err != nil {
+		return "", err
+	}
+	if path == realRoot {
+		return "", ErrModifyFileRoot
+	}
+	return path, nil
+}
+
+// realRoot returns the root with symbolic links resolved.
+func (f *fileRoot) realRoot() (string, error) {
+	return evalSymlinks(f.root)
+}
+
+// open opens the file at the path resolved by resolve.
+// Elements of the path replaced by symbolic links after resolved are not followed.
+func (f *fileRoot) open(path string, flag int) (*os.File, error) {
+	realRoot, err := f.realRoot()
+	if err != nil {
+		return nil, err
+	}
+	return openBeneath(realRoot, path, flag, 0666)
+}
+
+// remove removes the file at the path resolved by resolveTarget.
+// Elements of the path replaced by symbolic links after resolved are not followed.
+func (f *fileRoot) remove(path string) error {
+	realRoot, err := f.realRoot()
+	if err != nil {
+		return err
+	}
+	return removeBeneath(realRoot, path)
+}
+
+// defineFileFuncs defines functions accessing files under the file root of this interpreter to the given frame.
+func (i *Interpreter) defineFileFuncs(global object.Frame) {
+	global["open-input-file"] = object.NewWrappedFunctionObject(
+		makeUnary(i.makeFile("open-input-file", false, i.openInputFile)))
+	global["open-output-file"] = object.NewWrappedFunctionObject(
+		makeUnary(i.makeFile("open-output-file", true, i.openOutputFile)))
+	global["call-with-input-file"] = object.NewWrappedFunctionObject(
+		makeBinary(i.makeFile("call-with-input-file", false, func(ctx context.Context, path string, objects []object.Object) object.Object {
+			return callWithPort(ctx, path, objects[1], i.openInputFile)
+		})))
+	global["call-with-output-file"] = object.NewWrappedFunctionObject(
+		makeBinary(i.makeFile("call-with-output-file", true, func(ctx context.Context, path string, objects []object.Object) object.Object {
+			return callWithPort(ctx, path, objects[1], i.openOutputFile)
+		})))
+	global["with-input-from-file"] = object.NewWrappedFunctionObject(
+		makeBinary(i.makeFile("with-input-from-file", false, func(ctx context.Context, path string, objects []object.Object) object.Object {
+			return withPort(ctx, path, objects[1], i.curIn, i.openInputFile)
+		})))
+	global["with-output-to-file"] = object.NewWrappedFunctionObject(
+		makeBinary(i.makeFile("with-output-to-file", true, func(ctx context.Context, path string, objects []object.Object) object.Object {
+			return withPort(ctx, path, objects[1], i.curOut, i.openOutputFile)
+		})))
+
+	global["file-exists?"] = object.NewWrappedFunctionObject(
+		makeUnary(i.makeFile("file-exists?", false, func(_ context.Context, path string, _ []object.Object) object.Object {
+			_, err := os.Stat(path)
+			if err != nil && !os.IsNotExist(err) {
+				return object.NewErrorObject(fmt.Sprintf("file-exists?: %v", err))
+			}
+			return object.NewBooleanObject(err == nil)
+		})))
+	global["delete-file"] = object.NewWrappedFunctionObject(
+		makeUnary(i.makeFile("delete-file", true, func(_ context.Context, path string, _ []object.Object) object.Object {
+			if err := i.files.remove(path); err != nil {
+				return object.NewErrorObject(fmt.Sprintf("delete-file: %v", err))
+			}
+			return object.VoidObj
+		})))
+	global["directory-list"] = object.NewWrappedFunctionObject(
+		makeUnary(i.makeFile("directory-list", false, func(ctx context.Context, path string, _ []object.Object) object.Object {
+			dir, err := i.files.open(path, os.O_RDONLY)
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("directory-list: %v", err))
+			}
+			defer dir.Close()
+			entries, err := dir.Readdirnames(-1)
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("directory-list: %v", err))
+			}
+			sort.Strings(entries)
+			names := make([]object.Object, len(entries))
+			for idx, entry := range entries {
+				names[idx] = object.NewStringObject(entry)
+			}
+			return allocateList(ctx, names)
+		})))
+}
+
+// makeFile makes a function taking a file name in the 1st argument, resolving it under the file root.
+// If modify is true, the function modifies the file, and the file root itself is rejected.
+func (i *Interpreter) makeFile(name string, modify bool, next func(ctx context.Context, path string, objects []object.Object) object.Object) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		fileName := objects[0]
+		if fileName.Type() != object_type.Str {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected file name to be string, but got %v", name, fileName))
+		}
+		resolve := i.files.resolve
+		if modify {
+			resolve = i.files.resolveTarget
+		}
+		path, err := resolve(fileName.Str())
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("%v: %v: %v", name, fileName.Str(), err))
+		}
+		return next(ctx, path, objects)
+	}
+}
+
+func (i *Interpreter) openInputFile(_ context.Context, path string, _ []object.Object) object.Object {
+	f, err := i.files.open(path, os.O_RDONLY)
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("cannot open input file: %v", err))
+	}
+	return object.NewInputPortObject(filepath.Base(path), f, f)
+}
+
+func (i *Interpreter) openOutputFile(_ context.Context, path string, _ []object.Object) object.Object {
+	f, err := i.files.open(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("cannot open output file: %v", err))
+	}
+	return object.NewOutputPortObject(filepath.Base(path), f, f)
+}
+
+// callWithPort opens a port with open, calls proc with the port, and closes the port.
+func callWithPort(ctx context.Context, path string, proc object.Object, open func(ctx context.Context, path string, _ []object.Object) object.Object) object.Object {
+	if proc.Type() != object_type.Function {
+		return object.NewErrorObject(fmt.Sprintf("expected 2nd argument to be a function, but got %v", proc))
+	}
+	p := open(ctx, path, nil)
+	if p.Type() == object_type.Err {
+		return p
+	}
+	defer p.(*object.Port).Close()
+	return callWithTailOptimization(ctx, proc.F, []object.Object{p})
+}
+
+// withPort opens a port with open, calls thunk with the port bound to param, and closes the port.
+func withPort(ctx context.Context, path string, thunk object.Object, param *object.Parameter, open func(ctx context.Context, path string, _ []object.Object) object.Object) object.Object {
+	if thunk.Type() != object_type.Function {
+		return object.NewErrorObject(fmt.Sprintf("expected 2nd argument to be a function, but got %v", thunk))
+	}
+	p := open(ctx, path, nil)
+	if p.Type() == object_type.Err {
+		return p
+	}
+	defer p.(*object.Port).Close()
+	return callWithTailOptimization(
+		object.WithParameterValues(ctx, []*object.Parameter{param}, []object.Object{p}), thunk.F, []object.Object{})
+}
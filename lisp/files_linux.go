@@ -0,0 +1,69 @@
+package lisp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// openBeneath opens the file at path under the directory root, opening each element of path relative to its parent
+// without following symbolic links, so that path cannot be redirected outside of root by replacing its elements.
+func openBeneath(root, path string, flag int, perm os.FileMode) (*os.File, error) {
+	dir, name, err := openParent(root, path)
+	if err != nil {
+		return nil, err
+	}
+	if dir < 0 {
+		// the root itself
+		return os.OpenFile(root, flag, perm)
+	}
+	defer syscall.Close(dir)
+	fd, err := syscall.Openat(dir, name, flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm))
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// removeBeneath removes the file at path under the directory root, like openBeneath.
+func removeBeneath(root, path string) error {
+	dir, name, err := openParent(root, path)
+	if err != nil {
+		return err
+	}
+	if dir < 0 {
+		return ErrModifyFileRoot
+	}
+	defer syscall.Close(dir)
+	if err := syscall.Unlinkat(dir, name); err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	return nil
+}
+
+// openParent opens the parent directory of path under root element by element without following symbolic links,
+// and returns its file descriptor and the last element of path. The descriptor is -1 if path is root itself.
+func openParent(root, path string) (int, string, error) {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || !within(root, path) {
+		return -1, "", ErrOutsideFileRoot
+	}
+	if rel == "." {
+		return -1, "", nil
+	}
+	elems := strings.Split(rel, string(filepath.Separator))
+	dir, err := syscall.Open(root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", &os.PathError{Op: "open", Path: root, Err: err}
+	}
+	for _, elem := range elems[:len(elems)-1] {
+		next, err := syscall.Openat(dir, elem, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		syscall.Close(dir)
+		if err != nil {
+			return -1, "", &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		dir = next
+	}
+	return dir, elems[len(elems)-1], nil
+}
@@ -0,0 +1,63 @@
+//go:build !linux
+// +build !linux
+
+package lisp
+
+import (
+	"os"
+)
+
+// openBeneath opens the file at path under the directory root.
+// The file is checked after opened to be the file at path without symbolic links, so that path redirected outside
+// of root by replacing its elements is rejected. Truncation is delayed until the check.
+func openBeneath(root, path string, flag int, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, flag&^os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkBeneath(root, path, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if flag&os.O_TRUNC != 0 {
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// removeBeneath removes the file at path under the directory root, checking path has no symbolic links.
+func removeBeneath(root, path string) error {
+	if err := checkBeneath(root, path, nil); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// checkBeneath checks path has no symbolic links under root, and f is the file at path if not nil.
+func checkBeneath(root, path string, f *os.File) error {
+	realPath, err := evalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	if realPath != path || !within(root, realPath) {
+		return ErrOutsideFileRoot
+	}
+	if f == nil {
+		return nil
+	}
+	opened, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(opened, info) {
+		return ErrOutsideFileRoot
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package lisp
+
+import (
+	"bytes"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileAccess(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "in.txt"), []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+	// dangling links, to a file outside of the root and to a file inside
+	linkRoot := t.TempDir()
+	if err := os.Symlink(filepath.Join(outside, "escaped.txt"), filepath.Join(linkRoot, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("created.txt", filepath.Join(linkRoot, "inner")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("escape", filepath.Join(linkRoot, "chain")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		inputs  []string
+		outputs []string
+	}{
+		{
+			name: "read and write",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(call-with-input-file \"dir/in.txt\" read-line)",
+				"(define p (open-input-file \"dir/in.txt\"))",
+				"(read-line p)",
+				"(read-line p)",
+				"(read-line p)",
+				"(close-input-port p)",
+				"(with-output-to-file \"out.txt\" (lambda () (display \"hello\") (newline)))",
+				"(call-with-output-file \"out2.txt\" (lambda (p) (write 'po p)))",
+				"(with-input-from-file \"out.txt\" read-line)",
+				"(file-exists? \"out2.txt\")",
+				"(length (directory-list \".\"))",
+				"(car (directory-list \".\"))",
+				"(delete-file \"out2.txt\")",
+				"(file-exists? \"out2.txt\")",
+			},
+			outputs: []string{
+				"\"line 1\"",
+				"\"line 1\"",
+				"\"line 2\"",
+				"#<eof>",
+				"\"hello\"",
+				"#t",
+				"4",
+				"\"dir\"",
+				"#f",
+			},
+		},
+		{
+			name: "outside of root",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(file-exists? \"../secret.txt\")",
+				"(open-input-file \"" + filepath.Join(outside, "secret.txt") + "\")",
+				"(open-input-file \"link/secret.txt\")",
+				"(file-exists? \"" + filepath.Join(root, "dir", "in.txt") + "\")",
+			},
+			outputs: []string{
+				"error: file-exists?: ../secret.txt: path is outside of the file root",
+				"error: open-input-file: " + filepath.Join(outside, "secret.txt") + ": path is outside of the file root",
+				"error: open-input-file: link/secret.txt: path is outside of the file root",
+				"#t",
+			},
+		},
+		{
+			name: "modify root",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(delete-file \".\")",
+				"(delete-file \"\")",
+				"(delete-file \"dir/..\")",
+				"(open-output-file \".\")",
+				"(file-exists? \".\")",
+			},
+			outputs: []string{
+				"error: delete-file: .: cannot modify the file root itself",
+				"error: delete-file: : cannot modify the file root itself",
+				"error: delete-file: dir/..: cannot modify the file root itself",
+				"error: open-output-file: .: cannot modify the file root itself",
+				"#t",
+			},
+		},
+		{
+			name: "dangling symbolic links",
+			opts: []Option{WithFileRoot(linkRoot)},
+			inputs: []string{
+				"(open-output-file \"escape\")",
+				"(call-with-output-file \"chain\" (lambda (p) (write 'escaped p)))",
+				"(call-with-output-file \"inner\" (lambda (p) (write 'created p)))",
+				"(call-with-input-file \"created.txt\" read)",
+			},
+			outputs: []string{
+				"error: open-output-file: escape: path is outside of the file root",
+				"error: call-with-output-file: chain: path is outside of the file root",
+				"created",
+			},
+		},
+		{
+			name: "disabled",
+			opts: []Option{WithFileRoot(root), WithoutFileAccess()},
+			inputs: []string{
+				"(file-exists? \"dir/in.txt\")",
+			},
+			outputs: []string{
+				"error: file-exists?: dir/in.txt: file access is disabled",
+			},
+		},
+		{
+			name: "disabled by default",
+			inputs: []string{
+				"(open-output-file \"po.txt\")",
+			},
+			outputs: []string{
+				"error: open-output-file: po.txt: file access is disabled",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(tt.inputs, "\n")))), out, false, 0, tt.opts...)
+			interpreter.ReadLoop()
+
+			expectOut := strings.Join(tt.outputs, "\n") + "\n"
+
+			if gotOut := out.String(); gotOut != expectOut {
+				t.Errorf("gotOut %v, want %v", gotOut, expectOut)
+			}
+		})
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("file outside of the root was created through a dangling link: %v", err)
+	}
+}
+
+func TestFileRootReplacedBySymlink(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "in.txt"), []byte("inside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "in.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f := newFileRoot(root)
+	path, err := f.resolveTarget("dir/in.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the directory by a link to outside of the root, after the path is resolved
+	if err := os.Rename(filepath.Join(root, "dir"), filepath.Join(root, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	if file, err := f.open(path, os.O_RDWR|os.O_TRUNC); err == nil {
+		file.Close()
+		t.Error("open() followed the replaced link")
+	}
+	if err := f.remove(path); err == nil {
+		t.Error("remove() followed the replaced link")
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(outside, "in.txt")); err != nil || string(b) != "secret" {
+		t.Errorf("file outside of the root was modified: %q, %v", b, err)
+	}
+}
@@ -1,62 +1,136 @@
 package lisp
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/lisp/printer"
 	"github.com/motoki317/lisp-interpreter/node"
 	"github.com/motoki317/lisp-interpreter/token"
 	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Interpreter struct {
 	p         *node.Parser
 	out       io.Writer
+	errOut    io.Writer
 	globalEnv *object.Env
 	cuiMode   bool
 	timeout   time.Duration
+	// rand is the random source of random and random-seed, shared among threads
+	rand   *rand.Rand
+	randMu sync.Mutex
+	// current ports
+	curIn, curOut, curErr *object.Parameter
+	// files is the root of file access, nil if disabled
+	files *fileRoot
+	// limits are the limits of resources used by each top-level evaluation
+	limits Limits
+	// builtinSet is the set of builtins available to programs
+	builtinSet BuiltinSet
+	// engine is the execution engine evaluating programs
+	engine Engine
+	// builtins are the initial variables of the global environment, which each library starts with
+	builtins object.Frame
+	// libraryPath is the directories to search for library files
+	libraryPath []string
+	// libraries are the libraries defined so far by name, each loaded only once
+	libraries   map[string]*library
+	librariesMu sync.Mutex
+	// noPrelude is true if the prelude is not loaded
+	noPrelude bool
 }
 
-func NewInterpreter(p *node.Parser, out io.Writer, cuiMode bool, timeout time.Duration) *Interpreter {
+// Option configures an Interpreter.
+type Option func(i *Interpreter)
+
+// WithFileRoot enables file access from programs, restricting it to files under the given root directory.
+// Relative paths are resolved against the root.
+func WithFileRoot(root string) Option {
+	return func(i *Interpreter) {
+		i.files = newFileRoot(root)
+	}
+}
+
+// WithoutFileAccess disables file access from programs. File access is disabled by default.
+func WithoutFileAccess() Option {
+	return func(i *Interpreter) {
+		i.files = nil
+	}
+}
+
+func NewInterpreter(p *node.Parser, out io.Writer, cuiMode bool, timeout time.Duration, opts ...Option) *Interpreter {
+	i := &Interpreter{
+		p:          p,
+		out:        out,
+		cuiMode:    cuiMode,
+		timeout:    timeout,
+		errOut:     os.Stderr,
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		builtinSet: BuiltinsFull,
+		libraries:  make(map[string]*library),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	if i.globalEnv != nil {
+		// custom environment given by WithEnv
+		i.builtins = i.globalEnv.Globals()
+		return i
+	}
+
 	global := object.EmptyFrame()
 	for k, v := range defaultEnv {
 		global[k] = v
 	}
-	i := &Interpreter{
-		p:         p,
-		out:       out,
-		globalEnv: object.NewGlobalEnv(global),
-		cuiMode:   cuiMode,
-		timeout:   timeout,
-	}
-	global["display"] = object.NewWrappedFunctionObject(
-		makeUnary(func(objects []object.Object) object.Object {
-			i.printf("%v", objects[0])
-			return object.VoidObj
-		}))
-	global["newline"] = object.NewWrappedFunctionObject(
-		makeNullary(func(_ []object.Object) object.Object {
-			i.printf("\n")
-			return object.VoidObj
-		}))
-	global["read"] = object.NewWrappedFunctionObject(
-		makeNullary(func(_ []object.Object) object.Object {
-			n, err := i.p.Next()
-			if err == node.EOF {
-				return object.NewErrorObject("end of input")
+	i.defineRandomFuncs(global)
+	if i.builtinSet >= BuiltinsIO {
+		i.definePortFuncs(global)
+	}
+	if i.builtinSet >= BuiltinsFull {
+		i.defineFileFuncs(global)
+		i.defineLoadFuncs(global)
+		i.defineThreadFuncs(global)
+	}
+	i.globalEnv = object.NewGlobalEnv(global)
+	if !i.noPrelude {
+		i.loadPrelude()
+	}
+	i.builtins = i.globalEnv.Globals()
+	return i
+}
+
+// defineRandomFuncs defines functions using the random source of this interpreter to the given frame.
+func (i *Interpreter) defineRandomFuncs(global object.Frame) {
+	global["random"] = object.NewWrappedFunctionObject(
+		makeUnary(makeNumbers(func(input []float64) object.Object {
+			// returns an integer in [0, n) if n is an integer, or a real number in [0, n) otherwise
+			n := input[0]
+			if n <= 0 {
+				return object.NewErrorObject(fmt.Sprintf("random: expected positive number, but got %v", object.NewNumberObject(n)))
 			}
-			if err != nil {
-				return object.NewErrorObject(fmt.Sprintf("an error occurred while reading from input: %v", err))
+			i.randMu.Lock()
+			defer i.randMu.Unlock()
+			if k, ok := toInteger(n); ok {
+				return object.NewNumberObject(float64(i.rand.Int63n(k)))
 			}
-			return evalWithTailOptimization(&node.Node{
-				Type: node.Branch,
-				Children: []*node.Node{
-					{Type: node.Keyword, Str: "quote"},
-					n,
-				},
-			}, i.globalEnv)
-		}))
-	return i
+			return object.NewNumberObject(i.rand.Float64() * n)
+		})))
+	global["random-seed"] = object.NewWrappedFunctionObject(
+		makeUnary(makeIntegers(func(input []int64) object.Object {
+			i.randMu.Lock()
+			defer i.randMu.Unlock()
+			i.rand.Seed(input[0])
+			return object.VoidObj
+		})))
 }
 
 // SetTokenizer sets internal tokenizer used by parser, to start using from the next call.
@@ -76,7 +150,7 @@ func (i *Interpreter) printf(format string, a ...interface{}) {
 	}
 }
 
-func (i *Interpreter) evalNext() (res object.Object, cont bool, timedOut bool) {
+func (i *Interpreter) evalNext(ctx context.Context) (res object.Object, cont bool, timedOut bool) {
 	n, err := i.p.Next()
 	if err == node.EOF {
 		return nil, false, false
@@ -86,45 +160,145 @@ func (i *Interpreter) evalNext() (res object.Object, cont bool, timedOut bool) {
 		return nil, true, false
 	}
 
-	// apply macro to the input
-	n, err = i.globalEnv.ApplyMacro(n)
+	// apply macro to the input, and splice included files
+	n, err = i.expand(i.globalEnv, n, "")
 	if err != nil {
 		i.printf("An error occurred while applying macro: %v\n", err)
 		return nil, true, false
 	}
 
-	var stopper <-chan time.Time
 	if i.timeout != time.Duration(0) {
-		timer := time.NewTimer(i.timeout)
-		stopper = timer.C
-		defer timer.Stop()
+		var cancel context.CancelFunc
+		ctx, cancel = withFormTimeout(ctx, i.timeout)
+		defer cancel()
 	}
 
-	res, timedOut = evalWithStopper(n, i.globalEnv, stopper)
-	if timedOut {
+	res, err = evalWithContext(withEvalState(withProfileOutput(ctx, i.curErr), i.limits), i.eval, n, i.globalEnv)
+	if err != nil {
 		return nil, true, true
 	} else {
 		return res, true, false
 	}
 }
 
+// applyMacro applies macros in the global environment env to n, recovering from a panic as an error.
+func applyMacro(env *object.Env, n *node.Node) (res *node.Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			res, err = nil, fmt.Errorf("internal error: %v", r)
+		}
+	}()
+	return env.ApplyMacro(n)
+}
+
 // ReadLoop executes the Read, Eval, Print loop (REPL), until the parser hits EOF.
 func (i *Interpreter) ReadLoop() {
-	for {
+	i.ReadLoopContext(context.Background())
+}
+
+// ReadLoopContext is like ReadLoop, but also stops when ctx is done, interrupting the current evaluation if any.
+// Threads started by the forms outlive the timeout of each form, but are terminated when the loop returns.
+func (i *Interpreter) ReadLoopContext(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for ctx.Err() == nil {
 		if i.cuiMode {
 			i.printf("> ")
 		}
-		res, cont, timedOut := i.evalNext()
+		res, cont, timedOut := i.evalNext(ctx)
 		if !cont {
 			break
 		}
 		if timedOut {
+			if ctx.Err() != nil {
+				break
+			}
 			i.printf("Timed out.\n")
 			continue
 		}
 		if res == nil || res == object.VoidObj {
 			continue
 		}
-		i.printf("%v\n", res)
+		i.printf("%v\n", printer.Write(res))
+	}
+}
+
+// ErrorKind is the kind of Error.
+type ErrorKind int
+
+const (
+	// ParseError is an error occurred while parsing the source
+	ParseError ErrorKind = iota
+	// MacroError is an error occurred while applying macros
+	MacroError
+	// RuntimeError is an error object resulted from evaluation
+	RuntimeError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ParseError:
+		return "parse error"
+	case MacroError:
+		return "macro error"
+	case RuntimeError:
+		return "runtime error"
+	}
+	return strconv.Itoa(int(k))
+}
+
+// Error is an error returned by EvalString and EvalReader.
+type Error struct {
+	Kind ErrorKind
+	// Pos is the position in the source where the error occurred.
+	// For macro and runtime errors, this is the position of the top-level form.
+	Pos token.Pos
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v: %v", e.Pos, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// EvalString evaluates all forms in src in the global environment, and returns the value of the last form.
+// See EvalReader for details.
+func (i *Interpreter) EvalString(ctx context.Context, src string) (object.Object, error) {
+	return i.EvalReader(ctx, strings.NewReader(src))
+}
+
+// EvalReader evaluates all forms read from r in the global environment, and returns the value of the last form,
+// or the void object if there are no forms.
+// Evaluation stops at the first parse, macro or runtime error, which is returned as *Error.
+// If ctx is done before the evaluation completes, ctx.Err() is returned.
+// Limits set by WithLimits apply to the evaluation of all forms as a whole.
+func (i *Interpreter) EvalReader(ctx context.Context, r io.Reader) (object.Object, error) {
+	ctx = withEvalState(withProfileOutput(ctx, i.curErr), i.limits)
+	p := node.NewParser(token.NewTokenizer(r))
+	var res object.Object = object.VoidObj
+	for {
+		n, err := p.Next()
+		if err == node.EOF {
+			return res, nil
+		}
+		if err != nil {
+			return nil, &Error{Kind: ParseError, Pos: p.Pos(), Err: err}
+		}
+
+		pos := n.Pos
+		n, err = i.expand(i.globalEnv, n, "")
+		if err != nil {
+			return nil, &Error{Kind: MacroError, Pos: pos, Err: err}
+		}
+		res, err = evalWithContext(ctx, i.eval, n, i.globalEnv)
+		if err != nil {
+			return nil, err
+		}
+		if res.Type() == object_type.Err {
+			return nil, &Error{Kind: RuntimeError, Pos: pos, Err: errors.New(res.Str())}
+		}
 	}
 }
@@ -2,10 +2,18 @@ package lisp
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/printer"
 	"github.com/motoki317/lisp-interpreter/node"
 	"github.com/motoki317/lisp-interpreter/token"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestInterpreter(t *testing.T) {
@@ -343,19 +351,1429 @@ func TestInterpreter(t *testing.T) {
 				"(1 2 3 4 5)",
 			},
 		},
+		{
+			name: "memoized promise",
+			inputs: []string{
+				"(define p (delay (begin (display (quote once)) 42)))",
+				"(promise? p)",
+				"(force p)",
+				"(force p)",
+				"(force (make-promise 3))",
+				"(promise? (make-promise 3))",
+				"(eq? p (make-promise p))",
+			},
+			outputs: []string{
+				"#t",
+				"once42",
+				"42",
+				"3",
+				"#t",
+				"#t",
+			},
+		},
+		{
+			// https://small.r7rs.org/attachment/r7rs.pdf 4.2.5
+			name: "delay-force",
+			inputs: []string{
+				"(define (loop n) (delay-force (if (= n 0) (delay 'done) (loop (- n 1)))))",
+				"(force (loop 100000))",
+				"(define count 0)",
+				"(define p (delay (begin (set! count (+ count 1)) (if (> count x) count (force p)))))",
+				"(define x 5)",
+				"(force p)",
+				"(begin (set! x 10) (force p))",
+			},
+			outputs: []string{
+				"done",
+				"6",
+				"6",
+			},
+		},
+		{
+			name: "streams library",
+			inputs: []string{
+				"(define (integers-from n) (stream-cons n (integers-from (+ n 1))))",
+				"(define nat (integers-from 0))",
+				"(stream-car (stream-cdr nat))",
+				"(stream->list (stream-take 5 nat))",
+				"(stream->list 5 (stream-filter even? nat))",
+				"(stream-null? (stream-take 0 nat))",
+				"(stream-pair? nat)",
+				"(stream->list (list->stream '(1 2 3)))",
+				"(define (fibgen a b) (stream-cons a (fibgen b (+ a b))))",
+				"(stream->list 1 (stream-filter (lambda (x) (> x 1000000)) (fibgen 0 1)))",
+				"(define s (stream-cons (begin (display 'car) (car '())) stream-null))",
+				"(stream-pair? s)",
+				"(stream-car s)",
+				"(stream-car (stream-cons (begin (display 'once) 1) stream-null))",
+				"(stream-pair? (cons (delay 1) (delay 2)))",
+				"(stream-car (cons (delay 1) (delay 2)))",
+				"(car nat)",
+				"nat",
+			},
+			outputs: []string{
+				"1",
+				"(0 1 2 3 4)",
+				"(0 2 4 6 8)",
+				"#t",
+				"#t",
+				"(1 2 3)",
+				"(1346269)",
+				"#t",
+				"carerror: car: expected cons but got null",
+				"once1",
+				"#f",
+				"error: stream-car: expected stream pair, but got (<promise> . <promise>)",
+				"error: car: expected cons but got stream-pair",
+				"<stream>",
+			},
+		},
+		{
+			name: "list library",
+			inputs: []string{
+				"(length '(1 2 3))",
+				"(append '(1) '(2 3) '() '(4 . 5))",
+				"(append)",
+				"(reverse '(1 2 3))",
+				"(list-tail '(1 2 3 4) 2)",
+				"(list-ref '(1 2 3 4) 2)",
+				"(memq 'c '(a b c d))",
+				"(member '(1) '((0) (1) (2)))",
+				"(member 2.0 '(1 2 3) =)",
+				"(memv 5 '(1 2 3))",
+				"(assq 'b '((a 1) (b 2)))",
+				"(assoc 2.0 '((1 one) (2 two)) =)",
+				"(last-pair '(1 2 3))",
+				"(list-copy '(1 2 . 3))",
+				"(iota 5)",
+				"(iota 5 1 2)",
+				"(define xs (list (list 1) (string-append \"a\") 1.5))",
+				"(list (memq (list 1) xs) (memv (string-append \"a\") xs) (memv 1.5 xs) (member \"a\" xs))",
+				"(list (memq (car xs) xs) (assq (list 1) (list xs)) (assv 1.5 '((1.5 . x))) (assoc (list 1) (list xs)))",
+				"(delete (list 1) xs)",
+			},
+			outputs: []string{
+				"3",
+				"(1 2 3 4 . 5)",
+				"()",
+				"(3 2 1)",
+				"(3 4)",
+				"3",
+				"(c d)",
+				"((1) (2))",
+				"(2 3)",
+				"#f",
+				"(b 2)",
+				"(2 two)",
+				"(3)",
+				"(1 2 . 3)",
+				"(0 1 2 3 4)",
+				"(1 3 5 7 9)",
+				"(#f #f (1.5) (\"a\" 1.5))",
+				"(((1) \"a\" 1.5) #f (1.5 . x) ((1) \"a\" 1.5))",
+				"(\"a\" 1.5)",
+			},
+		},
+		{
+			name: "higher order list functions",
+			inputs: []string{
+				"(map + '(1 2 3) '(10 20 30 40))",
+				"(for-each (lambda (x y) (display (+ x y))) '(1 2) '(3 4))",
+				"(newline)",
+				"(filter odd? '(1 2 3 4 5))",
+				"(reduce + 0 '(1 2 3 4))",
+				"(reduce + 0 '())",
+				"(fold-left cons '() '(1 2 3))",
+				"(fold-right cons '() '(1 2 3))",
+				"(fold-left (lambda (acc x y) (+ acc (* x y))) 0 '(1 2 3) '(4 5 6))",
+				"(delete 3 '(1 3 2 3))",
+				"(sort '(3 1 2 5 4) <)",
+				"(sort '((b . 1) (a . 1) (c . 0)) (lambda (x y) (< (cdr x) (cdr y))))",
+				"(apply + 1 2 '(3 4))",
+				"(apply list '())",
+			},
+			outputs: []string{
+				"(11 22 33)",
+				"46",
+				"(1 3 5)",
+				"10",
+				"0",
+				"(((() . 1) . 2) . 3)",
+				"(1 2 3)",
+				"32",
+				"(1 2)",
+				"(1 2 3 4 5)",
+				"((c . 0) (b . 1) (a . 1))",
+				"10",
+				"()",
+			},
+		},
+		{
+			name: "integer math",
+			inputs: []string{
+				"(abs -7)",
+				"(quotient -7 2)",
+				"(remainder -7 2)",
+				"(modulo -7 2)",
+				"(modulo 7 -2)",
+				"(modulo 5.5 2)",
+				"(modulo 1 0)",
+				"(floor/ -7 2)",
+				"(truncate/ -7 2)",
+				"(gcd 32 -36)",
+				"(gcd)",
+				"(lcm 32 -36)",
+				"(exact-integer-sqrt 17)",
+				"(square 5)",
+				"(expt 2 10)",
+				"(quotient 1 0)",
+				"(quotient 1.5 1)",
+			},
+			outputs: []string{
+				"7",
+				"-3",
+				"-1",
+				"-1",
+				"1",
+				"1",
+				"error: division by 0",
+				"(-4 1)",
+				"(-3 -1)",
+				"4",
+				"0",
+				"288",
+				"(4 1)",
+				"25",
+				"1024",
+				"error: division by 0",
+				"error: expected 0-th argument to be integer, but got 1.5",
+			},
+		},
+		{
+			name: "transcendental math and rounding",
+			inputs: []string{
+				"(exp 0)",
+				"(log 1)",
+				"(log 8 2)",
+				"(sin 0)",
+				"(cos 0)",
+				"(atan 1 1)",
+				"(floor -4.3)",
+				"(ceiling -4.3)",
+				"(truncate -4.3)",
+				"(round -4.3)",
+				"(round 2.5)",
+				"(round 3.5)",
+				"(number->string 255 16)",
+				"(number->string -10 2)",
+				"(string->number \"ff\" 16)",
+				"(string->number \"1.5\")",
+				"(string->number \"po\")",
+			},
+			outputs: []string{
+				"1",
+				"0",
+				"3",
+				"0",
+				"1",
+				"0.7853981633974483",
+				"-5.0",
+				"-4.0",
+				"-4.0",
+				"-4.0",
+				"2.0",
+				"4.0",
+				"\"ff\"",
+				"\"-1010\"",
+				"255",
+				"1.5",
+				"#f",
+			},
+		},
+		{
+			name: "random",
+			inputs: []string{
+				"(random-seed 42)",
+				"(define a (random 100))",
+				"(define b (random 1.5))",
+				"(random-seed 42)",
+				"(= a (random 100))",
+				"(= b (random 1.5))",
+				"(and (<= 0 a) (< a 100))",
+				"(random 0)",
+			},
+			outputs: []string{
+				"#t",
+				"#t",
+				"#t",
+				"error: random: expected positive number, but got 0",
+			},
+		},
+		{
+			name: "string ports",
+			inputs: []string{
+				"(define in (open-input-string \"ab\ncd (1 2) 3\"))",
+				"(input-port? in)",
+				"(peek-char in)",
+				"(read-char in)",
+				"(read-line in)",
+				"(read-string 2 in)",
+				"(read in)",
+				"(read in)",
+				"(read in)",
+				"(eof-object? (read-char in))",
+				"(define out (open-output-string))",
+				"(write 'po out)",
+				"(write-char #\\space out)",
+				"(display 42 out)",
+				"(write-string \"hello\" out 1 3)",
+				"(get-output-string out)",
+				"(close-port out)",
+				"(output-port-open? out)",
+				"(display 1 out)",
+			},
+			outputs: []string{
+				"#t",
+				"#\\a",
+				"#\\a",
+				"\"b\"",
+				"\"cd\"",
+				"(1 2)",
+				"3",
+				"#<eof>",
+				"#t",
+				"\"po 42el\"",
+				"#f",
+				"error: an error occurred while writing to output: port is closed",
+			},
+		},
+		{
+			name: "current ports",
+			inputs: []string{
+				"(display \"po\" (current-output-port))",
+				"(newline)",
+				"(with-output-to-string (lambda () (display \"in string\") (write 'po)))",
+				"(define sp (open-output-string))",
+				"(parameterize ((current-output-port sp)) (display 1) (display 2))",
+				"(get-output-string sp)",
+				"(display 3)",
+				"(newline)",
+				"(output-port? (current-output-port))",
+				"(port? 'po)",
+			},
+			outputs: []string{
+				"po",
+				"\"in stringpo\"",
+				"\"12\"",
+				"3",
+				"#t",
+				"#f",
+			},
+		},
+		{
+			name: "console input",
+			inputs: []string{
+				"(input-port? (current-input-port))",
+				"(read-char)x",
+				"(peek-char) 42",
+				"(read-line)rest of line",
+				"(read-string 3)abc",
+				"(read)(1 2)",
+				"(read-char (current-input-port));",
+			},
+			outputs: []string{
+				"#t",
+				"#\\x",
+				"#\\space",
+				"42",
+				"\"rest of line\"",
+				"\"abc\"",
+				"(1 2)",
+				"#\\;",
+			},
+		},
+		{
+			name: "parameters",
+			inputs: []string{
+				"(define radix (make-parameter 10 (lambda (x) (if (number? x) x 10))))",
+				"(radix)",
+				"(parameterize ((radix 2)) (radix))",
+				"(parameterize ((radix 'po)) (radix))",
+				"(radix)",
+			},
+			outputs: []string{
+				"10",
+				"2",
+				"10",
+				"10",
+			},
+		},
+		{
+			name: "write and display",
+			inputs: []string{
+				"(list \"a\\\"b\\\\c\\n\" #\\x 'po '|po po|)",
+				"(with-output-to-string (lambda () (write \"a\\tb\")))",
+				"(with-output-to-string (lambda () (display (list \"a\" #\\b 'c))))",
+				"(define x (list 1 2 3))",
+				"(set-cdr! (cddr x) x)",
+				"x",
+				"(with-output-to-string (lambda () (display x)))",
+				"(define y (list 'a))",
+				"(with-output-to-string (lambda () (write (list y y))))",
+				"(with-output-to-string (lambda () (write-shared (list y y))))",
+				"(string->symbol \"1\")",
+			},
+			outputs: []string{
+				"(\"a\\\"b\\\\c\\n\" #\\x po |po po|)",
+				"\"\\\"a\\\\tb\\\"\"",
+				"\"(a b c)\"",
+				"#0=(1 2 3 . #0#)",
+				"\"#0=(1 2 3 . #0#)\"",
+				"\"((a) (a))\"",
+				"\"(#0=(a) #0#)\"",
+				"|1|",
+			},
+		},
+		{
+			name: "datum labels",
+			inputs: []string{
+				"'#0=(a b . #0#)",
+				"'(#0=a #0# #1=#0# #1#)",
+				"(define z '(#0=(a) #0# #1=#2=(b . #2#) #1#))",
+				"(list (eq? (car z) (cadr z)) (eq? (caddr z) (cdr (caddr z))) (eq? (caddr z) (cadddr z)))",
+				"(define (reread write obj) (read (open-input-string (with-output-to-string (lambda () (write obj))))))",
+				"(define x (list 1 2 3))",
+				"(set-cdr! (cddr x) x)",
+				"(define c (reread write x))",
+				"(list (eq? c (cdddr c)) c)",
+				"(define y (list 'a))",
+				"(define s (reread write-shared (list y (cons y y))))",
+				"(list (eq? (car s) (caadr s)) (eq? (car s) (cdadr s)) s)",
+				"(read (open-input-string \"(#1#)\"))",
+				"(read (open-input-string \"(#1=a #1=b)\"))",
+				"(read (open-input-string \"#1=#1#\"))",
+				"'#0#",
+			},
+			outputs: []string{
+				"#0=(a b . #0#)",
+				"(a a a a)",
+				"(#t #t #t)",
+				"(#t #0=(1 2 3 . #0#))",
+				"(#t #t ((a) ((a) a)))",
+				"error: an error occurred while reading from input: an error occurred while parsing node: undefined datum label: #1#",
+				"error: an error occurred while reading from input: an error occurred while parsing node: duplicate datum label: #1=",
+				"error: an error occurred while reading from input: datum label refers to itself: #1=#1#",
+				"An error occurred while parsing next input: an error occurred while parsing quote: undefined datum label: #0#",
+			},
+		},
+		{
+			name: "equivalence",
+			inputs: []string{
+				"(eq? (list 1) (list 1))",
+				"(define l (list 1))",
+				"(eq? l l)",
+				"(eqv? l (list 1))",
+				"(equal? (list 1 (list 2)) (list 1 (list 2)))",
+				"(list (eq? 2 2) (eqv? 1.5 1.5) (eq? #\\a #\\a) (eq? '() '()) (eq? 'a 'a))",
+				"(eq? (string-append \"a\") (string-append \"a\"))",
+				"(equal? (string-append \"a\") (string-append \"a\"))",
+				"(define (f) 1)",
+				"(define (g) 1)",
+				"(list (eq? f f) (eqv? f g) (equal? f g) (eq? car car))",
+				"(equal? (lambda (x) x) (lambda (x) x))",
+				"(define p (delay 1))",
+				"(list (eq? p p) (equal? (delay 1) (delay 1)))",
+				"(memq (list 1) (list (list 1)))",
+				"(member (list 1) (list (list 1)))",
+				"(assv 2 '((1 . a) (2 . b)))",
+				"(list (eqv? 2 2.0) (eqv? 0 0.0) (eqv? 100000000 100000000.0) (equal? 2 2.0) (= 2 2.0))",
+				"(list (eqv? 2.0 2.0) (eqv? (+ 1 1) 2) (eqv? (+ 1.5 0.5) 2) (eqv? (+ 1.5 0.5) 2.0) (eqv? (* 2 1.0) 2))",
+				"(list (eqv? (sqrt 4) 2) (eqv? (floor 2.5) 2) (eqv? (string->number \"2.0\") 2) (memv 2.0 '(1 2 3)))",
+				"(list 2.0 (* 2 1.0) +inf.0 -inf.0 (string->number \"-inf.0\"))",
+				"(let ((x (* 2 1.0))) (eqv? x (read (open-input-string (number->string x)))))",
+			},
+			outputs: []string{
+				"#f",
+				"#t",
+				"#f",
+				"#t",
+				"(#t #t #t #t #t)",
+				"#f",
+				"#t",
+				"(#t #f #f #t)",
+				"#f",
+				"(#t #f)",
+				"#f",
+				"((1))",
+				"(2 . b)",
+				"(#f #f #f #f #t)",
+				"(#t #t #f #t #f)",
+				"(#t #f #f #f)",
+				"(2.0 2.0 +inf.0 -inf.0 -inf.0)",
+				"#t",
+			},
+		},
+		{
+			name: "prelude",
+			inputs: []string{
+				"(list (second '(1 2 3)) (third '(1 2 3)) (last '(1 2 3)))",
+				"(list (any even? '(1 3 4)) (every odd? '(1 3 4)) (find even? '(1 2 4)) (list-index even? '(1 2 4)))",
+				"(list (count odd? '(1 2 3)) (remove odd? '(1 2 3)) (partition odd? '(1 2 3)))",
+				"(filter-map (lambda (x) (and (even? x) (* x x))) '(1 2 3 4))",
+				"(append-map (lambda (x) (list x x)) '(1 2))",
+				"(delete-duplicates '(1 2 1 3 2))",
+				"(list (make-list 2 'x) (list-tabulate 3 square))",
+				"(stream->list (stream-map square (stream-take 3 (stream-from 1))))",
+				"(stream-ref (stream-append (list->stream '(1 2)) (stream-from 10)) 3)",
+				"(begin (stream-for-each display (list->stream '(1 2 3))) (newline))",
+				"(define (filter pred lst) 'redefined)",
+				"(define (map f lst) 'redefined)",
+				"(list (remove odd? '(1 2 3)) (partition odd? '(1 2 3)) (filter-map (lambda (x) x) '(1 #f)) (list-tabulate 2 square))",
+				"(define (car x) 'redefined)",
+				"(define (null? x) 'redefined)",
+				"(list (find even? '(1 2 4)) (any even? '(1 3 4)) (every odd? '(1 3)) (last '(1 2 3)) (make-list 2 'x))",
+				"(define (stream-car s) 'redefined)",
+				"(stream->list (stream-map square (stream-take 3 (stream-from 1))))",
+			},
+			outputs: []string{
+				"(2 3 3)",
+				"(#t #f 2 1)",
+				"(2 (2) ((1 3) (2)))",
+				"(4 16)",
+				"(1 1 2 2)",
+				"(1 2 3)",
+				"((x x) (0 1 4))",
+				"(1 4 9)",
+				"11",
+				"123",
+				"((2) ((1 3) (2)) (1) (0 1))",
+				"(2 #t #t 3 (x x))",
+				"(1 4 9)",
+			},
+		},
+		{
+			name: "libraries",
+			inputs: []string{
+				"(define-library (stack) (export make push (rename top peek)) (import (scheme base)) (begin (define (make) '()) (define (push s x) (cons x s)) (define (top s) (car s))))",
+				"(import (stack))",
+				"(peek (push (make) 1))",
+				"(import (prefix (only (stack) push) s:))",
+				"(s:push '() 2)",
+				"(import (rename (except (stack) make) (peek stack-top)))",
+				"(stack-top '(3))",
+				"(define-library (counter) (export next) (begin (define n 0) (define (next) (set! n (+ n 1)) n)))",
+				"(import (counter))",
+				"(list (next) (next))",
+				"(import (only (stack) top))",
+				"(import (nothing))",
+				"(define-library (broken) (export missing))",
+				"(if #t (import (stack)))",
+			},
+			outputs: []string{
+				"1",
+				"(2)",
+				"3",
+				"(1 2)",
+				"error: import: top is not exported by (stack)",
+				"error: import: library (nothing) not found in library path",
+				"error: define-library: (broken) exports missing, which is not defined",
+				"error: bad syntax: import is only allowed at top level",
+			},
+		},
+		{
+			name: "symbols",
+			inputs: []string{
+				"(eq? 'abc (string->symbol \"abc\"))",
+				"(eq? 'abc 'abd)",
+				"(define g (gensym))",
+				"(symbol? g)",
+				"(eq? g g)",
+				"(eq? g (string->symbol (symbol->string g)))",
+				"(eq? (gensym 'x) (gensym 'x))",
+				"(symbol? (generate-uninterned-symbol \"tmp\"))",
+				"(gensym 1)",
+			},
+			outputs: []string{
+				"#t",
+				"#f",
+				"#t",
+				"#t",
+				"#f",
+				"#f",
+				"#t",
+				"error: gensym: expected 1st argument to be string or symbol, but got 1",
+			},
+		},
+		{
+			name: "circular structure",
+			inputs: []string{
+				"(define x (list 1 2))",
+				"(set-cdr! (cdr x) x)",
+				"(define y (list 1 2 1 2))",
+				"(set-cdr! (cdddr y) y)",
+				"(list? x)",
+				"(list? (cdr x))",
+				"(equal? x y)",
+				"(equal? x (list 1 2 1 2))",
+				"(define z (list 1))",
+				"(set-car! z z)",
+				"(equal? z (let ((w (list 1))) (set-car! w w) w))",
+				"(length x)",
+				"(car 1 x)",
+				"(define w (list 0 1 2))",
+				"(set-cdr! (cddr w) (cdr w))",
+				"(last-pair w)",
+				"(list-copy w)",
+			},
+			outputs: []string{
+				"#f",
+				"#f",
+				"#t",
+				"#f",
+				"#t",
+				"error: length: expected 0-th argument to be a list, but got (1 2 . ...)",
+				"error: expected length of argument to be 1, but got 2",
+				"error: last-pair: expected finite list, but got (0 1 2 . ...)",
+				"error: list-copy: expected finite list, but got (0 1 2 . ...)",
+			},
+		},
+		{
+			name: "malformed input",
+			inputs: []string{
+				"(define x (list 1 2))",
+				"(set-cdr! (cdr x) x)",
+				"(last-pair x)",
+				"(list-copy x)",
+				"(memv 3 x)",
+				"(assv 3 x)",
+				"(cond ((+ 1 2)) (else 4))",
+				"(cond (else))",
+				"(define)",
+				"(define-syntax m (syntax-rules () (() 1)))",
+			},
+			outputs: []string{
+				"error: last-pair: expected finite list, but got (1 2 . ...)",
+				"error: list-copy: expected finite list, but got (1 2 . ...)",
+				"error: memv: expected 2nd argument to be a list, but got (1 2 . ...)",
+				"error: assv: expected 1-th argument to be a list, but got (1 2 . ...)",
+				"3",
+				"error: bad syntax: cond else branch needs at least 1 expression",
+				"error: bad syntax: define takes exactly 2 arguments, but got 0",
+				"error: bad macro syntax: malformed branch: expected \"_\" in the first element of the branch matcher",
+			},
+		},
+		{
+			name: "threads",
+			inputs: []string{
+				"(define t (make-thread (lambda () (* 6 7)) 'answer))",
+				"(thread-name t)",
+				"(eq? (thread-start! t) t)",
+				"(thread-join! t)",
+				"(thread-start! t)",
+				"(define counter 0)",
+				"(define m (make-mutex))",
+				"(define (work n) (if (> n 0) (begin (mutex-lock! m) (set! counter (+ counter 1)) (mutex-unlock! m) (work (- n 1)))))",
+				"(define threads (map (lambda (i) (thread-start! (make-thread (lambda () (work 100))))) (iota 4)))",
+				"(for-each thread-join! threads)",
+				"counter",
+				"(define self (make-thread (lambda () (current-thread))))",
+				"(eq? (thread-join! (thread-start! self)) self)",
+				"(thread-name (current-thread))",
+				"(thread-join! (current-thread))",
+				"(thread-join! (thread-start! (make-thread (lambda () (car 1)))))",
+			},
+			outputs: []string{
+				"answer",
+				"#t",
+				"42",
+				"error: thread-start!: thread is already started",
+				"400",
+				"#t",
+				"primordial",
+				"error: thread-join!: cannot join the current thread",
+				"error: thread-join!: <thread> terminated with error: car: expected cons but got number",
+			},
+		},
+		{
+			name: "parameters in threads",
+			inputs: []string{
+				"(define p (make-parameter 'global))",
+				"(define in (make-channel))",
+				"(define out (make-channel))",
+				"(define t (go (lambda () (parameterize ((p 'thread)) (channel-send out (p)) (channel-receive in) (p)))))",
+				"(channel-receive out)",
+				"(p)",
+				"(parameterize ((p 'main)) (channel-send in 'go) (list (p) (thread-join! t)))",
+				"(parameterize ((p 'parent)) (thread-join! (go p)))",
+				"(define t (go (lambda () (with-output-to-string (lambda () (display \"a\") (channel-send out 'ready) (channel-receive in) (display \"b\"))))))",
+				"(channel-receive out)",
+				"(begin (display \"main\") (newline))",
+				"(list (with-output-to-string (lambda () (channel-send in 'go) (display \"c\"))) (thread-join! t))",
+			},
+			outputs: []string{
+				"thread",
+				"global",
+				"(main thread)",
+				"parent",
+				"ready",
+				"main",
+				"(\"c\" \"ab\")",
+			},
+		},
+		{
+			name: "mutexes and condition variables",
+			inputs: []string{
+				"(define m (make-mutex 'm))",
+				"(mutex-state m)",
+				"(mutex-lock! m)",
+				"(eq? (mutex-state m) (current-thread))",
+				"(mutex-lock! m 0.01)",
+				"(thread-join! (thread-start! (make-thread (lambda () (mutex-lock! m 0.01)))))",
+				"(mutex-unlock! m)",
+				"(mutex-unlock! m)",
+				"(mutex-lock! m #f #f)",
+				"(mutex-state m)",
+				"(mutex-unlock! m)",
+				"(define cv (make-condition-variable))",
+				"(define queue '())",
+				"(define (consume) (mutex-lock! m) (if (null? queue) (begin (mutex-unlock! m cv) (consume)) (let ((x (car queue))) (set! queue (cdr queue)) (mutex-unlock! m) x)))",
+				"(define consumer (thread-start! (make-thread consume)))",
+				"(thread-join! consumer 0.01 'timeout)",
+				"(mutex-lock! m)",
+				"(set! queue (list 'item))",
+				"(condition-variable-signal! cv)",
+				"(mutex-unlock! m)",
+				"(thread-join! consumer)",
+				"(define looping (thread-start! (make-thread (lambda () (let loop () (loop))))))",
+				"(thread-terminate! looping)",
+				"(thread-join! looping)",
+				"(mutex-unlock! m cv 0.01)",
+			},
+			outputs: []string{
+				"not-abandoned",
+				"#t",
+				"#t",
+				"#f",
+				"#f",
+				"#t",
+				"error: mutex-unlock!: mutex is not locked",
+				"#t",
+				"not-owned",
+				"#t",
+				"timeout",
+				"#t",
+				"#t",
+				"item",
+				"error: thread-join!: thread was terminated",
+				"error: mutex-unlock!: mutex is not locked",
+			},
+		},
+		{
+			name: "lexical scope",
+			inputs: []string{
+				"(define x 'global)",
+				"(define (f x) (lambda () x))",
+				"((f 'local))",
+				"(define (make-counter) (let ((n 0)) (lambda () (set! n (+ n 1)) n)))",
+				"(define c (make-counter))",
+				"(c)",
+				"(c)",
+				"(define (g) (define y 1) (define (h) (+ y 1)) (set! y 10) (h))",
+				"(g)",
+				"(define (cond-define b) (if b (define z 'defined)) z)",
+				"(cond-define #t)",
+				"(cond-define #f)",
+				"(let* ((a 1) (b (+ a 1)) (a (* b 10))) (list a b))",
+				"(let ((x 'let)) (let ((f (lambda () x))) (let ((x 'inner)) (f))))",
+				"((lambda (a . rest) (define b 2) (list a b rest)) 1 3 4)",
+				"(define (set-global) (set! x 'changed))",
+				"(set-global)",
+				"x",
+				"(define (set-undefined) (define w (begin (set! w 1) 2)) w)",
+				"(set-undefined)",
+			},
+			outputs: []string{
+				"local",
+				"1",
+				"2",
+				"11",
+				"defined",
+				"error: unbound identifier: z",
+				"(20 2)",
+				"let",
+				"(1 2 (3 4))",
+				"changed",
+				"2",
+			},
+		},
+		{
+			name: "channels",
+			inputs: []string{
+				"(define c (make-channel 2))",
+				"(channel? c)",
+				"(channel-send c 1)",
+				"(channel-send c 2)",
+				"(channel-close c)",
+				"(channel-receive c)",
+				"(channel-receive c)",
+				"(eof-object? (channel-receive c))",
+				"(channel-send c 3)",
+				"(channel-close c)",
+				"(define (produce c n) (if (> n 0) (begin (channel-send c n) (produce c (- n 1))) (channel-close c)))",
+				"(define (consume c sum) (let ((x (channel-receive c))) (if (eof-object? x) sum (consume c (+ sum x)))))",
+				"(define c (make-channel))",
+				"(thread? (go produce c 10))",
+				"(consume c 0)",
+				"(define c (make-channel))",
+				"(select ((receive c x) x) ((timeout 0.01) 'timeout))",
+				"(select ((receive c) 'received) (else 'nothing))",
+				"(go (lambda () (channel-send c 'hello)))",
+				"(select ((receive c x) (list 'got x)) ((timeout 1) 'timeout))",
+				"(define d (make-channel 1))",
+				"(select ((send d 'sent)) ((receive c x) x))",
+				"(select ((receive d)))",
+				"(channel-close d)",
+				"(eof-object? (select ((receive d x) x)))",
+				"(select ((send d 1) 'sent))",
+				"(select ((receive 1) 'received))",
+				"(make-channel -1)",
+			},
+			outputs: []string{
+				"#t",
+				"1",
+				"2",
+				"#t",
+				"error: channel-send: channel is closed",
+				"error: channel-close: channel is closed",
+				"#t",
+				"55",
+				"timeout",
+				"nothing",
+				"<thread>",
+				"(got hello)",
+				"sent",
+				"#t",
+				"error: select: channel is closed",
+				"error: select: expected channel, but got 1",
+				"error: make-channel: expected 0-th argument to be non-negative integer, but got -1",
+			},
+		},
+	}
+	for _, engine := range []Engine{EngineClosure, EngineVM} {
+		engine := engine
+		for _, tt := range tests {
+			tt := tt
+			t.Run(engine.String()+"/"+tt.name, func(t *testing.T) {
+				t.Parallel()
+				out := &bytes.Buffer{}
+				interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(tt.inputs, "\n")))), out, false, 0, WithEngine(engine))
+				interpreter.ReadLoop()
+
+				expectOut := strings.Join(tt.outputs, "\n") + "\n"
+
+				if gotOut := out.String(); gotOut != expectOut {
+					t.Errorf("gotOut %v, want %v", gotOut, expectOut)
+				}
+			})
+		}
+	}
+}
+
+func TestWithoutPrelude(t *testing.T) {
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, WithoutPrelude())
+	res, err := interpreter.EvalString(context.Background(), "(filter odd? '(1 2 3))")
+	if err != nil {
+		t.Fatalf("EvalString() error = %v", err)
+	}
+	if got, want := printer.Write(res), "(1 3)"; got != want {
+		t.Errorf("EvalString() = %v, want %v", got, want)
+	}
+
+	_, err = interpreter.EvalString(context.Background(), "(last '(1 2))")
+	wantErr := "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: last"
+	if err == nil || err.Error() != wantErr {
+		t.Errorf("EvalString() error = %v, want %v", err, wantErr)
+	}
+}
+
+func TestEvalString(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "last value",
+			src:  "(define (double x) (* x 2))\n(double 21)",
+			want: "42",
+		},
+		{
+			name: "no forms",
+			src:  "; comment only",
+			want: "<void>",
+		},
+		{
+			name:    "parse error",
+			src:     "(define x 1)\n  (+ x 1))",
+			wantErr: "2:10: parse error: unexpected right parenthesis",
+		},
+		{
+			name:    "unclosed",
+			src:     "(define x\n  (+ 1",
+			wantErr: "2:6: parse error: an error occurred while parsing node: an error occurred while parsing node: end of input",
+		},
+		{
+			name:    "runtime error",
+			src:     "(define x 1)\n\n   (car x)",
+			wantErr: "3:4: runtime error: car: expected cons but got number",
+		},
+		{
+			name:    "macro error",
+			src:     "(define-syntax m (syntax-rules () ((_) (m))))\n(m)",
+			wantErr: "2:1: macro error: ",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+			res, err := interpreter.EvalString(context.Background(), tt.src)
+			if tt.wantErr != "" {
+				var lispErr *Error
+				if !errors.As(err, &lispErr) || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("EvalString() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalString() error = %v", err)
+			}
+			if got := printer.Write(res); got != tt.want {
+				t.Errorf("EvalString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalStringCancel(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{
+			name: "tail call",
+			src:  "(define (loop) (loop))\n(loop)",
+		},
+		{
+			name: "inside map",
+			src:  "(define (loop) (loop))\n(map (lambda (x) (loop)) '(1))",
+		},
+		{
+			name: "inside argument and let",
+			src:  "(define (loop) (loop))\n(let ((x (+ 1 (loop)))) x)",
+		},
+		{
+			name: "ignoring errors",
+			src:  "(define (loop) (list? (loop)) (loop))\n(loop)",
+		},
+		{
+			name: "channel receive",
+			src:  "(channel-receive (make-channel))",
+		},
+		{
+			name: "select",
+			src:  "(define c (make-channel))\n(select ((receive c x) x) ((send c 1) 1))",
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
-			out := &bytes.Buffer{}
-			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(tt.inputs, "\n")))), out, false, 0)
-			interpreter.ReadLoop()
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, err := interpreter.EvalString(ctx, tt.src); err != context.DeadlineExceeded {
+				t.Errorf("EvalString() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestReadLoopTimeout(t *testing.T) {
+	inputs := []string{
+		"(define (loop) (loop))",
+		"(for-each (lambda (x) (loop)) '(1 2))",
+		"42",
+	}
+	out := &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(inputs, "\n")))), out, false, 10*time.Millisecond)
+	interpreter.ReadLoop()
+	if want := "Timed out.\n42\n"; out.String() != want {
+		t.Errorf("gotOut %v, want %v", out.String(), want)
+	}
+}
+
+func TestReadLoopTimeoutThreads(t *testing.T) {
+	inputs := []string{
+		"(define t (thread-start! (make-thread (lambda () (thread-sleep! 0.05) 'done))))",
+		"(define c (make-channel))",
+		"(define u (go (lambda () (channel-receive c))))",
+		"(channel-send c 'hello)",
+		"(list (thread-join! t) (thread-join! u))",
+		"(define (loop) (loop))",
+		"(define v (go loop))",
+		"(thread-join! v)",
+		"(begin (thread-terminate! v) (thread-join! v 1 'terminated))",
+		"(define w (go loop))",
+	}
+	out := &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(inputs, "\n")))), out, false, 200*time.Millisecond)
+	interpreter.ReadLoop()
+	if want := "(done hello)\nTimed out.\nerror: thread-join!: thread was terminated\n"; out.String() != want {
+		t.Errorf("gotOut %v, want %v", out.String(), want)
+	}
+
+	// threads still running are terminated when ReadLoop returns
+	res, err := interpreter.EvalString(context.Background(), "(thread-join! w 1 'running)")
+	if err == nil && printer.Write(res) == "running" {
+		t.Error("thread started by ReadLoop is still running after ReadLoop returned")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  Limits
+		src     string
+		want    string
+		wantErr string
+	}{
+		{
+			name:    "steps",
+			limits:  Limits{MaxSteps: 1000},
+			src:     "(define (loop) (loop))\n(loop)",
+			wantErr: "2:1: runtime error: step limit exceeded: evaluated more than 1000 steps",
+		},
+		{
+			name:    "steps ignoring errors",
+			limits:  Limits{MaxSteps: 1000},
+			src:     "(define (loop) (list? (loop)) (loop))\n(loop)",
+			wantErr: "2:1: runtime error: step limit exceeded",
+		},
+		{
+			name:   "steps within limit",
+			limits: Limits{MaxSteps: 1000},
+			src:    "(define (sum n) (if (= n 0) 0 (+ n (sum (- n 1)))))\n(sum 10)",
+			want:   "55",
+		},
+		{
+			name:    "depth",
+			limits:  Limits{MaxDepth: 1000, MaxFrames: 1000},
+			src:     "(define (f n) (+ 1 (f n)))\n(f 0)",
+			wantErr: "2:1: runtime error: recursion depth limit exceeded: nested more than 1000 ",
+		},
+		{
+			name: "default depth",
+			src:  "(define (make-list* n) (if (= n 0) '() (cons n (make-list* (- n 1)))))\n(define (sum lst) (if (null? lst) 0 (+ (car lst) (sum (cdr lst)))))\n(sum (make-list* 100000))",
+			want: "5000050000",
+		},
+		{
+			name:    "depth through map",
+			limits:  Limits{MaxDepth: 1 << 30},
+			src:     "(define (f n) (car (map f (list n))))\n(f 1)",
+			wantErr: "2:1: runtime error: recursion depth limit exceeded: nested more than 10000 calls from builtins",
+		},
+		{
+			name:   "depth through map within reentry limit",
+			limits: Limits{MaxReentries: 20000},
+			src:    "(define (f n) (if (= n 0) 0 (+ 1 (car (map f (list (- n 1)))))))\n(f 15000)",
+			want:   "15000",
+		},
+		{
+			name:    "reentry limit",
+			limits:  Limits{MaxReentries: 100},
+			src:     "(define (f n) (if (= n 0) 0 (+ 1 (car (map f (list (- n 1)))))))\n(f 1000)",
+			wantErr: "2:1: runtime error: recursion depth limit exceeded: nested more than 100 calls from builtins",
+		},
+		{
+			name:    "depth through force",
+			limits:  Limits{MaxDepth: 1 << 30},
+			src:     "(define (f) (force (delay (+ 1 (f)))))\n(f)",
+			wantErr: "2:1: runtime error: recursion depth limit exceeded: nested more than 10000 calls from builtins",
+		},
+		{
+			name:   "tail calls do not nest",
+			limits: Limits{MaxDepth: 100},
+			src:    "(define (count n) (if (= n 0) 'done (count (- n 1))))\n(count 10000)",
+			want:   "done",
+		},
+		{
+			name: "tail calls through apply",
+			src:  "(define (loop n) (if (= n 0) 'done (apply loop (list (- n 1)))))\n(loop 2000000)",
+			want: "done",
+		},
+		{
+			name:   "tail calls through nested apply do not nest",
+			limits: Limits{MaxDepth: 100},
+			src:    "(define (loop n) (if (= n 0) 'done (apply apply loop (list (list (- n 1))))))\n(loop 10000)",
+			want:   "done",
+		},
+		{
+			name:    "conses",
+			limits:  Limits{MaxConses: 100},
+			src:     "(length (iota 1000))",
+			wantErr: "1:1: runtime error: allocation limit exceeded: allocated more than 100 pairs",
+		},
+		{
+			name:    "conses by cons",
+			limits:  Limits{MaxConses: 100},
+			src:     "(define (build n acc) (if (= n 0) acc (build (- n 1) (cons n acc))))\n(build 1000 '())",
+			wantErr: "2:1: runtime error: allocation limit exceeded: allocated more than 100 pairs",
+		},
+		{
+			name:    "string bytes",
+			limits:  Limits{MaxStringBytes: 1000},
+			src:     "(define (grow s) (grow (string-append s s)))\n(grow \"a\")",
+			wantErr: "2:1: runtime error: allocation limit exceeded: allocated more than 1000 bytes of strings",
+		},
+		{
+			name:    "symbols by string->symbol",
+			limits:  Limits{MaxStringBytes: 1000},
+			src:     "(define (loop) (string->symbol \"abcdefghij\") (loop))\n(loop)",
+			wantErr: "2:1: runtime error: allocation limit exceeded: allocated more than 1000 bytes of strings",
+		},
+		{
+			name:    "symbols by read",
+			limits:  Limits{MaxStringBytes: 1000},
+			src:     "(define (loop) (read (open-input-string \"(abcde fghij)\")) (loop))\n(loop)",
+			wantErr: "2:1: runtime error: allocation limit exceeded: allocated more than 1000 bytes of strings",
+		},
+		{
+			name:    "output bytes",
+			limits:  Limits{MaxOutputBytes: 100},
+			src:     "(define (loop) (display \"hello\") (loop))\n(loop)",
+			wantErr: "2:1: runtime error: output limit exceeded: wrote more than 100 bytes",
+		},
+		{
+			name:   "output within limit",
+			limits: Limits{MaxOutputBytes: 100},
+			src:    "(with-output-to-string (lambda () (display \"hello\")))",
+			want:   "\"hello\"",
+		},
+	}
+	for _, engine := range []Engine{EngineClosure, EngineVM} {
+		engine := engine
+		for _, tt := range tests {
+			tt := tt
+			t.Run(engine.String()+"/"+tt.name, func(t *testing.T) {
+				t.Parallel()
+				interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, WithLimits(tt.limits), WithEngine(engine))
+				res, err := interpreter.EvalString(context.Background(), tt.src)
+				if tt.wantErr != "" {
+					if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+						t.Errorf("EvalString() error = %v, want %v", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("EvalString() error = %v", err)
+				}
+				if got := printer.Write(res); got != tt.want {
+					t.Errorf("EvalString() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestVMDeepRecursion(t *testing.T) {
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0,
+		WithEngine(EngineVM), WithLimits(Limits{MaxFrames: 2000000}))
+	src := `(define (sum n) (if (= n 0) 0 (+ n (sum (- n 1)))))
+(define (build n) (if (= n 0) '() (cons n (build (- n 1)))))
+(list (sum 1000000) (length (build 1000000)))`
+	res, err := interpreter.EvalString(context.Background(), src)
+	if err != nil {
+		t.Fatalf("EvalString() error = %v", err)
+	}
+	if got, want := printer.Write(res), "(500000500000 1000000)"; got != want {
+		t.Errorf("EvalString() = %v, want %v", got, want)
+	}
+}
 
-			expectOut := strings.Join(tt.outputs, "\n") + "\n"
+func TestVMDeepRecursionDefaultLimits(t *testing.T) {
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0,
+		WithEngine(EngineVM))
+	src := `(define (sum n) (if (= n 0) 0 (+ n (sum (- n 1)))))
+(sum 100000)`
+	res, err := interpreter.EvalString(context.Background(), src)
+	if err != nil {
+		t.Fatalf("EvalString() error = %v", err)
+	}
+	if got, want := printer.Write(res), "5000050000"; got != want {
+		t.Errorf("EvalString() = %v, want %v", got, want)
+	}
+}
+
+func TestReadLoopLimits(t *testing.T) {
+	inputs := []string{
+		"(define (loop) (loop))",
+		"(loop)",
+		"(define (count n) (if (= n 0) 'done (count (- n 1))))",
+		"(count 10)",
+	}
+	out := &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(inputs, "\n")))), out, false, 0, WithLimits(Limits{MaxSteps: 1000}))
+	interpreter.ReadLoop()
+	if want := "error: step limit exceeded: evaluated more than 1000 steps\ndone\n"; out.String() != want {
+		t.Errorf("gotOut %v, want %v", out.String(), want)
+	}
+}
 
-			if gotOut := out.String(); gotOut != expectOut {
-				t.Errorf("gotOut %v, want %v", gotOut, expectOut)
+func TestBuiltinSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		src     string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "pure arithmetic and lists",
+			opts: []Option{WithBuiltins(BuiltinsPure)},
+			src:  "(map (lambda (x) (* x x)) (iota 3))",
+			want: "(0 1 4)",
+		},
+		{
+			name:    "pure without output",
+			opts:    []Option{WithBuiltins(BuiltinsPure)},
+			src:     "(display 1)",
+			wantErr: "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: display",
+		},
+		{
+			name:    "pure without read",
+			opts:    []Option{WithBuiltins(BuiltinsPure)},
+			src:     "(read)",
+			wantErr: "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: read",
+		},
+		{
+			name:    "pure without string ports",
+			opts:    []Option{WithBuiltins(BuiltinsPure)},
+			src:     "(open-input-string \"po\")",
+			wantErr: "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: open-input-string",
+		},
+		{
+			name: "io with ports",
+			opts: []Option{WithBuiltins(BuiltinsIO)},
+			src:  "(with-output-to-string (lambda () (write (read (open-input-string \"(1 2)\")))))",
+			want: "\"(1 2)\"",
+		},
+		{
+			name:    "io without files",
+			opts:    []Option{WithBuiltins(BuiltinsIO), WithFileRoot(".")},
+			src:     "(file-exists? \"interpreter.go\")",
+			wantErr: "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: file-exists?",
+		},
+		{
+			name: "full with files",
+			opts: []Option{WithFileRoot(".")},
+			src:  "(file-exists? \"interpreter.go\")",
+			want: "#t",
+		},
+		{
+			name: "custom env",
+			opts: []Option{WithEnv(mustNewEnv(t, "+", "car"))},
+			src:  "(+ 1 (car '(2 3)))",
+			want: "3",
+		},
+		{
+			name:    "custom env without others",
+			opts:    []Option{WithEnv(mustNewEnv(t, "+", "car"))},
+			src:     "(cdr '(2 3))",
+			wantErr: "1:1: runtime error: expected function in 0-th argument, but got error: unbound identifier: cdr",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, tt.opts...)
+			res, err := interpreter.EvalString(context.Background(), tt.src)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("EvalString() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EvalString() error = %v", err)
+			}
+			if got := printer.Write(res); got != tt.want {
+				t.Errorf("EvalString() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, name := range []string{"display", "read", "random", "no-such-builtin"} {
+		if _, err := NewEnv(name); err == nil {
+			t.Errorf("NewEnv(%v) expected error", name)
+		}
+	}
+}
+
+func mustNewEnv(t *testing.T, names ...string) *object.Env {
+	env, err := NewEnv(names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestConcurrentEvalString(t *testing.T) {
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if _, err := interpreter.EvalString(context.Background(), "(define m (make-mutex))\n(define total 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for idx := 0; idx < n; idx++ {
+		idx := idx
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("(define (sum-%v k) (if (= k 0) 0 (+ k (sum-%v (- k 1)))))\n"+
+				"(mutex-lock! m)\n(set! total (+ total (sum-%v 100)))\n(mutex-unlock! m)", idx, idx, idx)
+			if _, err := interpreter.EvalString(context.Background(), src); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	res, err := interpreter.EvalString(context.Background(), "total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.String(), fmt.Sprint(n*5050); got != want {
+		t.Errorf("total = %v, want %v", got, want)
+	}
+}
+
+func TestConcurrentRandom(t *testing.T) {
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	const n = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for idx := 0; idx < n; idx++ {
+		idx := idx
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("(random-seed %v)\n(random 10)\n(random 1.5)", idx)
+			if _, err := interpreter.EvalString(context.Background(), src); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentForce(t *testing.T) {
+	var chain func(n int) object.Object
+	chain = func(n int) object.Object {
+		if n == 0 {
+			return object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+				return list([]object.Object{object.NewNumberObject(0)})
+			})
+		}
+		return object.NewGoDelayForceObject(func(ctx context.Context) object.Object {
+			return chain(n - 1)
+		})
+	}
+	var promises []object.Object
+	for idx := 0; idx < 100; idx++ {
+		idx := idx
+		promises = append(promises, object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+			return list([]object.Object{object.NewNumberObject(float64(idx))})
+		}), chain(10))
+	}
+
+	const n = 8
+	results := make([][]object.Object, n)
+	var wg sync.WaitGroup
+	for idx := 0; idx < n; idx++ {
+		idx := idx
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, p := range promises {
+				results[idx] = append(results[idx], object.Force(context.Background(), p))
+			}
+		}()
+	}
+	wg.Wait()
+	for i, p := range promises {
+		want := object.Force(context.Background(), p)
+		for idx := range results {
+			if got := results[idx][i]; got != want {
+				t.Errorf("Force() of promise %v in goroutine %v = %v, want the memoized %v", i, idx, got, want)
+			}
+		}
+	}
+}
+
+func TestErrorOutput(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), out, false, 0, WithErrorOutput(errOut))
+	if _, err := interpreter.EvalString(context.Background(), "(display \"po\" (current-error-port))\n(profile (+ 1 2))"); err != nil {
+		t.Fatalf("EvalString() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("output = %q, want empty", out.String())
+	}
+	if !strings.HasPrefix(errOut.String(), "poprofile: ") {
+		t.Errorf("error output = %q, want the display and the profile report", errOut.String())
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	src := `(define (fib n) (if (< n 2) n (+ (fib (- n 1)) (fib (- n 2)))))
+(define (build n) (if (= n 0) '() (cons n (build (- n 1)))))
+(define p (open-output-string))
+(define res (parameterize ((current-error-port p))
+  (profile (begin (build 100) (thread-join! (go build 50)) (list 1 2) (fib 15)))))
+(list res (get-output-string p))`
+	for _, engine := range []Engine{EngineClosure, EngineVM} {
+		engine := engine
+		t.Run(engine.String(), func(t *testing.T) {
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, WithEngine(engine))
+			res, err := interpreter.EvalString(context.Background(), src)
+			if err != nil {
+				t.Fatalf("EvalString() error = %v", err)
+			}
+			if got := res.Pair()[0]; got.String() != "610" {
+				t.Errorf("profile result = %v, want 610", got)
+			}
+			report := res.Pair()[1].Pair()[0].Str()
+			for _, pattern := range []string{
+				`^profile: \S+ elapsed, \d+ samples\n`,
+				`\n +\S+ +[0-9.]+ +150 +0  build\n`,
+				`\n +\S+ +[0-9.]+ +2 +0  \(other\)\n`,
+			} {
+				if !regexp.MustCompile(pattern).MatchString(report) {
+					t.Errorf("report %q does not match %v", report, pattern)
+				}
 			}
 		})
 	}
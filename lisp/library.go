@@ -0,0 +1,367 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// libraryExt is the extension of library files, searched in the library path.
+const libraryExt = ".sld"
+
+// WithLibraryPath sets the directories to search for library files imported by import.
+// The library (foo bar) is loaded from the file foo/bar.sld under the first directory containing it.
+// Libraries other than builtin libraries can be imported only with BuiltinsFull.
+func WithLibraryPath(dirs ...string) Option {
+	return func(i *Interpreter) {
+		i.libraryPath = dirs
+	}
+}
+
+// library is a library defined by define-library, with its own global environment.
+type library struct {
+	name string
+	env  *object.Env
+	// exports maps the external names to the internal names of the exported variables
+	exports map[string]string
+}
+
+type loadingLibrariesKey struct{}
+
+// isBuiltinLibrary returns true if the library is provided by the builtins, such as (scheme base) and (srfi 1).
+// Builtin libraries export all builtins of the interpreter.
+func isBuiltinLibrary(name []string) bool {
+	return len(name) > 0 && (name[0] == "scheme" || name[0] == "srfi")
+}
+
+// libraryName parses the name of a library, a list of identifiers and non-negative integers.
+func libraryName(n *node.Node) ([]string, bool) {
+	if n.Type != node.Branch || len(n.Children) == 0 {
+		return nil, false
+	}
+	parts := make([]string, len(n.Children))
+	for idx, part := range n.Children {
+		switch {
+		case part.Type == node.Identifier:
+			parts[idx] = part.Str
+		case part.Type == node.Number && part.Num >= 0 && part.Num == float64(int64(part.Num)):
+			parts[idx] = part.String()
+		default:
+			return nil, false
+		}
+	}
+	return parts, true
+}
+
+// libraryKey returns the key of the library name, as written in programs.
+func libraryKey(name []string) string {
+	return "(" + strings.Join(name, " ") + ")"
+}
+
+// eval evaluates the top-level form in the global environment env, handling the forms only allowed at top level.
+func (i *Interpreter) eval(ctx context.Context, n *node.Node, env *object.Env) object.Object {
+	if n.Type == node.Branch && len(n.Children) > 0 && n.Children[0].Type == node.Keyword {
+		switch n.Children[0].Str {
+		case "define-library":
+			return i.defineLibrary(ctx, n)
+		case "import":
+			return i.importAll(ctx, n.Children[1:], env)
+		}
+	}
+	return i.engine.eval(ctx, n, env)
+}
+
+// defineLibrary evaluates (define-library name declaration ...), and registers the library.
+// The declarations are (export spec ...), (import import-set ...) and (begin form ...), evaluated in order.
+func (i *Interpreter) defineLibrary(ctx context.Context, n *node.Node) object.Object {
+	if len(n.Children) < 2 {
+		return object.NewErrorObject("bad syntax: define-library needs a library name")
+	}
+	name, ok := libraryName(n.Children[1])
+	if !ok {
+		return object.NewErrorObject(fmt.Sprintf("bad syntax: define-library: invalid library name %v", n.Children[1]))
+	}
+	if isBuiltinLibrary(name) {
+		return object.NewErrorObject(fmt.Sprintf("define-library: cannot redefine builtin library %v", libraryKey(name)))
+	}
+
+	lib := &library{name: libraryKey(name), env: object.NewGlobalEnv(i.builtins), exports: make(map[string]string)}
+	for _, decl := range n.Children[2:] {
+		if decl.Type != node.Branch || len(decl.Children) == 0 {
+			return object.NewErrorObject(fmt.Sprintf("bad syntax: define-library: invalid declaration %v", decl))
+		}
+		var errObj object.Object
+		switch head := decl.Children[0]; {
+		case head.Type == node.Identifier && head.Str == "export":
+			errObj = lib.export(decl.Children[1:])
+		case head.Type == node.Keyword && head.Str == "import":
+			errObj = i.importAll(ctx, decl.Children[1:], lib.env)
+		case head.Type == node.Keyword && head.Str == "begin":
+			errObj = i.evalBody(ctx, decl.Children[1:], lib.env)
+		default:
+			return object.NewErrorObject(fmt.Sprintf("bad syntax: define-library: unknown declaration %v", decl))
+		}
+		if errObj != nil && errObj.Type() == object_type.Err {
+			return errObj
+		}
+	}
+	for external, internal := range lib.exports {
+		if _, ok := lib.env.Lookup(internal); !ok {
+			return object.NewErrorObject(fmt.Sprintf("define-library: %v exports %v, which is not defined", lib.name, external))
+		}
+	}
+
+	i.librariesMu.Lock()
+	i.libraries[lib.name] = lib
+	i.librariesMu.Unlock()
+	return object.VoidObj
+}
+
+// export adds the export specs, an identifier or (rename internal external).
+func (lib *library) export(specs []*node.Node) object.Object {
+	for _, spec := range specs {
+		switch {
+		case spec.Type == node.Identifier:
+			lib.exports[spec.Str] = spec.Str
+		case spec.Type == node.Branch && len(spec.Children) == 3 &&
+			spec.Children[0].Type == node.Identifier && spec.Children[0].Str == "rename" &&
+			spec.Children[1].Type == node.Identifier && spec.Children[2].Type == node.Identifier:
+			lib.exports[spec.Children[2].Str] = spec.Children[1].Str
+		default:
+			return object.NewErrorObject(fmt.Sprintf("bad syntax: export: invalid export spec %v", spec))
+		}
+	}
+	return nil
+}
+
+// evalBody evaluates the forms in order in the global environment of a library.
+func (i *Interpreter) evalBody(ctx context.Context, forms []*node.Node, env *object.Env) object.Object {
+	for _, form := range forms {
+		form, err := i.expand(env, form, currentFile(ctx))
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("an error occurred while applying macro: %v", err))
+		}
+		if res := i.eval(ctx, form, env); res.Type() == object_type.Err {
+			return res
+		}
+	}
+	return nil
+}
+
+// importAll evaluates the import sets of (import import-set ...), and defines the imported variables in env.
+func (i *Interpreter) importAll(ctx context.Context, sets []*node.Node, env *object.Env) object.Object {
+	for _, set := range sets {
+		bindings, errObj := i.importSet(ctx, set)
+		if errObj != nil {
+			return errObj
+		}
+		for name, v := range bindings {
+			env.Define(name, v)
+		}
+	}
+	return object.VoidObj
+}
+
+// importSet returns the variables imported by the import set, which is a library name or one of
+// (only import-set id ...), (except import-set id ...), (prefix import-set prefix)
+// and (rename import-set (from to) ...).
+// Variables are imported by their values at the time of import.
+func (i *Interpreter) importSet(ctx context.Context, set *node.Node) (map[string]object.Object, object.Object) {
+	if set.Type != node.Branch || len(set.Children) == 0 {
+		return nil, object.NewErrorObject(fmt.Sprintf("bad syntax: import: invalid import set %v", set))
+	}
+	head := set.Children[0]
+	if head.Type == node.Identifier && len(set.Children) >= 2 && set.Children[1].Type == node.Branch {
+		switch head.Str {
+		case "only", "except":
+			bindings, errObj := i.importSet(ctx, set.Children[1])
+			if errObj != nil {
+				return nil, errObj
+			}
+			selected := make(map[string]object.Object)
+			for _, id := range set.Children[2:] {
+				if id.Type != node.Identifier {
+					return nil, object.NewErrorObject(fmt.Sprintf("bad syntax: import: %v expects identifiers, but got %v", head.Str, id))
+				}
+				v, ok := bindings[id.Str]
+				if !ok {
+					return nil, object.NewErrorObject(fmt.Sprintf("import: %v is not exported by %v", id.Str, set.Children[1]))
+				}
+				selected[id.Str] = v
+			}
+			if head.Str == "only" {
+				return selected, nil
+			}
+			for name := range selected {
+				delete(bindings, name)
+			}
+			return bindings, nil
+		case "prefix":
+			if len(set.Children) != 3 || set.Children[2].Type != node.Identifier {
+				return nil, object.NewErrorObject(fmt.Sprintf("bad syntax: import: prefix expects an import set and an identifier, but got %v", set))
+			}
+			bindings, errObj := i.importSet(ctx, set.Children[1])
+			if errObj != nil {
+				return nil, errObj
+			}
+			prefixed := make(map[string]object.Object, len(bindings))
+			for name, v := range bindings {
+				prefixed[set.Children[2].Str+name] = v
+			}
+			return prefixed, nil
+		case "rename":
+			bindings, errObj := i.importSet(ctx, set.Children[1])
+			if errObj != nil {
+				return nil, errObj
+			}
+			renamed := make(map[string]object.Object, len(bindings))
+			for name, v := range bindings {
+				renamed[name] = v
+			}
+			for _, pair := range set.Children[2:] {
+				if pair.Type != node.Branch || len(pair.Children) != 2 ||
+					pair.Children[0].Type != node.Identifier || pair.Children[1].Type != node.Identifier {
+					return nil, object.NewErrorObject(fmt.Sprintf("bad syntax: import: rename expects pairs of identifiers, but got %v", pair))
+				}
+				from, to := pair.Children[0].Str, pair.Children[1].Str
+				v, ok := bindings[from]
+				if !ok {
+					return nil, object.NewErrorObject(fmt.Sprintf("import: %v is not exported by %v", from, set.Children[1]))
+				}
+				delete(renamed, from)
+				renamed[to] = v
+			}
+			return renamed, nil
+		}
+	}
+
+	name, ok := libraryName(set)
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("bad syntax: import: invalid import set %v", set))
+	}
+	if isBuiltinLibrary(name) {
+		builtins := make(map[string]object.Object, len(i.builtins))
+		for k, v := range i.builtins {
+			builtins[k] = v
+		}
+		return builtins, nil
+	}
+	if i.builtinSet < BuiltinsFull {
+		return nil, object.NewErrorObject(fmt.Sprintf("import: library %v is not available with %v builtins", libraryKey(name), i.builtinSet))
+	}
+	lib, errObj := i.library(ctx, name)
+	if errObj != nil {
+		return nil, errObj
+	}
+	bindings := make(map[string]object.Object, len(lib.exports))
+	for external, internal := range lib.exports {
+		v, _ := lib.env.Lookup(internal)
+		bindings[external] = v
+	}
+	return bindings, nil
+}
+
+// library returns the library of the name, loading it from the library path if not loaded yet.
+func (i *Interpreter) library(ctx context.Context, name []string) (*library, object.Object) {
+	key := libraryKey(name)
+	i.librariesMu.Lock()
+	lib, ok := i.libraries[key]
+	i.librariesMu.Unlock()
+	if ok {
+		return lib, nil
+	}
+
+	// detect cyclic imports among the libraries being loaded by this evaluation
+	loading, _ := ctx.Value(loadingLibrariesKey{}).([]string)
+	for _, l := range loading {
+		if l == key {
+			return nil, object.NewErrorObject(fmt.Sprintf("import: cyclic import of %v", key))
+		}
+	}
+	ctx = context.WithValue(ctx, loadingLibrariesKey{}, append(loading[:len(loading):len(loading)], key))
+
+	f, err := i.openLibrary(name)
+	if err != nil {
+		return nil, object.NewErrorObject(fmt.Sprintf("import: %v", err))
+	}
+	path := f.Name()
+	if errObj := i.loadLibraryFile(ctx, f); errObj != nil {
+		return nil, errObj
+	}
+
+	i.librariesMu.Lock()
+	lib, ok = i.libraries[key]
+	i.librariesMu.Unlock()
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("import: %v does not define library %v", path, key))
+	}
+	return lib, nil
+}
+
+// validLibraryFileName returns true if each part of the library name can be used as a path element,
+// so that the file of the library is under the library directory.
+func validLibraryFileName(name []string) bool {
+	for _, part := range name {
+		if part == "" || part == "." || part == ".." || filepath.IsAbs(part) ||
+			strings.ContainsAny(part, `/`+string(filepath.Separator)) {
+			return false
+		}
+	}
+	return true
+}
+
+// openLibrary opens the file of the library in the library path.
+// Files resolving outside of their library directory (e.g. via symbolic links) are not used,
+// and the file is opened by openBeneath, not following symbolic links replaced after resolved.
+func (i *Interpreter) openLibrary(name []string) (*os.File, error) {
+	if !validLibraryFileName(name) {
+		return nil, fmt.Errorf("invalid library name %v", libraryKey(name))
+	}
+	rel := filepath.Join(name...) + libraryExt
+	for _, dir := range i.libraryPath {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		path, err := filepath.EvalSymlinks(filepath.Join(realDir, rel))
+		if err != nil || !within(realDir, path) {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		f, err := openBeneath(realDir, path, os.O_RDONLY, 0)
+		if err != nil {
+			continue
+		}
+		if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+			f.Close()
+			continue
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("library %v not found in library path", libraryKey(name))
+}
+
+// loadLibraryFile evaluates the define-library forms in the opened file, and closes the file.
+func (i *Interpreter) loadLibraryFile(ctx context.Context, f *os.File) object.Object {
+	path := f.Name()
+	forms, err := readForms(f)
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("import: %v", err))
+	}
+	for _, n := range forms {
+		if n.Type != node.Branch || len(n.Children) == 0 || n.Children[0].Type != node.Keyword || n.Children[0].Str != "define-library" {
+			return object.NewErrorObject(fmt.Sprintf("import: %v:%v: expected define-library", path, n.Pos))
+		}
+		if res := i.defineLibrary(ctx, n); res.Type() == object_type.Err {
+			return res
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package lisp
+
+import (
+	"bytes"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLibraryPath(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "lib")
+	if err := os.MkdirAll(filepath.Join(dir, "util"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"util/counter.sld": `(define-library (util counter)
+  (export next)
+  (begin
+    (display "loading counter")
+    (newline)
+    (define n 0)
+    (define (next) (set! n (+ n 1)) n)))`,
+		"util/twice.sld": `(define-library (util twice)
+  (export twice)
+  (import (util counter))
+  (begin (define (twice) (next) (next))))`,
+		"cycle.sld":  `(define-library (cycle) (export x) (import (cycle2)) (begin (define x 1)))`,
+		"cycle2.sld": `(define-library (cycle2) (export y) (import (cycle)) (begin (define y 1)))`,
+		"wrong.sld":  `(define x 1)`,
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// library outside of the library directory
+	secret := filepath.Join(filepath.Dir(dir), "secret", "key.sld")
+	if err := os.MkdirAll(filepath.Dir(secret), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(secret, []byte(`(define-library (secret key) (export x) (begin (define x 1)))`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link.sld")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		inputs  []string
+		outputs []string
+	}{
+		{
+			name: "loaded once",
+			opts: []Option{WithLibraryPath(t.TempDir(), dir)},
+			inputs: []string{
+				"(import (util twice) (util counter))",
+				"(twice)",
+				"(next)",
+			},
+			outputs: []string{
+				"loading counter",
+				"2",
+				"3",
+			},
+		},
+		{
+			name: "errors",
+			opts: []Option{WithLibraryPath(dir)},
+			inputs: []string{
+				"(import (cycle))",
+				"(import (wrong))",
+				"(import (util missing))",
+			},
+			outputs: []string{
+				"error: import: cyclic import of (cycle)",
+				"error: import: " + filepath.Join(dir, "wrong.sld") + ":1:1: expected define-library",
+				"error: import: library (util missing) not found in library path",
+			},
+		},
+		{
+			name: "outside of library path",
+			opts: []Option{WithLibraryPath(dir)},
+			inputs: []string{
+				"(import (.. secret key))",
+				"(import (../secret/key))",
+				"(import (link))",
+			},
+			outputs: []string{
+				"error: import: invalid library name (.. secret key)",
+				"error: import: invalid library name (../secret/key)",
+				"error: import: library (link) not found in library path",
+			},
+		},
+		{
+			name: "pure builtins",
+			opts: []Option{WithLibraryPath(dir), WithBuiltins(BuiltinsPure)},
+			inputs: []string{
+				"(import (util counter))",
+				"(import (only (scheme base) car))",
+				"(car '(1 2))",
+			},
+			outputs: []string{
+				"error: import: library (util counter) is not available with pure builtins",
+				"1",
+			},
+		},
+		{
+			name: "no library path",
+			inputs: []string{
+				"(import (util counter))",
+			},
+			outputs: []string{
+				"error: import: library (util counter) not found in library path",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			out := &bytes.Buffer{}
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(tt.inputs, "\n")))), out, false, 0, tt.opts...)
+			interpreter.ReadLoop()
+			if want := strings.Join(tt.outputs, "\n") + "\n"; out.String() != want {
+				t.Errorf("gotOut %v, want %v", out.String(), want)
+			}
+		})
+	}
+}
@@ -0,0 +1,278 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMaxDepth is the maximum depth of nested evaluation used if Limits.MaxDepth is zero.
+// Deeper recursion would eventually overflow the Go stack and crash the whole process.
+const DefaultMaxDepth = 1000000
+
+// DefaultMaxFrames is the maximum number of frames of the virtual machine used if Limits.MaxFrames is zero.
+const DefaultMaxFrames = 1000000
+
+// DefaultMaxReentries is the maximum depth of evaluation re-entered by builtins used if Limits.MaxReentries is zero.
+// Each re-entry nests the Go calls of the builtin and the engine, using a few kilobytes of the Go stack,
+// so that deeper recursion through builtins would overflow the Go stack before reaching DefaultMaxDepth.
+const DefaultMaxReentries = 10000
+
+// Limits are the limits of resources used by each top-level evaluation, i.e. each form read by ReadLoop
+// and each call to EvalString or EvalReader. Exceeding a limit results in an error object, and every later step
+// of the same evaluation results in the same error, so that programs ignoring the error cannot continue.
+// Zero values mean no limits, except for MaxDepth, MaxFrames and MaxReentries.
+type Limits struct {
+	// MaxSteps is the maximum number of evaluation steps.
+	MaxSteps int64
+	// MaxDepth is the maximum depth of nested (non-tail) evaluation on the Go stack. DefaultMaxDepth is used if zero.
+	// Calls between functions evaluated by EngineVM do not nest on the Go stack, and are limited by MaxFrames instead.
+	MaxDepth int
+	// MaxFrames is the maximum number of frames of calls in the virtual machine of EngineVM. DefaultMaxFrames is used if zero.
+	MaxFrames int
+	// MaxReentries is the maximum depth of evaluation re-entered by builtins calling functions or forcing promises,
+	// such as map, for-each and force. DefaultMaxReentries is used if zero.
+	// Raising it allows deeper recursion through builtins, using a few kilobytes of the Go stack per re-entry.
+	MaxReentries int
+	// MaxConses is the maximum number of pairs allocated by function calls and builtins.
+	MaxConses int64
+	// MaxStringBytes is the maximum total bytes of strings created by builtins,
+	// including the names of symbols made by string->symbol and read, which are interned and never freed.
+	MaxStringBytes int64
+	// MaxOutputBytes is the maximum total bytes written to output ports.
+	MaxOutputBytes int64
+}
+
+// WithLimits sets the limits of resources used by each top-level evaluation.
+func WithLimits(l Limits) Option {
+	return func(i *Interpreter) {
+		i.limits = l
+	}
+}
+
+type evalStateKey struct{}
+
+// evalState tracks the resources used by an evaluation.
+type evalState struct {
+	limits *Limits
+	// usage is the resources used so far, shared among goroutines of the same evaluation
+	usage *usage
+	// depth is the current depth of nested evaluation in this thread
+	depth int
+	// reentries is the current depth of evaluation re-entered by builtins in this thread
+	reentries int
+}
+
+type usage struct {
+	steps, conses, stringBytes, outputBytes int64
+	// failed is set to 1 after err is set, to check without locking mu
+	failed int32
+	// err is the error object of the first exceeded limit
+	err object.Object
+	mu  sync.Mutex
+}
+
+// fail records the error object of an exceeded limit, and returns the error object recorded first.
+func (s *evalState) fail(errObj object.Object) object.Object {
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	if s.usage.err == nil {
+		s.usage.err = errObj
+		atomic.StoreInt32(&s.usage.failed, 1)
+	}
+	return s.usage.err
+}
+
+// failed returns the error object of the first exceeded limit, or nil if no limits have been exceeded.
+func (s *evalState) failed() object.Object {
+	if atomic.LoadInt32(&s.usage.failed) == 0 {
+		return nil
+	}
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	return s.usage.err
+}
+
+// withEvalState returns a new context tracking resource usage against the given limits.
+func withEvalState(ctx context.Context, limits Limits) context.Context {
+	if limits.MaxDepth == 0 {
+		limits.MaxDepth = DefaultMaxDepth
+	}
+	if limits.MaxFrames == 0 {
+		limits.MaxFrames = DefaultMaxFrames
+	}
+	if limits.MaxReentries == 0 {
+		limits.MaxReentries = DefaultMaxReentries
+	}
+	return context.WithValue(ctx, evalStateKey{}, &evalState{limits: &limits, usage: &usage{}})
+}
+
+// forkEvalState returns a new context for evaluation on another thread, sharing the resource usage with ctx
+// but tracking the depth of nested evaluation and re-entries separately.
+func forkEvalState(ctx context.Context) context.Context {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, evalStateKey{}, &evalState{limits: s.limits, usage: s.usage})
+}
+
+// evalStateOf returns the evaluation state of the context, or nil if resource usage is not tracked.
+func evalStateOf(ctx context.Context) *evalState {
+	s, _ := ctx.Value(evalStateKey{}).(*evalState)
+	return s
+}
+
+// exceeded adds n to the counter, and returns true if the result exceeds max, where max of zero means no limit.
+func exceeded(counter *int64, n int64, max int64) bool {
+	return atomic.AddInt64(counter, n) > max && max > 0
+}
+
+// limitError returns the error object of the first exceeded limit in the context, or nil if no limits have been exceeded.
+func limitError(ctx context.Context) object.Object {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil
+	}
+	return s.failed()
+}
+
+// step records an evaluation step, and returns an error object if the step limit is exceeded.
+func step(ctx context.Context) object.Object {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil
+	}
+	if errObj := s.failed(); errObj != nil {
+		return errObj
+	}
+	if exceeded(&s.usage.steps, 1, s.limits.MaxSteps) {
+		return s.fail(object.NewErrorObject(fmt.Sprintf("step limit exceeded: evaluated more than %v steps", s.limits.MaxSteps)))
+	}
+	return nil
+}
+
+// enter records entering a nested evaluation, and returns an error object if the depth limit is exceeded.
+// leave must be called on the returned state on leaving the evaluation.
+func enter(ctx context.Context) (*evalState, object.Object) {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil, nil
+	}
+	if s.depth >= s.limits.MaxDepth {
+		return nil, s.fail(object.NewErrorObject(fmt.Sprintf("recursion depth limit exceeded: nested more than %v levels", s.limits.MaxDepth)))
+	}
+	s.depth++
+	return s, nil
+}
+
+// leave records leaving a nested evaluation entered by enter.
+func (s *evalState) leave() {
+	if s != nil {
+		s.depth--
+	}
+}
+
+// reenter records a builtin re-entering evaluation, and returns an error object if the depth of re-entries exceeds
+// Limits.MaxReentries.
+// leaveReentry must be called on the returned state on returning to the builtin.
+func reenter(ctx context.Context) (*evalState, object.Object) {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil, nil
+	}
+	if s.reentries >= s.limits.MaxReentries {
+		return nil, s.fail(object.NewErrorObject(fmt.Sprintf("recursion depth limit exceeded: nested more than %v calls from builtins", s.limits.MaxReentries)))
+	}
+	s.reentries++
+	return s, nil
+}
+
+// leaveReentry records returning to the builtin which re-entered evaluation by reenter.
+func (s *evalState) leaveReentry() {
+	if s != nil {
+		s.reentries--
+	}
+}
+
+// maxFrames returns the maximum number of frames of the virtual machine in the context, or 0 if not limited.
+func maxFrames(ctx context.Context) int {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return 0
+	}
+	return s.limits.MaxFrames
+}
+
+// allocate records allocation of pairs and string bytes, and returns an error object if a limit is exceeded.
+func allocate(ctx context.Context, conses int, stringBytes int) object.Object {
+	if profiling() {
+		profileTrackOf(ctx).allocate(conses, stringBytes)
+	}
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil
+	}
+	if exceeded(&s.usage.conses, int64(conses), s.limits.MaxConses) {
+		return s.fail(object.NewErrorObject(fmt.Sprintf("allocation limit exceeded: allocated more than %v pairs", s.limits.MaxConses)))
+	}
+	if exceeded(&s.usage.stringBytes, int64(stringBytes), s.limits.MaxStringBytes) {
+		return s.fail(object.NewErrorObject(fmt.Sprintf("allocation limit exceeded: allocated more than %v bytes of strings", s.limits.MaxStringBytes)))
+	}
+	return nil
+}
+
+// allocateString records allocation of the string, and returns the string object or an error object if a limit is exceeded.
+func allocateString(ctx context.Context, str string) object.Object {
+	if errObj := allocate(ctx, 0, len(str)); errObj != nil {
+		return errObj
+	}
+	return object.NewStringObject(str)
+}
+
+// allocateSymbol records allocation of the name of the symbol, and returns the interned symbol object
+// or an error object if a limit is exceeded. Interned symbols are shared by all interpreters and never freed.
+func allocateSymbol(ctx context.Context, name string) object.Object {
+	if errObj := allocate(ctx, 0, len(name)); errObj != nil {
+		return errObj
+	}
+	return object.NewSymbolObject(name)
+}
+
+// symbolBytes returns the total bytes of the names of the identifiers in n, interned by the parser.
+func symbolBytes(n *node.Node) int {
+	total := 0
+	stack := []*node.Node{n}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if n.Type == node.Identifier {
+			total += len(n.Str)
+		}
+		stack = append(stack, n.Children...)
+	}
+	return total
+}
+
+// allocateList records allocation of the list, and returns the list or an error object if a limit is exceeded.
+func allocateList(ctx context.Context, objects []object.Object) object.Object {
+	if errObj := allocate(ctx, len(objects), 0); errObj != nil {
+		return errObj
+	}
+	return list(objects)
+}
+
+// output records output of n bytes, and returns an error object if the output limit is exceeded.
+func output(ctx context.Context, n int) object.Object {
+	s := evalStateOf(ctx)
+	if s == nil {
+		return nil
+	}
+	if exceeded(&s.usage.outputBytes, int64(n), s.limits.MaxOutputBytes) {
+		return s.fail(object.NewErrorObject(fmt.Sprintf("output limit exceeded: wrote more than %v bytes", s.limits.MaxOutputBytes)))
+	}
+	return nil
+}
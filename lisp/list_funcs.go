@@ -0,0 +1,461 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"math"
+	"sort"
+)
+
+func init() {
+	defaultEnv["length"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			elements, errObj := listArg("length", 0, objects[0])
+			if errObj != nil {
+				return errObj
+			}
+			return object.NewNumberObject(float64(len(elements)))
+		}))
+	defaultEnv["append"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (append list1 ... obj)
+		if len(objects) == 0 {
+			return object.NullObj
+		}
+		elements := make([]object.Object, 0)
+		for i, o := range objects[:len(objects)-1] {
+			lst, errObj := listArg("append", i, o)
+			if errObj != nil {
+				return errObj
+			}
+			elements = append(elements, lst...)
+		}
+		if errObj := allocate(ctx, len(elements), 0); errObj != nil {
+			return errObj
+		}
+		return listWithTail(elements, objects[len(objects)-1])
+	})
+	defaultEnv["reverse"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			elements, errObj := listArg("reverse", 0, objects[0])
+			if errObj != nil {
+				return errObj
+			}
+			if errObj := allocate(ctx, len(elements), 0); errObj != nil {
+				return errObj
+			}
+			var res object.Object = object.NullObj
+			for _, elt := range elements {
+				res = object.NewConsObject(elt, res)
+			}
+			return res
+		}))
+	defaultEnv["list-tail"] = object.NewWrappedFunctionObject(
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
+			k, errObj := indexArg("list-tail", 1, objects[1])
+			if errObj != nil {
+				return errObj
+			}
+			lst := objects[0]
+			for ; k > 0; k-- {
+				if lst.Type() != object_type.Cons {
+					return object.NewErrorObject(fmt.Sprintf("list-tail: index %v is too large for list %v", objects[1], objects[0]))
+				}
+				lst = lst.Pair()[1]
+			}
+			return lst
+		}))
+	defaultEnv["list-ref"] = object.NewWrappedFunctionObject(
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
+			k, errObj := indexArg("list-ref", 1, objects[1])
+			if errObj != nil {
+				return errObj
+			}
+			lst := objects[0]
+			for ; k > 0 && lst.Type() == object_type.Cons; k-- {
+				lst = lst.Pair()[1]
+			}
+			if lst.Type() != object_type.Cons {
+				return object.NewErrorObject(fmt.Sprintf("list-ref: index %v is too large for list %v", objects[1], objects[0]))
+			}
+			return lst.Pair()[0]
+		}))
+	defaultEnv["last-pair"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			lst := objects[0]
+			if lst.Type() != object_type.Cons {
+				return object.NewErrorObject(fmt.Sprintf("last-pair: expected pair, but got %v", lst))
+			}
+			if object.IsCircular(lst) {
+				return object.NewErrorObject(fmt.Sprintf("last-pair: expected finite list, but got %v", lst))
+			}
+			for lst.Pair()[1].Type() == object_type.Cons {
+				lst = lst.Pair()[1]
+			}
+			return lst
+		}))
+	defaultEnv["list-copy"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			// copies the spine of the list, keeping the tail of an improper list
+			elements := make([]object.Object, 0)
+			lst := objects[0]
+			if object.IsCircular(lst) {
+				return object.NewErrorObject(fmt.Sprintf("list-copy: expected finite list, but got %v", lst))
+			}
+			for lst.Type() == object_type.Cons {
+				elements = append(elements, lst.Pair()[0])
+				lst = lst.Pair()[1]
+			}
+			if errObj := allocate(ctx, len(elements), 0); errObj != nil {
+				return errObj
+			}
+			return listWithTail(elements, lst)
+		}))
+	defaultEnv["iota"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		return makeNumbers(func(input []float64) object.Object {
+			// (iota count [start step])
+			if len(input) == 0 || len(input) > 3 {
+				return object.NewErrorObject(fmt.Sprintf("iota: expected 1 to 3 arguments, but got %v", len(input)))
+			}
+			count := input[0]
+			if count < 0 || count != math.Trunc(count) {
+				return object.NewErrorObject(fmt.Sprintf("iota: expected count to be non-negative integer, but got %v", count))
+			}
+			var start, step float64 = 0, 1
+			if len(input) >= 2 {
+				start = input[1]
+			}
+			if len(input) == 3 {
+				step = input[2]
+			}
+			if errObj := allocate(ctx, int(count), 0); errObj != nil {
+				return errObj
+			}
+			elements := make([]object.Object, int(count))
+			for i := range elements {
+				elements[i] = object.NewNumberObject(start + float64(i)*step)
+			}
+			return list(elements)
+		})(ctx, objects)
+	})
+
+	defaultEnv["memq"] = object.NewWrappedFunctionObject(makeMember("memq", isEq))
+	defaultEnv["memv"] = object.NewWrappedFunctionObject(makeMember("memv", isEqv))
+	defaultEnv["member"] = object.NewWrappedFunctionObject(makeMember("member", isEqual))
+	defaultEnv["assq"] = object.NewWrappedFunctionObject(makeAssoc("assq", isEq))
+	defaultEnv["assv"] = object.NewWrappedFunctionObject(makeAssoc("assv", isEqv))
+	defaultEnv["assoc"] = object.NewWrappedFunctionObject(makeAssoc("assoc", isEqual))
+
+	defaultEnv["for-each"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (for-each f list1 list2 ...)
+		f, lists, errObj := functionAndLists("for-each", objects)
+		if errObj != nil {
+			return errObj
+		}
+		for _, args := range zipLists(lists) {
+			if res := callWithTailOptimization(ctx, f.F, args); res.Type() == object_type.Err {
+				return res
+			}
+		}
+		return object.VoidObj
+	})
+	defaultEnv["filter"] = object.NewWrappedFunctionObject(
+		makeBinary(func(ctx context.Context, objects []object.Object) object.Object {
+			// (filter pred list)
+			pred, lists, errObj := functionAndLists("filter", objects)
+			if errObj != nil {
+				return errObj
+			}
+			res := make([]object.Object, 0)
+			for _, elt := range lists[0] {
+				ok := callWithTailOptimization(ctx, pred.F, []object.Object{elt})
+				if ok.Type() == object_type.Err {
+					return ok
+				}
+				if ok.IsTruthy() {
+					res = append(res, elt)
+				}
+			}
+			return allocateList(ctx, res)
+		}))
+	defaultEnv["delete"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (delete x list [=])
+		if len(objects) != 2 && len(objects) != 3 {
+			return object.NewErrorObject(fmt.Sprintf("delete: expected 2 or 3 arguments, but got %v", len(objects)))
+		}
+		x := objects[0]
+		elements, errObj := listArg("delete", 1, objects[1])
+		if errObj != nil {
+			return errObj
+		}
+		equals, errObj := comparatorArg(ctx, "delete", objects, 2, isEqual)
+		if errObj != nil {
+			return errObj
+		}
+		res := make([]object.Object, 0, len(elements))
+		for _, elt := range elements {
+			eq, errObj := equals(x, elt)
+			if errObj != nil {
+				return errObj
+			}
+			if !eq {
+				res = append(res, elt)
+			}
+		}
+		return allocateList(ctx, res)
+	})
+	defaultEnv["reduce"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (reduce f ridentity list)
+		if len(objects) != 3 {
+			return object.NewErrorObject(fmt.Sprintf("reduce: expected 3 arguments, but got %v", len(objects)))
+		}
+		f := objects[0]
+		if f.Type() != object_type.Function {
+			return object.NewErrorObject(fmt.Sprintf("reduce: expected 1st argument to be a function, but got %v", f))
+		}
+		elements, errObj := listArg("reduce", 2, objects[2])
+		if errObj != nil {
+			return errObj
+		}
+		if len(elements) == 0 {
+			return objects[1]
+		}
+		acc := elements[0]
+		for _, elt := range elements[1:] {
+			acc = callWithTailOptimization(ctx, f.F, []object.Object{elt, acc})
+			if acc.Type() == object_type.Err {
+				return acc
+			}
+		}
+		return acc
+	})
+	defaultEnv["fold-left"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (fold-left f init list1 list2 ...), calls (f acc elt1 elt2 ...)
+		if len(objects) < 3 {
+			return object.NewErrorObject(fmt.Sprintf("fold-left: expected at least 3 arguments, but got %v", len(objects)))
+		}
+		f, lists, errObj := functionAndLists("fold-left", append([]object.Object{objects[0]}, objects[2:]...))
+		if errObj != nil {
+			return errObj
+		}
+		acc := objects[1]
+		for _, args := range zipLists(lists) {
+			acc = callWithTailOptimization(ctx, f.F, append([]object.Object{acc}, args...))
+			if acc.Type() == object_type.Err {
+				return acc
+			}
+		}
+		return acc
+	})
+	defaultEnv["fold-right"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (fold-right f init list1 list2 ...), calls (f elt1 elt2 ... acc)
+		if len(objects) < 3 {
+			return object.NewErrorObject(fmt.Sprintf("fold-right: expected at least 3 arguments, but got %v", len(objects)))
+		}
+		f, lists, errObj := functionAndLists("fold-right", append([]object.Object{objects[0]}, objects[2:]...))
+		if errObj != nil {
+			return errObj
+		}
+		acc := objects[1]
+		zipped := zipLists(lists)
+		for i := len(zipped) - 1; i >= 0; i-- {
+			acc = callWithTailOptimization(ctx, f.F, append(zipped[i], acc))
+			if acc.Type() == object_type.Err {
+				return acc
+			}
+		}
+		return acc
+	})
+	defaultEnv["sort"] = object.NewWrappedFunctionObject(
+		makeBinary(func(ctx context.Context, objects []object.Object) object.Object {
+			// (sort list less?), stable
+			elements, errObj := listArg("sort", 0, objects[0])
+			if errObj != nil {
+				return errObj
+			}
+			less := objects[1]
+			if less.Type() != object_type.Function {
+				return object.NewErrorObject(fmt.Sprintf("sort: expected 2nd argument to be a function, but got %v", less))
+			}
+			var sortErr object.Object
+			sort.SliceStable(elements, func(i, j int) bool {
+				if sortErr != nil {
+					return false
+				}
+				res := callWithTailOptimization(ctx, less.F, []object.Object{elements[i], elements[j]})
+				if res.Type() == object_type.Err {
+					sortErr = res
+					return false
+				}
+				return res.IsTruthy()
+			})
+			if sortErr != nil {
+				return sortErr
+			}
+			return allocateList(ctx, elements)
+		}))
+}
+
+// equalityFunc compares two objects, and returns error object if the comparison failed.
+type equalityFunc func(o1, o2 object.Object) (bool, object.Object)
+
+// isEq compares two objects by identity, as eq? (memq, assq).
+func isEq(o1, o2 object.Object) (bool, object.Object) {
+	return object.Eq(o1, o2), nil
+}
+
+// isEqv compares two objects by identity, or numbers and chars by value, as eqv? (memv, assv).
+func isEqv(o1, o2 object.Object) (bool, object.Object) {
+	return object.Eqv(o1, o2), nil
+}
+
+// isEqual compares two objects structurally, as equal? (member, assoc, delete).
+func isEqual(o1, o2 object.Object) (bool, object.Object) {
+	return o1.Equals(o2), nil
+}
+
+// comparatorArg returns the optional comparator at objects[i] if given, or def otherwise.
+func comparatorArg(ctx context.Context, name string, objects []object.Object, i int, def equalityFunc) (equalityFunc, object.Object) {
+	if len(objects) <= i {
+		return def, nil
+	}
+	f := objects[i]
+	if f.Type() != object_type.Function {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be a function, but got %v", name, i, f))
+	}
+	return func(o1, o2 object.Object) (bool, object.Object) {
+		res := callWithTailOptimization(ctx, f.F, []object.Object{o1, o2})
+		if res.Type() == object_type.Err {
+			return false, res
+		}
+		return res.IsTruthy(), nil
+	}, nil
+}
+
+// makeMember makes memq, memv and member.
+func makeMember(name string, def equalityFunc) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		// (member x list [compare])
+		if len(objects) != 2 && len(objects) != 3 {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected 2 or 3 arguments, but got %v", name, len(objects)))
+		}
+		equals, errObj := comparatorArg(ctx, name, objects, 2, def)
+		if errObj != nil {
+			return errObj
+		}
+		x, lst := objects[0], objects[1]
+		if object.IsCircular(lst) {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected 2nd argument to be a list, but got %v", name, lst))
+		}
+		for lst.Type() == object_type.Cons {
+			eq, errObj := equals(x, lst.Pair()[0])
+			if errObj != nil {
+				return errObj
+			}
+			if eq {
+				return lst
+			}
+			lst = lst.Pair()[1]
+		}
+		if lst.Type() != object_type.Null {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected 2nd argument to be a list, but got %v", name, objects[1]))
+		}
+		return object.NewBooleanObject(false)
+	}
+}
+
+// makeAssoc makes assq, assv and assoc.
+func makeAssoc(name string, def equalityFunc) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		// (assoc x alist [compare])
+		if len(objects) != 2 && len(objects) != 3 {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected 2 or 3 arguments, but got %v", name, len(objects)))
+		}
+		equals, errObj := comparatorArg(ctx, name, objects, 2, def)
+		if errObj != nil {
+			return errObj
+		}
+		x := objects[0]
+		elements, errObj := listArg(name, 1, objects[1])
+		if errObj != nil {
+			return errObj
+		}
+		for _, pair := range elements {
+			if pair.Type() != object_type.Cons {
+				return object.NewErrorObject(fmt.Sprintf("%v: expected association list, but got element %v", name, pair))
+			}
+			eq, errObj := equals(x, pair.Pair()[0])
+			if errObj != nil {
+				return errObj
+			}
+			if eq {
+				return pair
+			}
+		}
+		return object.NewBooleanObject(false)
+	}
+}
+
+// listArg returns elements of the i-th argument o, or an error object if o is not a list.
+func listArg(name string, i int, o object.Object) ([]object.Object, object.Object) {
+	if !o.IsList() {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be a list, but got %v", name, i, o))
+	}
+	return o.ListElements(), nil
+}
+
+// indexArg returns the i-th argument o as a non-negative integer, or an error object otherwise.
+func indexArg(name string, i int, o object.Object) (int, object.Object) {
+	if o.Type() != object_type.Number || o.Number() < 0 || o.Number() != math.Trunc(o.Number()) {
+		return 0, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be non-negative integer, but got %v", name, i, o))
+	}
+	return int(o.Number()), nil
+}
+
+// functionAndLists validates arguments in the form of (f list1 list2 ...), and returns f and elements of the lists.
+func functionAndLists(name string, objects []object.Object) (object.Object, [][]object.Object, object.Object) {
+	if len(objects) < 2 {
+		return nil, nil, object.NewErrorObject(fmt.Sprintf("%v: expected at least 2 arguments, but got %v", name, len(objects)))
+	}
+	f := objects[0]
+	if f.Type() != object_type.Function {
+		return nil, nil, object.NewErrorObject(fmt.Sprintf("expected 1st argument of %v to be a function, but got %v", name, f))
+	}
+	lists := make([][]object.Object, len(objects)-1)
+	for i, lst := range objects[1:] {
+		elements, errObj := listArg(name, i+1, lst)
+		if errObj != nil {
+			return nil, nil, errObj
+		}
+		lists[i] = elements
+	}
+	return f, lists, nil
+}
+
+// zipLists returns the lists of i-th elements of each lists, stopping at the shortest list.
+func zipLists(lists [][]object.Object) [][]object.Object {
+	length := len(lists[0])
+	for _, lst := range lists[1:] {
+		if len(lst) < length {
+			length = len(lst)
+		}
+	}
+	zipped := make([][]object.Object, length)
+	for i := range zipped {
+		zipped[i] = make([]object.Object, len(lists))
+		for j, lst := range lists {
+			zipped[i][j] = lst[i]
+		}
+	}
+	return zipped
+}
+
+// listWithTail makes a list from the given objects, whose last cdr is tail.
+func listWithTail(objects []object.Object, tail object.Object) object.Object {
+	res := tail
+	for i := len(objects) - 1; i >= 0; i-- {
+		res = object.NewConsObject(objects[i], res)
+	}
+	return res
+}
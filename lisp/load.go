@@ -0,0 +1,201 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"os"
+	"path/filepath"
+)
+
+type loadingFilesKey struct{}
+
+// readForms parses all forms in the opened file, and closes the file.
+// Parse errors are reported with the path and the position in the file.
+func readForms(f *os.File) ([]*node.Node, error) {
+	defer f.Close()
+
+	path := f.Name()
+	p := node.NewParser(token.NewTokenizer(f))
+	var forms []*node.Node
+	for {
+		n, err := p.Next()
+		if err == node.EOF {
+			return forms, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %w", path, p.Pos(), err)
+		}
+		forms = append(forms, n)
+	}
+}
+
+// resolveFrom resolves the file name in a program against the directory of the file base,
+// or against the file root if base is empty.
+func (i *Interpreter) resolveFrom(base, name string) (string, error) {
+	if i.builtinSet < BuiltinsFull {
+		return "", ErrFileAccessDisabled
+	}
+	if base != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(filepath.Dir(base), name)
+	}
+	return i.files.resolve(name)
+}
+
+// loadingFiles returns the files being loaded by load in ctx, the innermost last.
+func loadingFiles(ctx context.Context) []string {
+	files, _ := ctx.Value(loadingFilesKey{}).([]string)
+	return files
+}
+
+// currentFile returns the file being loaded by load in ctx, or an empty string if none.
+func currentFile(ctx context.Context) string {
+	if files := loadingFiles(ctx); len(files) > 0 {
+		return files[len(files)-1]
+	}
+	return ""
+}
+
+// expand applies macros in the global environment env to n, and then splices the files included by include forms.
+// Relative paths of included files are resolved against the directory of the file base.
+func (i *Interpreter) expand(env *object.Env, n *node.Node, base string) (*node.Node, error) {
+	n, err := applyMacro(env, n)
+	if err != nil {
+		return nil, err
+	}
+	return i.expandIncludes(env, n, base, nil)
+}
+
+// expandIncludes replaces (include file ...) in n with (begin form ...) of the forms in the files,
+// which are expanded in turn. including are the files being included, to detect cyclic includes.
+// Quoted forms are left as they are.
+func (i *Interpreter) expandIncludes(env *object.Env, n *node.Node, base string, including []string) (*node.Node, error) {
+	if n.Type != node.Branch || len(n.Children) == 0 {
+		return n, nil
+	}
+	if head := n.Children[0]; head.Type == node.Keyword {
+		switch head.Str {
+		case "quote":
+			return n, nil
+		case "include":
+			return i.include(env, n, base, including)
+		}
+	}
+
+	var children []*node.Node
+	for idx, child := range n.Children {
+		expanded, err := i.expandIncludes(env, child, base, including)
+		if err != nil {
+			return nil, err
+		}
+		if expanded != child && children == nil {
+			children = make([]*node.Node, len(n.Children))
+			copy(children, n.Children[:idx])
+		}
+		if children != nil {
+			children[idx] = expanded
+		}
+	}
+	if children == nil {
+		return n, nil
+	}
+	return &node.Node{Type: node.Branch, Children: children, Pos: n.Pos}, nil
+}
+
+// include returns (begin form ...) of the forms in the files of (include file ...).
+func (i *Interpreter) include(env *object.Env, n *node.Node, base string, including []string) (*node.Node, error) {
+	if len(n.Children) < 2 {
+		return nil, fmt.Errorf("bad syntax: include needs at least one file name")
+	}
+	res := &node.Node{Type: node.Branch, Children: []*node.Node{{Type: node.Keyword, Str: "begin"}}, Pos: n.Pos}
+	for _, name := range n.Children[1:] {
+		if name.Type != node.String {
+			return nil, fmt.Errorf("include: expected file name to be string, but got %v", name)
+		}
+		path, err := i.resolveFrom(base, name.Str)
+		if err != nil {
+			return nil, fmt.Errorf("include: %v: %w", name.Str, err)
+		}
+		for _, f := range including {
+			if f == path {
+				return nil, fmt.Errorf("include: cyclic include of %v", path)
+			}
+		}
+		f, err := i.files.open(path, os.O_RDONLY)
+		if err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+		forms, err := readForms(f)
+		if err != nil {
+			return nil, fmt.Errorf("include: %w", err)
+		}
+		for _, form := range forms {
+			pos := form.Pos
+			form, err = applyMacro(env, form)
+			if err == nil {
+				form, err = i.expandIncludes(env, form, path, append(including[:len(including):len(including)], path))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%v:%v: %w", path, pos, err)
+			}
+			res.Children = append(res.Children, form)
+		}
+	}
+	if len(res.Children) == 1 {
+		// (begin) cannot be evaluated, so (if #f #f) evaluates to void instead
+		cond := &node.Node{Type: node.Boolean}
+		return &node.Node{Type: node.Branch, Children: []*node.Node{{Type: node.Keyword, Str: "if"}, cond, cond}, Pos: n.Pos}, nil
+	}
+	return res, nil
+}
+
+// defineLoadFuncs defines load, evaluating files under the file root of this interpreter to the given frame.
+func (i *Interpreter) defineLoadFuncs(global object.Frame) {
+	global["load"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			fileName := objects[0]
+			if fileName.Type() != object_type.Str {
+				return object.NewErrorObject(fmt.Sprintf("load: expected file name to be string, but got %v", fileName))
+			}
+			path, err := i.resolveFrom(currentFile(ctx), fileName.Str())
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("load: %v: %v", fileName.Str(), err))
+			}
+			return i.load(ctx, path)
+		}))
+}
+
+// load evaluates the forms in the file in order in the global environment.
+// Relative paths in load and include in the file are resolved against the directory of the file.
+func (i *Interpreter) load(ctx context.Context, path string) object.Object {
+	loading := loadingFiles(ctx)
+	for _, f := range loading {
+		if f == path {
+			return object.NewErrorObject(fmt.Sprintf("load: cyclic load of %v", path))
+		}
+	}
+	f, err := i.files.open(path, os.O_RDONLY)
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("load: %v", err))
+	}
+	forms, err := readForms(f)
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("load: %v", err))
+	}
+
+	ctx = context.WithValue(ctx, loadingFilesKey{}, append(loading[:len(loading):len(loading)], path))
+	for _, form := range forms {
+		pos := form.Pos
+		form, err := i.expand(i.globalEnv, form, path)
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("load: %v:%v: %v", path, pos, err))
+		}
+		if res := i.eval(ctx, form, i.globalEnv); res.Type() == object_type.Err {
+			return object.NewErrorObject(fmt.Sprintf("load: %v:%v: %v", path, pos, res.Str()))
+		}
+	}
+	return object.VoidObj
+}
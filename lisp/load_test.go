@@ -0,0 +1,152 @@
+package lisp
+
+import (
+	"bytes"
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAndInclude(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main.scm":       "(load \"lib/util.scm\")\n(define main-loaded #t)",
+		"lib/util.scm":   "(load \"helper.scm\")\n(define (double x) (helper x x))",
+		"lib/helper.scm": "(define (helper x y) (+ x y))",
+		"defs.scm":       "(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))\n(include \"lib/body.scm\")",
+		"lib/body.scm":   "(define x 1)\n(define y 2)",
+		"expr.scm":       "(* n 10)",
+		"empty.scm":      "",
+		"broken.scm":     "(define ok 1)\n(car '())",
+		"unclosed.scm":   "(define z",
+		"cycle.scm":      "(load \"cycle.scm\")",
+		"icycle.scm":     "(include \"icycle.scm\")",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		inputs  []string
+		outputs []string
+	}{
+		{
+			name: "load",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(load \"main.scm\")",
+				"(list main-loaded (double 21))",
+			},
+			outputs: []string{
+				"(#t 42)",
+			},
+		},
+		{
+			name: "include",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(include \"defs.scm\")",
+				"(swap! x y)",
+				"(list x y)",
+				"(define (f n) (include \"expr.scm\"))",
+				"(f 4)",
+				"(include \"empty.scm\")",
+				"'(include \"none.scm\")",
+			},
+			outputs: []string{
+				"(2 1)",
+				"40",
+				"(include \"none.scm\")",
+			},
+		},
+		{
+			name: "errors",
+			opts: []Option{WithFileRoot(root)},
+			inputs: []string{
+				"(load \"broken.scm\")",
+				"ok",
+				"(load \"unclosed.scm\")",
+				"(load \"cycle.scm\")",
+				"(include \"icycle.scm\")",
+				"(load 1)",
+			},
+			outputs: []string{
+				"error: load: " + filepath.Join(root, "broken.scm") + ":2:1: car: expected cons but got null",
+				"1",
+				"error: load: " + filepath.Join(root, "unclosed.scm") + ":1:9: an error occurred while parsing node: end of input",
+				"error: load: " + filepath.Join(root, "cycle.scm") + ":1:1: load: cyclic load of " + filepath.Join(root, "cycle.scm"),
+				"An error occurred while applying macro: " + filepath.Join(root, "icycle.scm") + ":1:1: include: cyclic include of " + filepath.Join(root, "icycle.scm"),
+				"error: load: expected file name to be string, but got 1",
+			},
+		},
+		{
+			name: "disabled",
+			inputs: []string{
+				"(load \"main.scm\")",
+				"(include \"expr.scm\")",
+			},
+			outputs: []string{
+				"error: load: main.scm: file access is disabled",
+				"An error occurred while applying macro: include: expr.scm: file access is disabled",
+			},
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			interpreter := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(strings.Join(tt.inputs, "\n")))), out, false, 0, tt.opts...)
+			interpreter.ReadLoop()
+			if want := strings.Join(tt.outputs, "\n") + "\n"; out.String() != want {
+				t.Errorf("gotOut %v, want %v", out.String(), want)
+			}
+		})
+	}
+}
+
+func TestLoadReplacedBySymlink(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "dir", "code.scm"), []byte("(define loaded 'inside)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(outside, "code.scm"), []byte("(define loaded 'outside)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, WithFileRoot(root))
+	path, err := i.resolveFrom("", "dir/code.scm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// replace the directory by a link to outside of the root, after the path is resolved
+	if err := os.Rename(filepath.Join(root, "dir"), filepath.Join(root, "moved")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "dir")); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := i.load(context.Background(), path); res.Type() != object_type.Err {
+		t.Errorf("load() = %v, want error", res)
+	}
+	if _, ok := i.globalEnv.Globals()["loaded"]; ok {
+		t.Error("load() followed the replaced link")
+	}
+}
@@ -23,6 +23,8 @@ Example:
 type Macro struct {
 	name     string
 	branches []*branch
+	// n is the define-syntax form the macro was created from
+	n *node.Node
 }
 
 type matcherType int
@@ -99,9 +101,15 @@ func NewMacro(n *node.Node) (*Macro, error) {
 	return &Macro{
 		name:     macroName,
 		branches: branches,
+		n:        n,
 	}, nil
 }
 
+// Node returns the define-syntax form the macro was created from.
+func (m *Macro) Node() *node.Node {
+	return m.n
+}
+
 // Replace checks the given node recursively, and applies the macro (once) if possible.
 func (m *Macro) Replace(n *node.Node) (res *node.Node, ok bool) {
 	if n.Type != node.Branch {
@@ -149,12 +157,14 @@ func newBranch(n *node.Node, allowedKeywords []string) (*branch, error) {
 
 	matcherCode := n.Children[0]
 	targetCode := n.Children[1]
-	if matcherCode.Type != node.Branch || matcherCode.Children[0].Type != node.Keyword ||
+	if matcherCode.Type != node.Branch || len(matcherCode.Children) == 0 || matcherCode.Children[0].Type != node.Keyword ||
 		matcherCode.Children[0].Str != "_" {
 		return nil, errors.New("expected \"_\" in the first element of the branch matcher")
 	}
-	// drop the first elt in the list which corresponds to the macro name
-	matcherCode.Children = matcherCode.Children[1:]
+	// drop the first elt in the list which corresponds to the macro name, keeping the define-syntax form intact
+	dropped := *matcherCode
+	dropped.Children = dropped.Children[1:]
+	matcherCode = &dropped
 
 	matcher, err := newMatcher(matcherCode, allowedKeywords)
 	if err != nil {
@@ -199,6 +209,7 @@ func (b *builder) buildTarget(target *matcher) *node.Node {
 			return &node.Node{
 				Type: node.Identifier,
 				Str:  target.str,
+				Sym:  node.Intern(target.str),
 			}
 		}
 	case data:
@@ -278,6 +289,8 @@ func newMatcherTarget(n *node.Node) (*matcher, error) {
 	case node.Boolean:
 		fallthrough
 	case node.String:
+		fallthrough
+	case node.Char:
 		return &matcher{matcherType: data, data: n}, nil
 	case node.Branch:
 		children := make([]*matcher, len(n.Children))
@@ -18,9 +18,28 @@ func read(t *testing.T, input string) *node.Node {
 	if err != nil {
 		t.Fatalf("error when reading input: %v", err)
 	}
+	clearPos(n)
 	return n
 }
 
+// clearPos clears the positions of nodes, so that nodes read from different sources can be compared.
+func clearPos(n *node.Node) {
+	n.Pos = token.Pos{}
+	for _, child := range n.Children {
+		clearPos(child)
+	}
+}
+
+// intern sets the interned symbols of identifiers as set by the parser, so that nodes made by hand can be compared.
+func intern(n *node.Node) {
+	if n.Type == node.Identifier {
+		n.Sym = node.Intern(n.Str)
+	}
+	for _, child := range n.Children {
+		intern(child)
+	}
+}
+
 func TestMacro_Replace(t *testing.T) {
 	// http://www.shido.info/lisp/scheme_syntax_e.html
 	tests := []struct {
@@ -241,6 +260,7 @@ func TestMacro_Replace(t *testing.T) {
 			}
 
 			inputCode := read(t, tt.input)
+			intern(tt.want)
 			if got, err := e.ApplyMacro(inputCode); err != nil {
 				t.Fatalf("error when applying macro: %v", err)
 			} else if !reflect.DeepEqual(got, tt.want) {
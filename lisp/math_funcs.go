@@ -0,0 +1,266 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxExactInteger is the max integer that can be exactly represented by number objects (float64).
+const maxExactInteger = 1 << 53
+
+func init() {
+	defaultEnv["abs"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Abs)))
+	defaultEnv["square"] = object.NewWrappedFunctionObject(
+		makeUnary(makeInexact(makeNumbers(func(input []float64) object.Object {
+			return object.NewNumberObject(input[0] * input[0])
+		}))))
+	defaultEnv["sqrt"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Sqrt)))
+	defaultEnv["exp"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Exp)))
+	defaultEnv["log"] = object.NewWrappedFunctionObject(
+		makeInexact(makeNumbers(func(input []float64) object.Object {
+			// (log z [base])
+			switch len(input) {
+			case 1:
+				return object.NewNumberObject(math.Log(input[0]))
+			case 2:
+				return object.NewNumberObject(math.Log(input[0]) / math.Log(input[1]))
+			}
+			return object.NewErrorObject(fmt.Sprintf("log: expected 1 or 2 arguments, but got %v", len(input)))
+		})))
+	defaultEnv["expt"] = object.NewWrappedFunctionObject(
+		makeBinary(makeInexact(makeNumbers(func(input []float64) object.Object {
+			return object.NewNumberObject(math.Pow(input[0], input[1]))
+		}))))
+
+	defaultEnv["sin"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Sin)))
+	defaultEnv["cos"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Cos)))
+	defaultEnv["tan"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Tan)))
+	defaultEnv["asin"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Asin)))
+	defaultEnv["acos"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Acos)))
+	defaultEnv["atan"] = object.NewWrappedFunctionObject(
+		makeInexact(makeNumbers(func(input []float64) object.Object {
+			// (atan z) or (atan y x)
+			switch len(input) {
+			case 1:
+				return object.NewNumberObject(math.Atan(input[0]))
+			case 2:
+				return object.NewNumberObject(math.Atan2(input[0], input[1]))
+			}
+			return object.NewErrorObject(fmt.Sprintf("atan: expected 1 or 2 arguments, but got %v", len(input)))
+		})))
+
+	defaultEnv["floor"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Floor)))
+	defaultEnv["ceiling"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Ceil)))
+	defaultEnv["round"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.RoundToEven)))
+	defaultEnv["truncate"] = object.NewWrappedFunctionObject(makeUnary(makeMath(math.Trunc)))
+
+	// Integer divisions.
+	// Since multiple values are not supported, floor/ and truncate/ return a list of the quotient and the remainder.
+	defaultEnv["floor/"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			q, r := floorDiv(n1, n2)
+			return list([]object.Object{object.NewNumberObject(float64(q)), object.NewNumberObject(float64(r))})
+		})))
+	defaultEnv["floor-quotient"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			q, _ := floorDiv(n1, n2)
+			return object.NewNumberObject(float64(q))
+		})))
+	defaultEnv["floor-remainder"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			_, r := floorDiv(n1, n2)
+			return object.NewNumberObject(float64(r))
+		})))
+	defaultEnv["truncate/"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			return list([]object.Object{object.NewNumberObject(float64(n1 / n2)), object.NewNumberObject(float64(n1 % n2))})
+		})))
+	defaultEnv["truncate-quotient"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			return object.NewNumberObject(float64(n1 / n2))
+		})))
+	defaultEnv["truncate-remainder"] = object.NewWrappedFunctionObject(
+		makeBinary(makeDivision(func(n1, n2 int64) object.Object {
+			return object.NewNumberObject(float64(n1 % n2))
+		})))
+	defaultEnv["quotient"] = defaultEnv["truncate-quotient"]
+	defaultEnv["remainder"] = defaultEnv["truncate-remainder"]
+
+	defaultEnv["gcd"] = object.NewWrappedFunctionObject(
+		makeIntegers(func(input []int64) object.Object {
+			var res int64
+			for _, in := range input {
+				res = gcd(res, in)
+			}
+			return object.NewNumberObject(float64(res))
+		}))
+	defaultEnv["lcm"] = object.NewWrappedFunctionObject(
+		makeIntegers(func(input []int64) object.Object {
+			var res int64 = 1
+			for _, in := range input {
+				if in == 0 {
+					return object.NewNumberObject(0)
+				}
+				res = abs(res / gcd(res, in) * in)
+			}
+			return object.NewNumberObject(float64(res))
+		}))
+	// Since multiple values are not supported, exact-integer-sqrt returns a list of s and r, where k = s^2 + r.
+	defaultEnv["exact-integer-sqrt"] = object.NewWrappedFunctionObject(
+		makeUnary(makeIntegers(func(input []int64) object.Object {
+			k := input[0]
+			if k < 0 {
+				return object.NewErrorObject(fmt.Sprintf("exact-integer-sqrt: expected non-negative integer, but got %v", k))
+			}
+			s := int64(math.Sqrt(float64(k)))
+			// correct the floating point error
+			for s*s > k {
+				s--
+			}
+			for (s+1)*(s+1) <= k {
+				s++
+			}
+			return list([]object.Object{object.NewNumberObject(float64(s)), object.NewNumberObject(float64(k - s*s))})
+		})))
+
+	defaultEnv["number->string"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (number->string z [radix])
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("number->string: expected 1 or 2 arguments, but got %v", len(objects)))
+		}
+		z := objects[0]
+		if z.Type() != object_type.Number {
+			return object.NewErrorObject(fmt.Sprintf("number->string: expected 1st argument to be number, but got %v", z))
+		}
+		radix, errObj := radixArg("number->string", objects)
+		if errObj != nil {
+			return errObj
+		}
+		if radix == 10 {
+			return object.NewStringObject(z.String())
+		}
+		n, ok := toInteger(z.Number())
+		if !ok {
+			return object.NewErrorObject(fmt.Sprintf("number->string: cannot format non-integer %v in radix %v", z, radix))
+		}
+		return object.NewStringObject(strconv.FormatInt(n, radix))
+	})
+	defaultEnv["string->number"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (string->number string [radix])
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("string->number: expected 1 or 2 arguments, but got %v", len(objects)))
+		}
+		s := objects[0]
+		if s.Type() != object_type.Str {
+			return object.NewErrorObject(fmt.Sprintf("string->number: expected 1st argument to be string, but got %v", s))
+		}
+		radix, errObj := radixArg("string->number", objects)
+		if errObj != nil {
+			return errObj
+		}
+		if radix == 10 {
+			str := strings.TrimSpace(s.Str())
+			if f, ok := node.ParseInfNaN(str); ok {
+				return object.NewInexactNumberObject(f)
+			}
+			if f, err := strconv.ParseFloat(str, 64); err == nil {
+				if strings.ContainsAny(str, ".eE") {
+					return object.NewInexactNumberObject(f)
+				}
+				return object.NewNumberObject(f)
+			}
+			return object.NewBooleanObject(false)
+		}
+		if n, err := strconv.ParseInt(s.Str(), radix, 64); err == nil {
+			return object.NewNumberObject(float64(n))
+		}
+		return object.NewBooleanObject(false)
+	})
+}
+
+// radixArg returns the optional radix in the 2nd argument, defaulting to 10.
+func radixArg(name string, objects []object.Object) (int, object.Object) {
+	if len(objects) < 2 {
+		return 10, nil
+	}
+	radix := objects[1]
+	if radix.Type() != object_type.Number {
+		return 0, object.NewErrorObject(fmt.Sprintf("%v: expected radix to be number, but got %v", name, radix))
+	}
+	switch r := radix.Number(); r {
+	case 2, 8, 10, 16:
+		return int(r), nil
+	}
+	return 0, object.NewErrorObject(fmt.Sprintf("%v: expected radix to be one of 2, 8, 10 or 16, but got %v", name, radix))
+}
+
+// toInteger converts the given number to an integer, if it is an exactly representable integer.
+func toInteger(f float64) (int64, bool) {
+	if f != math.Trunc(f) || math.Abs(f) > maxExactInteger {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// gcd returns the non-negative greatest common divisor of n1 and n2.
+func gcd(n1, n2 int64) int64 {
+	n1, n2 = abs(n1), abs(n2)
+	for n2 != 0 {
+		n1, n2 = n2, n1%n2
+	}
+	return n1
+}
+
+// floorDiv returns the quotient and remainder of n1 / n2, rounding the quotient towards negative infinity.
+func floorDiv(n1, n2 int64) (q, r int64) {
+	q, r = n1/n2, n1%n2
+	if r != 0 && (r < 0) != (n2 < 0) {
+		q--
+		r += n2
+	}
+	return
+}
+
+func makeMath(f func(float64) float64) generalFunc {
+	return makeInexact(makeNumbers(func(input []float64) object.Object {
+		return object.NewNumberObject(f(input[0]))
+	}))
+}
+
+func makeIntegers(next func(input []int64) object.Object) generalFunc {
+	return makeNumbers(func(input []float64) object.Object {
+		integers := make([]int64, len(input))
+		for i, in := range input {
+			n, ok := toInteger(in)
+			if !ok {
+				return object.NewErrorObject(fmt.Sprintf(
+					"expected %v-th argument to be integer, but got %v", i, object.NewNumberObject(in)))
+			}
+			integers[i] = n
+		}
+		return next(integers)
+	})
+}
+
+// makeDivision makes a binary integer division function, checking the divisor is non-zero.
+func makeDivision(next func(n1, n2 int64) object.Object) generalFunc {
+	return makeIntegers(func(input []int64) object.Object {
+		if input[1] == 0 {
+			return object.NewErrorObject("division by 0")
+		}
+		return next(input[0], input[1])
+	})
+}
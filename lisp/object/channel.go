@@ -0,0 +1,138 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
+)
+
+var ErrChannelClosed = errors.New("channel is closed")
+
+// Channel is a channel of objects, buffered if the capacity is positive.
+// Unlike Go channels, sending to or closing a closed channel results in ErrChannelClosed instead of panicking.
+type Channel struct {
+	ch chan Object
+	// done is closed when the channel is closed, while ch is never closed
+	done chan struct{}
+	// mu guards closed
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewChannelObject returns a new channel with the given capacity.
+func NewChannelObject(capacity int) *Channel {
+	return &Channel{ch: make(chan Object, capacity), done: make(chan struct{})}
+}
+
+// Chan returns the underlying Go channel, which is never closed.
+func (c *Channel) Chan() chan Object {
+	return c.ch
+}
+
+// Done returns a Go channel closed when this channel is closed.
+func (c *Channel) Done() <-chan struct{} {
+	return c.done
+}
+
+// Send sends the value to this channel, waiting until a receiver is ready or the buffer has space.
+func (c *Channel) Send(ctx context.Context, value Object) error {
+	select {
+	case <-c.done:
+		return ErrChannelClosed
+	default:
+	}
+	select {
+	case c.ch <- value:
+		return nil
+	case <-c.done:
+		return ErrChannelClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Receive receives a value from this channel, waiting until a value is sent.
+// Returns false if the channel is closed and no values are left.
+func (c *Channel) Receive(ctx context.Context) (Object, bool, error) {
+	select {
+	case value := <-c.ch:
+		return value, true, nil
+	case <-c.done:
+		value, ok := c.TryReceive()
+		return value, ok, nil
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// TryReceive receives a value from this channel if available, without waiting.
+func (c *Channel) TryReceive() (Object, bool) {
+	select {
+	case value := <-c.ch:
+		return value, true
+	default:
+		return nil, false
+	}
+}
+
+// Close closes this channel. Values sent before closing can still be received.
+func (c *Channel) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrChannelClosed
+	}
+	c.closed = true
+	close(c.done)
+	return nil
+}
+
+func (c *Channel) Type() object_type.T {
+	return object_type.Channel
+}
+
+func (c *Channel) Number() float64 {
+	panic("number() called on channel object")
+}
+
+func (c *Channel) Bool() bool {
+	panic("Bool() called on channel object")
+}
+
+func (c *Channel) Pair() *[2]Object {
+	panic("Pair() called on channel object")
+}
+
+func (c *Channel) Str() string {
+	panic("Str() called on channel object")
+}
+
+func (c *Channel) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on channel object")
+}
+
+func (c *Channel) String() string {
+	return "<channel>"
+}
+
+func (c *Channel) Display() string {
+	return c.String()
+}
+
+func (c *Channel) IsList() bool {
+	return false
+}
+
+func (c *Channel) ListElements() []Object {
+	panic("ListElements() called on channel object")
+}
+
+func (c *Channel) IsTruthy() bool {
+	return true
+}
+
+func (c *Channel) Equals(object Object) bool {
+	return object == Object(c)
+}
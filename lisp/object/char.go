@@ -0,0 +1,63 @@
+package object
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+func NewCharObject(r rune) Object {
+	return char(r)
+}
+
+func (c char) Type() object_type.T {
+	return object_type.Char
+}
+
+func (c char) Number() float64 {
+	panic("number() called on char object")
+}
+
+func (c char) Bool() bool {
+	panic("Bool() called on char object")
+}
+
+func (c char) Pair() *[2]Object {
+	panic("Pair() called on char object")
+}
+
+// Str returns the string consisting of the character.
+func (c char) Str() string {
+	return string(c)
+}
+
+func (c char) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on char object")
+}
+
+func (c char) String() string {
+	return node.CharString(rune(c))
+}
+
+func (c char) Display() string {
+	return string(c)
+}
+
+func (c char) IsList() bool {
+	return false
+}
+
+func (c char) ListElements() []Object {
+	panic("ListElements() called on char object")
+}
+
+func (c char) IsTruthy() bool {
+	return true
+}
+
+func (c char) Equals(object Object) bool {
+	if object.Type() != object_type.Char {
+		return false
+	}
+	return string(c) == object.Str()
+}
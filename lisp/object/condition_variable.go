@@ -0,0 +1,130 @@
+package object
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
+	"time"
+)
+
+// ConditionVariable is a condition variable of SRFI-18.
+type ConditionVariable struct {
+	specific
+	// mu guards waiters
+	mu sync.Mutex
+	// waiters are the channels of the waiting threads in order, closed on signal
+	waiters []chan struct{}
+}
+
+// NewConditionVariableObject returns a new condition variable. name can be nil.
+func NewConditionVariableObject(name Object) *ConditionVariable {
+	return &ConditionVariable{specific: newSpecific(name)}
+}
+
+// Wait unlocks the mutex and waits for this condition variable to be signaled, without locking the mutex again.
+// Returns false if not signaled within the timeout, where negative timeout means no timeout.
+func (c *ConditionVariable) Wait(ctx context.Context, m *Mutex, timeout time.Duration) (bool, error) {
+	ch := make(chan struct{})
+	c.mu.Lock()
+	c.waiters = append(c.waiters, ch)
+	c.mu.Unlock()
+	if err := m.Unlock(); err != nil {
+		c.remove(ch)
+		return false, err
+	}
+
+	timer, stop := newTimer(timeout)
+	defer stop()
+	select {
+	case <-ch:
+		return true, nil
+	case <-timer:
+		// signaled at the same time if already removed
+		return !c.remove(ch), nil
+	case <-ctx.Done():
+		c.remove(ch)
+		return false, ctx.Err()
+	}
+}
+
+// remove removes the waiter, and returns false if already removed by signal.
+func (c *ConditionVariable) remove(ch chan struct{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, w := range c.waiters {
+		if w == ch {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Signal wakes up a thread waiting for this condition variable, if any.
+func (c *ConditionVariable) Signal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.waiters) > 0 {
+		close(c.waiters[0])
+		c.waiters = c.waiters[1:]
+	}
+}
+
+// Broadcast wakes up all threads waiting for this condition variable.
+func (c *ConditionVariable) Broadcast() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, w := range c.waiters {
+		close(w)
+	}
+	c.waiters = nil
+}
+
+func (c *ConditionVariable) Type() object_type.T {
+	return object_type.ConditionVariable
+}
+
+func (c *ConditionVariable) Number() float64 {
+	panic("number() called on condition variable object")
+}
+
+func (c *ConditionVariable) Bool() bool {
+	panic("Bool() called on condition variable object")
+}
+
+func (c *ConditionVariable) Pair() *[2]Object {
+	panic("Pair() called on condition variable object")
+}
+
+func (c *ConditionVariable) Str() string {
+	panic("Str() called on condition variable object")
+}
+
+func (c *ConditionVariable) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on condition variable object")
+}
+
+func (c *ConditionVariable) String() string {
+	return c.describe("condition-variable")
+}
+
+func (c *ConditionVariable) Display() string {
+	return c.String()
+}
+
+func (c *ConditionVariable) IsList() bool {
+	return false
+}
+
+func (c *ConditionVariable) ListElements() []Object {
+	panic("ListElements() called on condition variable object")
+}
+
+func (c *ConditionVariable) IsTruthy() bool {
+	return true
+}
+
+func (c *ConditionVariable) Equals(object Object) bool {
+	return object == Object(c)
+}
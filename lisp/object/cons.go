@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
@@ -29,52 +30,120 @@ func (c *cons) Str() string {
 	panic("Str() called on cons object")
 }
 
-func (c *cons) F(_ []Object) (Object, *node.Node, *Env) {
+func (c *cons) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on cons object")
 }
 
-func (c *cons) stringStripPars(display bool) string {
+// stringStripPars returns the elements of the list starting from this pair, without the enclosing parentheses.
+// ancestors is the set of pairs being printed, and pairs referring back to them are printed as "..." to avoid
+// infinite loops on circular structure. Use package printer for the representation with datum labels.
+func (c *cons) stringStripPars(display bool, ancestors map[*cons]bool) string {
 	var ret string
-	if display {
-		ret = c[0].String()
-	} else {
-		ret = c[0].Display()
-	}
-	switch c[1].Type() {
-	case object_type.Cons:
-		ret += " " + (c[1]).(*cons).stringStripPars(display)
-	case object_type.Null:
-		// append none
-	default:
-		if display {
-			ret += " . " + c[1].Display()
-		} else {
-			ret += " . " + c[1].String()
+	var list []*cons
+	for cur := c; ; {
+		ancestors[cur] = true
+		list = append(list, cur)
+		ret += stringOf(cur[0], display, ancestors)
+
+		next, ok := cur[1].(*cons)
+		if !ok {
+			if cur[1].Type() != object_type.Null {
+				ret += " . " + stringOf(cur[1], display, ancestors)
+			}
+			break
 		}
+		if ancestors[next] {
+			ret += " . ..."
+			break
+		}
+		ret += " "
+		cur = next
+	}
+	for _, pair := range list {
+		delete(ancestors, pair)
 	}
 	return ret
 }
 
+// stringOf returns the string representation of o, where o may be a pair referring back to ancestors.
+func stringOf(o Object, display bool, ancestors map[*cons]bool) string {
+	if c, ok := o.(*cons); ok {
+		if ancestors[c] {
+			return "..."
+		}
+		return "(" + c.stringStripPars(display, ancestors) + ")"
+	}
+	if display {
+		return o.Display()
+	}
+	return o.String()
+}
+
 func (c *cons) String() string {
-	return "(" + c.stringStripPars(false) + ")"
+	return "(" + c.stringStripPars(false, make(map[*cons]bool)) + ")"
 }
 
 func (c *cons) Display() string {
-	return "(" + c.stringStripPars(true) + ")"
+	return "(" + c.stringStripPars(true, make(map[*cons]bool)) + ")"
 }
 
+// next returns the cdr if it is a pair, nil otherwise.
+func (c *cons) next() *cons {
+	next, _ := c[1].(*cons)
+	return next
+}
+
+// IsList returns true if this is a proper list, i.e. a finite chain of pairs terminated by the empty list.
+// Circular lists are detected with Floyd's cycle-finding algorithm.
 func (c *cons) IsList() bool {
-	// this is cons type, only requires cdr to also be list
-	return c[1].IsList()
+	slow, fast := c, c
+	for {
+		if fast.next() == nil {
+			return fast[1].IsList()
+		}
+		fast = fast.next()
+		if fast.next() == nil {
+			return fast[1].IsList()
+		}
+		fast = fast.next()
+		slow = slow.next()
+		if slow == fast {
+			return false
+		}
+	}
+}
+
+// IsCircular returns true if following the cdrs of pairs from o never ends.
+// Cycles are detected with Floyd's cycle-finding algorithm, as in IsList.
+func IsCircular(o Object) bool {
+	c, ok := o.(*cons)
+	if !ok {
+		return false
+	}
+	slow, fast := c, c
+	for {
+		if fast = fast.next(); fast == nil {
+			return false
+		}
+		if fast = fast.next(); fast == nil {
+			return false
+		}
+		slow = slow.next()
+		if slow == fast {
+			return true
+		}
+	}
 }
 
 func (c *cons) ListElements() []Object {
-	if c.Type() == object_type.Null {
-		return []Object{}
+	if !c.IsList() {
+		panic("ListElements() called on improper or circular list")
 	}
-	first := c[0]
-	rest := c[1].ListElements()
-	return append([]Object{first}, rest...)
+	var ret []Object
+	for cur := c; cur != nil; cur = cur.next() {
+		ret = append(ret, cur[0])
+	}
+	return ret
 }
 
 func (c *cons) IsTruthy() bool {
@@ -82,9 +151,36 @@ func (c *cons) IsTruthy() bool {
 }
 
 func (c *cons) Equals(object Object) bool {
-	if object.Type() != object_type.Cons {
+	o, ok := object.(*cons)
+	if !ok {
 		return false
 	}
-	o := object.(*cons)
-	return c[0].Equals(o[0]) && c[1].Equals(o[1])
+	return equalPairs(c, o, make(map[[2]*cons]bool))
+}
+
+// equalPairs compares two pairs recursively.
+// Pairs already being compared are assumed to be equal, so that comparisons of circular structure terminate.
+func equalPairs(c, o *cons, assumed map[[2]*cons]bool) bool {
+	for {
+		if c == o || assumed[[2]*cons{c, o}] {
+			return true
+		}
+		assumed[[2]*cons{c, o}] = true
+
+		if car1, ok := c[0].(*cons); ok {
+			car2, ok := o[0].(*cons)
+			if !ok || !equalPairs(car1, car2, assumed) {
+				return false
+			}
+		} else if !c[0].Equals(o[0]) {
+			return false
+		}
+
+		next1, ok1 := c[1].(*cons)
+		next2, ok2 := o[1].(*cons)
+		if !ok1 || !ok2 {
+			return c[1].Equals(o[1])
+		}
+		c, o = next1, next2
+	}
 }
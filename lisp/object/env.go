@@ -4,51 +4,153 @@ import (
 	"fmt"
 	"github.com/motoki317/lisp-interpreter/lisp/macro"
 	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
 )
 
 type (
+	// Env is an environment, safe for concurrent use by multiple threads.
+	// The global Env holds variables by interned symbol, while local Envs hold variables in slots,
+	// which are resolved by the compiler to pairs of depth and index.
 	Env struct {
-		frame  Frame
+		// mu guards frame, slots and macros
+		mu sync.RWMutex
+		// frame is the frame of the global Env, nil for local Envs
+		frame map[*node.Symbol]Object
+		// slots are the variables of a local Env, where unbound variables are nil
+		slots []Object
+		// names are the names of slots, to look up variables by name
+		names  []string
 		macros []*macro.Macro
 		upper  *Env
+		global *Env
 	}
+	// Frame is the variables of a global Env by name.
 	Frame map[string]Object
 )
 
 // NewGlobalEnv returns a new Env with single given frame (i.e. global Env).
+// The variables of the frame are copied, so that later changes to the frame are not reflected.
 func NewGlobalEnv(globalEnv Frame) *Env {
-	return &Env{frame: globalEnv}
+	frame := make(map[*node.Symbol]Object, len(globalEnv))
+	for k, v := range globalEnv {
+		frame[node.Intern(k)] = v
+	}
+	e := &Env{frame: frame}
+	e.global = e
+	return e
+}
+
+// Globals returns a copy of the variables of the global Env by name.
+func (e *Env) Globals() Frame {
+	g := e.global
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	frame := make(Frame, len(g.frame))
+	for k, v := range g.frame {
+		frame[k.Name()] = v
+	}
+	return frame
 }
 
-// NewEnv appends the given new frame to the existing Env, not modifying the base Env.
-func (e *Env) NewEnv(newFrame Frame) *Env {
+// NewEnv appends a new local Env with the given slots to the existing Env, not modifying the base Env.
+// names are the names of the slots, and must not be modified afterwards.
+func (e *Env) NewEnv(names []string, slots []Object) *Env {
+	if len(names) != len(slots) {
+		panic("assertion error: len(names) == len(slots)")
+	}
 	return &Env{
-		frame: newFrame,
-		upper: e,
+		slots:  slots,
+		names:  names,
+		upper:  e,
+		global: e.global,
+	}
+}
+
+// IsGlobal returns true if this Env is the global Env.
+func (e *Env) IsGlobal() bool {
+	return e.upper == nil
+}
+
+// Upper returns the Env this Env was appended to, or nil if global.
+func (e *Env) Upper() *Env {
+	return e.upper
+}
+
+// Names returns the names of the slots of this Env.
+func (e *Env) Names() []string {
+	return e.names
+}
+
+// up returns the Env depth levels up.
+func (e *Env) up(depth int) *Env {
+	for ; depth > 0; depth-- {
+		e = e.upper
 	}
+	return e
 }
 
-// Define adds a key value pair in the top Frame.
+// Slot returns the value of the slot at index of the Env depth levels up, or nil if unbound.
+// Slot does not lock the Env, and is only for the slots never assigned after the Env is made.
+func (e *Env) Slot(depth, index int) Object {
+	return e.up(depth).slots[index]
+}
+
+// LoadSlot is like Slot, but is for the slots assigned after the Env is made.
+func (e *Env) LoadSlot(depth, index int) Object {
+	e = e.up(depth)
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.slots[index]
+}
+
+// StoreSlot sets the value of the slot at index of the Env depth levels up.
+func (e *Env) StoreSlot(depth, index int, value Object) {
+	e = e.up(depth)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.slots[index] = value
+}
+
+// Define adds a key value pair in this Env.
+// For local Envs, the key must be one of the names of the slots.
 func (e *Env) Define(key string, value Object) {
-	e.frame[key] = value
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.frame != nil {
+		e.frame[node.Intern(key)] = value
+		return
+	}
+	for i, name := range e.names {
+		if name == key {
+			e.slots[i] = value
+			return
+		}
+	}
+	panic(fmt.Sprintf("assertion error: %v is not a slot of the local env", key))
 }
 
 // DefineGlobalMacro adds macro to the global env.
 func (e *Env) DefineGlobalMacro(m *macro.Macro) {
-	global := e
-	for global.upper != nil {
-		global = global.upper
-	}
+	global := e.global
+	global.mu.Lock()
+	defer global.mu.Unlock()
 	global.macros = append(global.macros, m)
 }
 
+// Macros returns the macros of the global env, in the order of definition.
+func (e *Env) Macros() []*macro.Macro {
+	global := e.global
+	global.mu.RLock()
+	defer global.mu.RUnlock()
+	return append([]*macro.Macro(nil), global.macros...)
+}
+
 // Set overrides a key value pair in this Env.
 // Returns false if the key isn't this Env.
 func (e *Env) Set(key string, value Object) (ok bool) {
 	cur := e
 	for cur != nil {
-		if _, ok := cur.frame[key]; ok {
-			cur.frame[key] = value
+		if cur.set(key, value) {
 			return true
 		}
 		cur = cur.upper
@@ -56,18 +158,86 @@ func (e *Env) Set(key string, value Object) (ok bool) {
 	return false
 }
 
+// set overrides a key value pair in the frame or the slots of this Env, if bound.
+func (e *Env) set(key string, value Object) (ok bool) {
+	if e.frame != nil {
+		// names never interned are not bound, and are not added to the table of interned symbols
+		sym, ok := node.Interned(key)
+		return ok && e.setGlobal(sym, value)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, name := range e.names {
+		if name == key && e.slots[i] != nil {
+			e.slots[i] = value
+			return true
+		}
+	}
+	return false
+}
+
+// setGlobal overrides a key value pair in the frame of the global Env, if bound.
+func (e *Env) setGlobal(key *node.Symbol, value Object) (ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, ok = e.frame[key]; ok {
+		e.frame[key] = value
+	}
+	return
+}
+
+// SetGlobal overrides a key value pair in the global Env.
+// Returns false if the key isn't the global Env.
+func (e *Env) SetGlobal(key *node.Symbol, value Object) (ok bool) {
+	return e.global.setGlobal(key, value)
+}
+
 // Lookup looks up for the key in this Env.
+// This is the slow path looking up by name at each level, for variables not resolved by the compiler.
 func (e *Env) Lookup(key string) (value Object, ok bool) {
 	cur := e
 	for cur != nil {
-		if v, ok := cur.frame[key]; ok {
-			return v, true
+		if value, ok = cur.lookup(key); ok {
+			return
 		}
 		cur = cur.upper
 	}
 	return nil, false
 }
 
+// lookup looks up for the key in the frame or the slots of this Env.
+func (e *Env) lookup(key string) (value Object, ok bool) {
+	if e.frame != nil {
+		// names never interned are not bound, and are not added to the table of interned symbols
+		sym, ok := node.Interned(key)
+		if !ok {
+			return nil, false
+		}
+		return e.lookupGlobal(sym)
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	for i := len(e.names) - 1; i >= 0; i-- {
+		if e.names[i] == key && e.slots[i] != nil {
+			return e.slots[i], true
+		}
+	}
+	return nil, false
+}
+
+// lookupGlobal looks up for the key in the frame of the global Env.
+func (e *Env) lookupGlobal(key *node.Symbol) (value Object, ok bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	value, ok = e.frame[key]
+	return
+}
+
+// LookupGlobal looks up for the key in the global Env.
+func (e *Env) LookupGlobal(key *node.Symbol) (value Object, ok bool) {
+	return e.global.lookupGlobal(key)
+}
+
 const maxMacroRecursiveApply = 100
 
 // ApplyMacro applies macro recursively, and returns the applied code.
@@ -86,16 +256,16 @@ func (e *Env) ApplyMacro(n *node.Node) (*node.Node, error) {
 	return n, nil
 }
 
-// applyMacro applies macro once.
+// applyMacro applies macro once. Macros are only defined in the global env.
 func (e *Env) applyMacro(n *node.Node) (res *node.Node, ok bool) {
-	cur := e
-	for cur != nil {
-		for _, m := range e.macros {
-			if res, ok = m.Replace(n); ok {
-				return
-			}
+	global := e.global
+	global.mu.RLock()
+	macros := global.macros
+	global.mu.RUnlock()
+	for _, m := range macros {
+		if res, ok = m.Replace(n); ok {
+			return
 		}
-		cur = cur.upper
 	}
 	return n, false
 }
@@ -104,16 +274,3 @@ func (e *Env) applyMacro(n *node.Node) (res *node.Node, ok bool) {
 func EmptyFrame() Frame {
 	return make(map[string]Object)
 }
-
-// NewBindingFrame returns a new Frame binding the given arguments.
-// Expects the length of argNames and the length of objects to be the same.
-func NewBindingFrame(argNames []string, objects []Object) Frame {
-	f := EmptyFrame()
-	if len(argNames) != len(objects) {
-		panic("assertion error: len(argNames) == len(objects)")
-	}
-	for i, arg := range argNames {
-		f[arg] = objects[i]
-	}
-	return f
-}
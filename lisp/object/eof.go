@@ -0,0 +1,55 @@
+package object
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+func (e eof) Type() object_type.T {
+	return object_type.EOF
+}
+
+func (e eof) Number() float64 {
+	panic("number() called on eof object")
+}
+
+func (e eof) Bool() bool {
+	panic("Bool() called on eof object")
+}
+
+func (e eof) Pair() *[2]Object {
+	panic("Pair() called on eof object")
+}
+
+func (e eof) Str() string {
+	panic("Str() called on eof object")
+}
+
+func (e eof) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on eof object")
+}
+
+func (e eof) String() string {
+	return "#<eof>"
+}
+
+func (e eof) Display() string {
+	return "#<eof>"
+}
+
+func (e eof) IsList() bool {
+	return false
+}
+
+func (e eof) ListElements() []Object {
+	panic("ListElements() called on eof object")
+}
+
+func (e eof) IsTruthy() bool {
+	return true
+}
+
+func (e eof) Equals(object Object) bool {
+	return object.Type() == object_type.EOF
+}
@@ -0,0 +1,17 @@
+package object
+
+// Eq returns true if the objects are the same object, as compared by eq?.
+// Numbers, booleans and characters have no identity, and are compared by value (and exactness for numbers).
+// Strings, pairs, procedures and promises made separately are never the same.
+func Eq(o1, o2 Object) bool {
+	return o1 == o2
+}
+
+// Eqv returns true if the objects are equivalent, as compared by eqv?.
+// Eqv is Eq, except that numbers are compared by value and exactness in any case, including NaN.
+func Eqv(o1, o2 Object) bool {
+	if n, ok := o1.(number); ok {
+		return n.Equals(o2)
+	}
+	return Eq(o1, o2)
+}
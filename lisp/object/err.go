@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
@@ -29,7 +30,7 @@ func (e err) Str() string {
 	return string(e)
 }
 
-func (e err) F(_ []Object) (Object, *node.Node, *Env) {
+func (e err) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on err object")
 }
 
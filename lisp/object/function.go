@@ -1,68 +1,91 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
 
-func NewWrappedFunctionObject(f func(objects []Object) Object) Object {
-	return function(func(objects []Object) (Object, *node.Node, *Env) {
-		return f(objects), nil, nil
-	})
+func NewWrappedFunctionObject(f func(ctx context.Context, objects []Object) Object) Object {
+	return &function{f: func(ctx context.Context, objects []Object) (Object, *node.Node, *Env) {
+		return f(ctx, objects), nil, nil
+	}}
 }
 
-func NewFunctionObject(f func(objects []Object) (Object, *node.Node, *Env)) Object {
-	return function(f)
+func NewFunctionObject(f func(ctx context.Context, objects []Object) (Object, *node.Node, *Env)) Object {
+	return &function{f: f}
 }
 
-func (f function) Type() object_type.T {
+// NewTailApplyFunctionObject returns a new tail-apply function, which only calls another function as its result, such as apply.
+// resolve returns the function and the arguments to call it with, or an error object.
+// Engines evaluate calls to tail-apply functions by calling the resolved function in place of them,
+// so that tail calls through them do not nest.
+func NewTailApplyFunctionObject(resolve func(objects []Object) (Object, []Object, Object)) Object {
+	return &function{
+		f: func(ctx context.Context, objects []Object) (Object, *node.Node, *Env) {
+			f, args, errObj := resolve(objects)
+			if errObj != nil {
+				return errObj, nil, nil
+			}
+			return f.F(ctx, args)
+		},
+		tailApply: resolve,
+	}
+}
+
+// TailApply returns the function to resolve calls to the tail-apply function, or nil if o is not a tail-apply function.
+func TailApply(o Object) func(objects []Object) (Object, []Object, Object) {
+	if f, ok := o.(*function); ok {
+		return f.tailApply
+	}
+	return nil
+}
+
+func (f *function) Type() object_type.T {
 	return object_type.Function
 }
 
-func (f function) Number() float64 {
+func (f *function) Number() float64 {
 	panic("number() called on function object")
 }
 
-func (f function) Bool() bool {
+func (f *function) Bool() bool {
 	panic("Bool() called on function object")
 }
 
-func (f function) Pair() *[2]Object {
+func (f *function) Pair() *[2]Object {
 	panic("Pair() called on function object")
 }
 
-func (f function) Str() string {
+func (f *function) Str() string {
 	panic("Str() called on function object")
 }
 
-func (f function) F(objects []Object) (Object, *node.Node, *Env) {
-	return f(objects)
+func (f *function) F(ctx context.Context, objects []Object) (Object, *node.Node, *Env) {
+	return f.f(ctx, objects)
 }
 
-func (f function) String() string {
+func (f *function) String() string {
 	return "<function>"
 }
 
-func (f function) Display() string {
+func (f *function) Display() string {
 	return "<function>"
 }
 
-func (f function) IsList() bool {
+func (f *function) IsList() bool {
 	return false
 }
 
-func (f function) ListElements() []Object {
+func (f *function) ListElements() []Object {
 	panic("ListElements() called on function object")
 }
 
-func (f function) IsTruthy() bool {
+func (f *function) IsTruthy() bool {
 	return true
 }
 
-func (f function) Equals(object Object) bool {
-	if object.Type() != object_type.Function {
-		return false
-	}
-	// function comparison undefined
-	return true
+func (f *function) Equals(object Object) bool {
+	// functions are equal only to themselves
+	return object == Object(f)
 }
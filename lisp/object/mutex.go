@@ -0,0 +1,121 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
+	"time"
+)
+
+var ErrMutexNotLocked = errors.New("mutex is not locked")
+
+// Mutex is a mutex of SRFI-18. Unlike sync.Mutex, locking can time out, and any thread can unlock it.
+type Mutex struct {
+	specific
+	// lock holds a value while the mutex is locked
+	lock chan struct{}
+	// mu guards owner
+	mu sync.Mutex
+	// owner is the thread owning the mutex, nil if not owned
+	owner Object
+}
+
+// NewMutexObject returns a new unlocked mutex. name can be nil.
+func NewMutexObject(name Object) *Mutex {
+	return &Mutex{specific: newSpecific(name), lock: make(chan struct{}, 1)}
+}
+
+// Lock locks this mutex, waiting until it is unlocked if locked. owner can be nil.
+// Returns false if the mutex could not be locked within the timeout, where negative timeout means no timeout.
+func (m *Mutex) Lock(ctx context.Context, timeout time.Duration, owner Object) (bool, error) {
+	timer, stop := newTimer(timeout)
+	defer stop()
+	select {
+	case m.lock <- struct{}{}:
+	case <-timer:
+		return false, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.owner = owner
+	return true, nil
+}
+
+// Unlock unlocks this mutex. Returns ErrMutexNotLocked if not locked.
+func (m *Mutex) Unlock() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	select {
+	case <-m.lock:
+	default:
+		return ErrMutexNotLocked
+	}
+	m.owner = nil
+	return nil
+}
+
+// State returns the owner thread if locked and owned, the symbol not-owned if locked and not owned,
+// or the symbol not-abandoned if unlocked.
+func (m *Mutex) State() Object {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.lock) == 0 {
+		return NewSymbolObject("not-abandoned")
+	}
+	if m.owner == nil {
+		return NewSymbolObject("not-owned")
+	}
+	return m.owner
+}
+
+func (m *Mutex) Type() object_type.T {
+	return object_type.Mutex
+}
+
+func (m *Mutex) Number() float64 {
+	panic("number() called on mutex object")
+}
+
+func (m *Mutex) Bool() bool {
+	panic("Bool() called on mutex object")
+}
+
+func (m *Mutex) Pair() *[2]Object {
+	panic("Pair() called on mutex object")
+}
+
+func (m *Mutex) Str() string {
+	panic("Str() called on mutex object")
+}
+
+func (m *Mutex) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on mutex object")
+}
+
+func (m *Mutex) String() string {
+	return m.describe("mutex")
+}
+
+func (m *Mutex) Display() string {
+	return m.String()
+}
+
+func (m *Mutex) IsList() bool {
+	return false
+}
+
+func (m *Mutex) ListElements() []Object {
+	panic("ListElements() called on mutex object")
+}
+
+func (m *Mutex) IsTruthy() bool {
+	return true
+}
+
+func (m *Mutex) Equals(object Object) bool {
+	return object == Object(m)
+}
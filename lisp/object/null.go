@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
@@ -25,7 +26,7 @@ func (n null) Str() string {
 	panic("Str() called on null object")
 }
 
-func (n null) F(_ []Object) (Object, *node.Node, *Env) {
+func (n null) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on null object")
 }
 
@@ -1,13 +1,29 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
+	"math"
 	"strconv"
+	"strings"
 )
 
+// NewNumberObject returns a number, which is exact if it is an integer, and inexact otherwise.
 func NewNumberObject(num float64) Object {
-	return number(num)
+	return number{num: num, inexact: math.IsInf(num, 0) || num != math.Trunc(num)}
+}
+
+// NewInexactNumberObject returns an inexact number, such as read from a literal with a decimal point.
+func NewInexactNumberObject(num float64) Object {
+	return number{num: num, inexact: true}
+}
+
+// IsExact returns true if o is an exact number.
+// Since numbers are represented by float64, only integers not computed from inexact numbers are exact.
+func IsExact(o Object) bool {
+	n, ok := o.(number)
+	return ok && !n.inexact
 }
 
 func (n number) Type() object_type.T {
@@ -15,7 +31,7 @@ func (n number) Type() object_type.T {
 }
 
 func (n number) Number() float64 {
-	return float64(n)
+	return n.num
 }
 
 func (n number) Bool() bool {
@@ -30,16 +46,33 @@ func (n number) Str() string {
 	panic("Str() called on number object")
 }
 
-func (n number) F(_ []Object) (Object, *node.Node, *Env) {
+func (n number) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on number object")
 }
 
+// String returns the number in the syntax read back to the same number, keeping its exactness.
+// Inexact integers are written with a decimal point, and infinities and NaN as +inf.0, -inf.0 and +nan.0.
 func (n number) String() string {
-	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+	switch {
+	case math.IsInf(n.num, 1):
+		return "+inf.0"
+	case math.IsInf(n.num, -1):
+		return "-inf.0"
+	case math.IsNaN(n.num):
+		return "+nan.0"
+	case !n.inexact && n.num == 0:
+		// exact zero has no sign
+		return "0"
+	}
+	s := strconv.FormatFloat(n.num, 'f', -1, 64)
+	if n.inexact && !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
 }
 
 func (n number) Display() string {
-	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+	return n.String()
 }
 
 func (n number) IsList() bool {
@@ -55,8 +88,11 @@ func (n number) IsTruthy() bool {
 }
 
 func (n number) Equals(o Object) bool {
-	if o.Type() != object_type.Number {
+	m, ok := o.(number)
+	if !ok || n.inexact != m.inexact {
 		return false
 	}
-	return float64(n) == o.Number()
+	// NaN is equal to itself, as the same number
+	a, b := n.num, m.num
+	return a == b || a != a && b != b
 }
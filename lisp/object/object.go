@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
@@ -8,6 +9,7 @@ import (
 var (
 	VoidObj = void{}
 	NullObj = null{}
+	EOFObj  = eof{}
 )
 
 type Object interface {
@@ -16,10 +18,12 @@ type Object interface {
 	Number() float64
 	Bool() bool
 	Pair() *[2]Object
-	// Str returns string data if type is symbol or str.
+	// Str returns string data if type is symbol, str or char.
 	// Panics otherwise.
 	Str() string
-	F(objects []Object) (Object, *node.Node, *Env)
+	// F calls the function with the given arguments.
+	// Returns nil, continuation node and Env to evaluate it with for tail call optimization, if any.
+	F(ctx context.Context, objects []Object) (Object, *node.Node, *Env)
 
 	String() string
 	Display() string
@@ -27,21 +31,35 @@ type Object interface {
 	IsList() bool
 	ListElements() []Object
 	IsTruthy() bool
+	// Equals returns true if the objects are structurally equal, as compared by equal?.
+	// See also Eq and Eqv.
 	Equals(Object) bool
 }
 
 type (
-	number   float64
-	boolean  bool
-	symbol   string
-	str      string
+	number struct {
+		num     float64
+		inexact bool
+	}
+	boolean bool
+	symbol  struct {
+		s *node.Symbol
+	}
+	str struct {
+		s string
+	}
 	cons     [2]Object
 	null     struct{}
 	void     struct{}
-	function func(objects []Object) (Object, *node.Node, *Env)
-	promise  struct {
-		n *node.Node
-		e *Env
+	function struct {
+		f func(ctx context.Context, objects []Object) (Object, *node.Node, *Env)
+		// tailApply resolves the function and the arguments called by the function, nil if not a tail-apply function
+		tailApply func(objects []Object) (Object, []Object, Object)
+	}
+	promise struct {
+		s *promiseState
 	}
-	err string
+	err  string
+	char rune
+	eof  struct{}
 )
@@ -15,6 +15,14 @@ const (
 	Function
 	Promise
 	Err
+	Char
+	EOF
+	Port
+	Thread
+	Mutex
+	ConditionVariable
+	Channel
+	StreamPair
 )
 
 func (t T) String() string {
@@ -39,6 +47,22 @@ func (t T) String() string {
 		return "promise"
 	case Err:
 		return "error"
+	case Char:
+		return "char"
+	case EOF:
+		return "eof"
+	case Port:
+		return "port"
+	case Thread:
+		return "thread"
+	case Mutex:
+		return "mutex"
+	case ConditionVariable:
+		return "condition-variable"
+	case Channel:
+		return "channel"
+	case StreamPair:
+		return "stream-pair"
 	}
 	return strconv.Itoa(int(t))
 }
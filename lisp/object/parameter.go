@@ -0,0 +1,111 @@
+package object
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+// Parameter is a parameter object created by make-parameter.
+// A parameter is a function returning its current value when called with no arguments,
+// and its value can be dynamically rebound by parameterize.
+type Parameter struct {
+	// value is the value of this parameter outside of parameterize
+	value Object
+	// converter is applied to values given to parameterize, nil if none
+	converter Object
+}
+
+// NewParameterObject returns a new parameter object with the given (already converted) value and converter.
+// converter can be nil.
+func NewParameterObject(value, converter Object) *Parameter {
+	return &Parameter{value: value, converter: converter}
+}
+
+type parameterBindingsKey struct{}
+
+// parameterBinding is a dynamic binding of a parameter, linked to the bindings made outside of it.
+type parameterBinding struct {
+	p     *Parameter
+	value Object
+	next  *parameterBinding
+}
+
+// WithParameterValues returns a new context where the parameters are bound to the (already converted) values.
+// The bindings are seen only by evaluation in the returned context, including threads started in it,
+// so that parameterize in one thread does not affect the others.
+func WithParameterValues(ctx context.Context, params []*Parameter, values []Object) context.Context {
+	b, _ := ctx.Value(parameterBindingsKey{}).(*parameterBinding)
+	for i, p := range params {
+		b = &parameterBinding{p: p, value: values[i], next: b}
+	}
+	return context.WithValue(ctx, parameterBindingsKey{}, b)
+}
+
+// Value returns the current value of this parameter in the context.
+func (p *Parameter) Value(ctx context.Context) Object {
+	b, _ := ctx.Value(parameterBindingsKey{}).(*parameterBinding)
+	for ; b != nil; b = b.next {
+		if b.p == p {
+			return b.value
+		}
+	}
+	return p.value
+}
+
+// Converter returns the converter of this parameter, or nil if none.
+func (p *Parameter) Converter() Object {
+	return p.converter
+}
+
+// Type returns function type, since parameter objects are procedures.
+func (p *Parameter) Type() object_type.T {
+	return object_type.Function
+}
+
+func (p *Parameter) Number() float64 {
+	panic("number() called on parameter object")
+}
+
+func (p *Parameter) Bool() bool {
+	panic("Bool() called on parameter object")
+}
+
+func (p *Parameter) Pair() *[2]Object {
+	panic("Pair() called on parameter object")
+}
+
+func (p *Parameter) Str() string {
+	panic("Str() called on parameter object")
+}
+
+func (p *Parameter) F(ctx context.Context, objects []Object) (Object, *node.Node, *Env) {
+	if len(objects) != 0 {
+		return NewErrorObject("parameter object takes no arguments"), nil, nil
+	}
+	return p.Value(ctx), nil, nil
+}
+
+func (p *Parameter) String() string {
+	return "<parameter>"
+}
+
+func (p *Parameter) Display() string {
+	return "<parameter>"
+}
+
+func (p *Parameter) IsList() bool {
+	return false
+}
+
+func (p *Parameter) ListElements() []Object {
+	panic("ListElements() called on parameter object")
+}
+
+func (p *Parameter) IsTruthy() bool {
+	return true
+}
+
+func (p *Parameter) Equals(object Object) bool {
+	return object == Object(p)
+}
@@ -0,0 +1,267 @@
+package object
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"io"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrPortClosed    = errors.New("port is closed")
+	ErrNotInputPort  = errors.New("not an input port")
+	ErrNotOutputPort = errors.New("not an output port")
+	ErrNotStringPort = errors.New("not a string output port")
+)
+
+// Port is an input or output port, safe for concurrent use by multiple threads.
+type Port struct {
+	// mu guards the fields below other than name
+	mu   sync.Mutex
+	name string
+	// p is the parser of input ports, whose tokenizer also reads characters, nil if not an input port
+	p *node.Parser
+	// w is the writer for output, nil if not an output port
+	w io.Writer
+	// buf is the buffer of string output port
+	buf    *bytes.Buffer
+	closer io.Closer
+	closed bool
+}
+
+// NewInputPortObject returns a new input port reading from r.
+// closer is called on close, if non-nil.
+func NewInputPortObject(name string, r io.Reader, closer io.Closer) Object {
+	return &Port{name: name, p: node.NewParser(token.NewTokenizer(r)), closer: closer}
+}
+
+// NewParserInputPortObject returns a new input port reading data from the given parser,
+// and characters from the tokenizer the parser uses at the time of reading.
+func NewParserInputPortObject(name string, p *node.Parser) Object {
+	return &Port{name: name, p: p}
+}
+
+// NewStringInputPortObject returns a new input port reading from the given string.
+func NewStringInputPortObject(s string) Object {
+	return NewInputPortObject("string", strings.NewReader(s), nil)
+}
+
+// NewOutputPortObject returns a new output port writing to w.
+// closer is called on close, if non-nil.
+func NewOutputPortObject(name string, w io.Writer, closer io.Closer) Object {
+	return &Port{name: name, w: w, closer: closer}
+}
+
+// NewStringOutputPortObject returns a new output port accumulating characters to a string.
+func NewStringOutputPortObject() Object {
+	buf := &bytes.Buffer{}
+	return &Port{name: "string", w: buf, buf: buf}
+}
+
+// IsInput returns true if this is an input port.
+func (p *Port) IsInput() bool {
+	return p.p != nil
+}
+
+// IsOutput returns true if this is an output port.
+func (p *Port) IsOutput() bool {
+	return p.w != nil
+}
+
+// IsOpen returns true if this port is not closed yet.
+func (p *Port) IsOpen() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.closed
+}
+
+func (p *Port) reader() (*token.Tokenizer, error) {
+	if p.closed {
+		return nil, ErrPortClosed
+	}
+	if !p.IsInput() {
+		return nil, ErrNotInputPort
+	}
+	return p.p.Tokenizer(), nil
+}
+
+// ReadChar reads a character from this port. Returns io.EOF at the end of input.
+func (p *Port) ReadChar() (rune, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, err := p.reader()
+	if err != nil {
+		return 0, err
+	}
+	c, _, err := r.ReadRune()
+	return c, err
+}
+
+// PeekChar returns the next character without consuming it. Returns io.EOF at the end of input.
+func (p *Port) PeekChar() (rune, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, err := p.reader()
+	if err != nil {
+		return 0, err
+	}
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	return c, r.UnreadRune()
+}
+
+// ReadLine reads a line without the trailing newline. Returns io.EOF at the end of input.
+func (p *Port) ReadLine() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, err := p.reader()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for {
+		c, _, err := r.ReadRune()
+		if err == io.EOF && sb.Len() > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if c == '\n' {
+			break
+		}
+		sb.WriteRune(c)
+	}
+	return strings.TrimSuffix(sb.String(), "\r"), nil
+}
+
+// ReadString reads at most k characters. Returns io.EOF if no characters are available.
+func (p *Port) ReadString(k int) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	r, err := p.reader()
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for i := 0; i < k; i++ {
+		c, _, err := r.ReadRune()
+		if err == io.EOF && sb.Len() > 0 {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String(), nil
+}
+
+// ReadNode reads the next datum as a node. Returns node.EOF at the end of input.
+// Data and characters are read from the same input, so that reading them can be mixed.
+func (p *Port) ReadNode() (*node.Node, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPortClosed
+	}
+	if !p.IsInput() {
+		return nil, ErrNotInputPort
+	}
+	return p.p.Next()
+}
+
+// Write writes to this port.
+func (p *Port) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, ErrPortClosed
+	}
+	if !p.IsOutput() {
+		return 0, ErrNotOutputPort
+	}
+	return p.w.Write(b)
+}
+
+// OutputString returns the characters accumulated in the string output port.
+func (p *Port) OutputString() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.buf == nil {
+		return "", ErrNotStringPort
+	}
+	return p.buf.String(), nil
+}
+
+// Close closes this port. Closing a closed port has no effect.
+func (p *Port) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	if p.closer != nil {
+		return p.closer.Close()
+	}
+	return nil
+}
+
+func (p *Port) Type() object_type.T {
+	return object_type.Port
+}
+
+func (p *Port) Number() float64 {
+	panic("number() called on port object")
+}
+
+func (p *Port) Bool() bool {
+	panic("Bool() called on port object")
+}
+
+func (p *Port) Pair() *[2]Object {
+	panic("Pair() called on port object")
+}
+
+func (p *Port) Str() string {
+	panic("Str() called on port object")
+}
+
+func (p *Port) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on port object")
+}
+
+func (p *Port) String() string {
+	if p.IsInput() {
+		return "<input-port " + p.name + ">"
+	}
+	return "<output-port " + p.name + ">"
+}
+
+func (p *Port) Display() string {
+	return p.String()
+}
+
+func (p *Port) IsList() bool {
+	return false
+}
+
+func (p *Port) ListElements() []Object {
+	panic("ListElements() called on port object")
+}
+
+func (p *Port) IsTruthy() bool {
+	return true
+}
+
+func (p *Port) Equals(object Object) bool {
+	return object == Object(p)
+}
@@ -1,12 +1,108 @@
 package object
 
 import (
+	"context"
+	"fmt"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
 )
 
-func NewPromiseObject(n *node.Node, e *Env) Object {
-	return &promise{n: n, e: e}
+// promiseMu guards the states of all promises and the states shared by promises.
+// It is never held while evaluating the body of a promise, so that bodies can force other promises or themselves.
+var promiseMu sync.Mutex
+
+// promiseState is the (possibly shared) state of a promise.
+// Promises created by delay-force share their state with the promise they evaluate to, as per R7RS.
+// Fields of the state and the state of each promise are guarded by promiseMu.
+type promiseState struct {
+	done  bool
+	value Object
+	// lazy is true if the promise was created by delay-force,
+	// i.e. evaluating its body yields another promise to be forced.
+	lazy bool
+	f    func(ctx context.Context) Object
+	// takeovers is the number of times the state took over the state of another promise, i.e. f was replaced
+	takeovers int
+}
+
+// NewForcedPromiseObject returns a new promise which is already forced to the given value (make-promise).
+func NewForcedPromiseObject(value Object) Object {
+	return &promise{s: &promiseState{done: true, value: value}}
+}
+
+// NewGoPromiseObject returns a new promise (delay) which calls f when forced.
+func NewGoPromiseObject(f func(ctx context.Context) Object) Object {
+	return &promise{s: &promiseState{f: f}}
+}
+
+// NewGoDelayForceObject returns a new promise (delay-force) which calls f when forced,
+// expecting the result to be another promise.
+func NewGoDelayForceObject(f func(ctx context.Context) Object) Object {
+	return &promise{s: &promiseState{lazy: true, f: f}}
+}
+
+// ForcedValue returns the value of the promise, and true if the promise has been forced.
+func ForcedValue(p Object) (Object, bool) {
+	promiseMu.Lock()
+	defer promiseMu.Unlock()
+	s := p.(*promise).s
+	return s.value, s.done
+}
+
+// Force forces the given promise object, and returns its value.
+// The result of the body of the promise is memoized.
+// Chains of delay-force are forced iteratively, so that forcing them does not grow the stack.
+// If threads force the same promise concurrently, the body may be evaluated more than once,
+// but all of them return the value memoized first.
+func Force(ctx context.Context, p Object) Object {
+	d := p.(*promise)
+	for {
+		promiseMu.Lock()
+		s := d.s
+		if s.done {
+			promiseMu.Unlock()
+			return s.value
+		}
+		f, lazy, takeovers := s.f, s.lazy, s.takeovers
+		promiseMu.Unlock()
+
+		res := f(ctx)
+
+		promiseMu.Lock()
+		// the promise might have been forced while evaluating its body
+		if s.done {
+			promiseMu.Unlock()
+			return s.value
+		}
+		// another thread has proceeded the chain of delay-force while evaluating the body
+		if s.takeovers != takeovers {
+			promiseMu.Unlock()
+			continue
+		}
+		// do not memoize errors, so that forcing again retries the evaluation
+		if res.Type() == object_type.Err {
+			promiseMu.Unlock()
+			return res
+		}
+
+		if !lazy {
+			s.done, s.value = true, res
+			s.f = nil
+			promiseMu.Unlock()
+			return res
+		}
+		if res.Type() != object_type.Promise {
+			promiseMu.Unlock()
+			return NewErrorObject(fmt.Sprintf("delay-force: expected body to evaluate to a promise, but got %v", res))
+		}
+		// take over the state of the resulting promise, and make it share the state with this one
+		next := res.(*promise)
+		*s = *next.s
+		s.takeovers = takeovers + 1
+		next.s = s
+		promiseMu.Unlock()
+	}
 }
 
 func (d *promise) Type() object_type.T {
@@ -29,8 +125,8 @@ func (d *promise) Str() string {
 	panic("Str() called on promise object")
 }
 
-func (d *promise) F(_ []Object) (Object, *node.Node, *Env) {
-	return nil, d.n, d.e
+func (d *promise) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on promise object")
 }
 
 func (d *promise) String() string {
@@ -58,6 +154,8 @@ func (d *promise) Equals(object Object) bool {
 		return false
 	}
 	o := object.(*promise)
-	// not quite an accurate equality implementation
-	return *d == *o
+	promiseMu.Lock()
+	defer promiseMu.Unlock()
+	// promises sharing the same state are forced to the same value
+	return d.s == o.s
 }
@@ -0,0 +1,71 @@
+package object
+
+import (
+	"context"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+// StreamPair is a pair of a promise of the value and a promise of the rest of the stream, created by stream-cons.
+// Unlike pairs, its elements are not accessible by car and cdr.
+type StreamPair struct {
+	promises [2]Object
+}
+
+// NewStreamPairObject returns a new stream pair of the given promises.
+func NewStreamPairObject(car, cdr Object) *StreamPair {
+	return &StreamPair{promises: [2]Object{car, cdr}}
+}
+
+// Promises returns the promises of the value and the rest of the stream.
+func (s *StreamPair) Promises() *[2]Object {
+	return &s.promises
+}
+
+func (s *StreamPair) Type() object_type.T {
+	return object_type.StreamPair
+}
+
+func (s *StreamPair) Number() float64 {
+	panic("Number() called on stream pair object")
+}
+
+func (s *StreamPair) Bool() bool {
+	panic("Bool() called on stream pair object")
+}
+
+func (s *StreamPair) Pair() *[2]Object {
+	panic("Pair() called on stream pair object")
+}
+
+func (s *StreamPair) Str() string {
+	panic("Str() called on stream pair object")
+}
+
+func (s *StreamPair) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on stream pair object")
+}
+
+func (s *StreamPair) String() string {
+	return "<stream>"
+}
+
+func (s *StreamPair) Display() string {
+	return s.String()
+}
+
+func (s *StreamPair) IsList() bool {
+	return false
+}
+
+func (s *StreamPair) ListElements() []Object {
+	panic("ListElements() called on stream pair object")
+}
+
+func (s *StreamPair) IsTruthy() bool {
+	return true
+}
+
+func (s *StreamPair) Equals(object Object) bool {
+	return object == Object(s)
+}
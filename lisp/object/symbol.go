@@ -1,12 +1,30 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
 
-func NewSymbolObject(str string) Object {
-	return symbol(str)
+// NewSymbolObject returns the interned symbol of the name.
+func NewSymbolObject(name string) Object {
+	return symbol{node.Intern(name)}
+}
+
+// NewSymbolObjectOf returns the symbol object of the symbol.
+func NewSymbolObjectOf(s *node.Symbol) Object {
+	return symbol{s}
+}
+
+// NewUninternedSymbolObject returns a new uninterned symbol of the name,
+// which is not eq? to any other symbol.
+func NewUninternedSymbolObject(name string) Object {
+	return symbol{node.NewUninternedSymbol(name)}
+}
+
+// SymbolOf returns the symbol of the symbol object.
+func SymbolOf(o Object) *node.Symbol {
+	return o.(symbol).s
 }
 
 func (s symbol) Type() object_type.T {
@@ -26,19 +44,19 @@ func (s symbol) Pair() *[2]Object {
 }
 
 func (s symbol) Str() string {
-	return string(s)
+	return s.s.Name()
 }
 
-func (s symbol) F(_ []Object) (Object, *node.Node, *Env) {
+func (s symbol) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on symbol object")
 }
 
 func (s symbol) String() string {
-	return string(s)
+	return node.QuoteSymbol(s.s.Name())
 }
 
 func (s symbol) Display() string {
-	return string(s)
+	return s.s.Name()
 }
 
 func (s symbol) IsList() bool {
@@ -54,8 +72,7 @@ func (s symbol) IsTruthy() bool {
 }
 
 func (s symbol) Equals(object Object) bool {
-	if object.Type() != object_type.Symbol {
-		return false
-	}
-	return string(s) == object.Str()
+	// symbols are interned, so that symbols of the same name are the same
+	o, ok := object.(symbol)
+	return ok && s.s == o.s
 }
@@ -0,0 +1,210 @@
+package object
+
+import (
+	"context"
+	"errors"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sync"
+	"time"
+)
+
+var (
+	ErrThreadStarted    = errors.New("thread is already started")
+	ErrThreadTerminated = errors.New("thread was terminated")
+	ErrTimeout          = errors.New("timed out")
+)
+
+// Thread is a thread of SRFI-18, evaluating a thunk on its own goroutine.
+type Thread struct {
+	specific
+	// thunk is the function evaluated by the thread, nil for the primordial thread
+	thunk Object
+	// mu guards the fields below
+	mu         sync.Mutex
+	started    bool
+	terminated bool
+	cancel     context.CancelFunc
+	// done is closed when the thread terminates
+	done   chan struct{}
+	result Object
+}
+
+// NewThreadObject returns a new thread evaluating thunk, which is not started yet. name can be nil.
+func NewThreadObject(thunk, name Object) *Thread {
+	return &Thread{specific: newSpecific(name), thunk: thunk, done: make(chan struct{})}
+}
+
+// NewPrimordialThreadObject returns a new thread representing the thread evaluating the top-level forms.
+func NewPrimordialThreadObject() *Thread {
+	t := NewThreadObject(nil, NewSymbolObject("primordial"))
+	t.started = true
+	return t
+}
+
+// IsPrimordial returns true if this is the primordial thread.
+func (t *Thread) IsPrimordial() bool {
+	return t.thunk == nil
+}
+
+// Start starts this thread on a new goroutine, calling run with a context derived from ctx and the thunk.
+// The thread is terminated when ctx is done.
+func (t *Thread) Start(ctx context.Context, run func(ctx context.Context, thunk Object) Object) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started {
+		return ErrThreadStarted
+	}
+	t.started = true
+	ctx, t.cancel = context.WithCancel(ctx)
+	go func() {
+		res := run(ctx, t.thunk)
+		t.mu.Lock()
+		t.result = res
+		t.mu.Unlock()
+		t.cancel()
+		close(t.done)
+	}()
+	return nil
+}
+
+// Terminate terminates this thread. A running thread stops at the next evaluation step, without waiting for it.
+// The primordial thread cannot be terminated.
+func (t *Thread) Terminate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.terminated || t.IsPrimordial() {
+		return
+	}
+	select {
+	case <-t.done:
+		// already terminated normally
+		return
+	default:
+	}
+	t.terminated = true
+	if t.started {
+		t.cancel()
+	} else {
+		t.started = true
+		close(t.done)
+	}
+}
+
+// Join waits for this thread to terminate, and returns its result.
+// Returns ErrTimeout if the thread does not terminate within the timeout, where negative timeout means no timeout,
+// and ErrThreadTerminated if the thread was terminated by Terminate.
+func (t *Thread) Join(ctx context.Context, timeout time.Duration) (Object, error) {
+	timer, stop := newTimer(timeout)
+	defer stop()
+	select {
+	case <-t.done:
+	case <-timer:
+		return nil, ErrTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.terminated {
+		return nil, ErrThreadTerminated
+	}
+	return t.result, nil
+}
+
+func (t *Thread) Type() object_type.T {
+	return object_type.Thread
+}
+
+func (t *Thread) Number() float64 {
+	panic("number() called on thread object")
+}
+
+func (t *Thread) Bool() bool {
+	panic("Bool() called on thread object")
+}
+
+func (t *Thread) Pair() *[2]Object {
+	panic("Pair() called on thread object")
+}
+
+func (t *Thread) Str() string {
+	panic("Str() called on thread object")
+}
+
+func (t *Thread) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
+	panic("F() called on thread object")
+}
+
+func (t *Thread) String() string {
+	return t.describe("thread")
+}
+
+func (t *Thread) Display() string {
+	return t.String()
+}
+
+func (t *Thread) IsList() bool {
+	return false
+}
+
+func (t *Thread) ListElements() []Object {
+	panic("ListElements() called on thread object")
+}
+
+func (t *Thread) IsTruthy() bool {
+	return true
+}
+
+func (t *Thread) Equals(object Object) bool {
+	return object == Object(t)
+}
+
+// specific holds the name and the specific field of threads, mutexes and condition variables.
+type specific struct {
+	name Object
+	// specificMu guards value
+	specificMu sync.Mutex
+	value      Object
+}
+
+func newSpecific(name Object) specific {
+	return specific{name: name, value: VoidObj}
+}
+
+// Name returns the name of this object, or nil if not named.
+func (s *specific) Name() Object {
+	return s.name
+}
+
+// Specific returns the value of the specific field.
+func (s *specific) Specific() Object {
+	s.specificMu.Lock()
+	defer s.specificMu.Unlock()
+	return s.value
+}
+
+// SetSpecific sets the value of the specific field.
+func (s *specific) SetSpecific(value Object) {
+	s.specificMu.Lock()
+	defer s.specificMu.Unlock()
+	s.value = value
+}
+
+// describe returns the string representation of the object of the given kind, including the name if any.
+func (s *specific) describe(kind string) string {
+	if s.name == nil {
+		return "<" + kind + ">"
+	}
+	return "<" + kind + " " + s.name.Display() + ">"
+}
+
+// newTimer returns a channel receiving after the timeout, and a function to stop the timer.
+// The channel never receives if timeout is negative.
+func newTimer(timeout time.Duration) (<-chan time.Time, func()) {
+	if timeout < 0 {
+		return nil, func() {}
+	}
+	t := time.NewTimer(timeout)
+	return t.C, func() { t.Stop() }
+}
@@ -1,6 +1,7 @@
 package object
 
 import (
+	"context"
 	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
 	"github.com/motoki317/lisp-interpreter/node"
 )
@@ -25,7 +26,7 @@ func (v void) Str() string {
 	panic("Str() called on void object")
 }
 
-func (v void) F(_ []Object) (Object, *node.Node, *Env) {
+func (v void) F(_ context.Context, _ []Object) (Object, *node.Node, *Env) {
 	panic("F() called on void object")
 }
 
@@ -0,0 +1,311 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/lisp/printer"
+	"github.com/motoki317/lisp-interpreter/node"
+	"io"
+)
+
+// portEnv is the environment of port functions not depending on the interpreter,
+// separated from defaultEnv so that programs without the I/O capability cannot use ports.
+var portEnv = make(map[string]object.Object)
+
+func init() {
+	portEnv["port?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Port)
+		}))
+	portEnv["input-port?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			p, ok := objects[0].(*object.Port)
+			return object.NewBooleanObject(ok && p.IsInput())
+		}))
+	portEnv["output-port?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			p, ok := objects[0].(*object.Port)
+			return object.NewBooleanObject(ok && p.IsOutput())
+		}))
+	portEnv["input-port-open?"] = object.NewWrappedFunctionObject(
+		makeUnary(makePort("input-port-open?", func(p *object.Port) object.Object {
+			return object.NewBooleanObject(p.IsInput() && p.IsOpen())
+		})))
+	portEnv["output-port-open?"] = object.NewWrappedFunctionObject(
+		makeUnary(makePort("output-port-open?", func(p *object.Port) object.Object {
+			return object.NewBooleanObject(p.IsOutput() && p.IsOpen())
+		})))
+	closePort := makeUnary(makePort("close-port", func(p *object.Port) object.Object {
+		if err := p.Close(); err != nil {
+			return object.NewErrorObject(fmt.Sprintf("an error occurred while closing port: %v", err))
+		}
+		return object.VoidObj
+	}))
+	portEnv["close-port"] = object.NewWrappedFunctionObject(closePort)
+	portEnv["close-input-port"] = object.NewWrappedFunctionObject(closePort)
+	portEnv["close-output-port"] = object.NewWrappedFunctionObject(closePort)
+
+	portEnv["eof-object"] = object.NewWrappedFunctionObject(
+		makeNullary(func(_ context.Context, _ []object.Object) object.Object {
+			return object.EOFObj
+		}))
+	portEnv["eof-object?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.EOF)
+		}))
+
+	portEnv["open-input-string"] = object.NewWrappedFunctionObject(
+		makeUnary(makeStrings(func(input []string) object.Object {
+			return object.NewStringInputPortObject(input[0])
+		})))
+	portEnv["open-output-string"] = object.NewWrappedFunctionObject(
+		makeNullary(func(_ context.Context, _ []object.Object) object.Object {
+			return object.NewStringOutputPortObject()
+		}))
+	portEnv["get-output-string"] = object.NewWrappedFunctionObject(
+		makeUnary(makePort("get-output-string", func(p *object.Port) object.Object {
+			s, err := p.OutputString()
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("get-output-string: %v", err))
+			}
+			return object.NewStringObject(s)
+		})))
+}
+
+// WithErrorOutput sets the output of the current error port, to which profile writes its reports.
+// os.Stderr is used by default.
+func WithErrorOutput(errOut io.Writer) Option {
+	return func(i *Interpreter) {
+		i.errOut = errOut
+	}
+}
+
+// outputWriter writes to the output of the interpreter, which can be changed by SetOutput.
+type outputWriter struct {
+	i *Interpreter
+}
+
+func (w outputWriter) Write(p []byte) (int, error) {
+	return w.i.out.Write(p)
+}
+
+// definePortFuncs defines functions using the current ports of this interpreter to the given frame.
+func (i *Interpreter) definePortFuncs(global object.Frame) {
+	i.curIn = object.NewParameterObject(object.NewParserInputPortObject("console", i.p), nil)
+	i.curOut = object.NewParameterObject(object.NewOutputPortObject("console", outputWriter{i}, nil), nil)
+	i.curErr = object.NewParameterObject(object.NewOutputPortObject("stderr", i.errOut, nil), nil)
+	global["current-input-port"] = i.curIn
+	global["current-output-port"] = i.curOut
+	global["current-error-port"] = i.curErr
+	for k, v := range portEnv {
+		global[k] = v
+	}
+
+	global["display"] = object.NewWrappedFunctionObject(
+		i.makeOutput("display", 1, func(ctx context.Context, p *object.Port, objects []object.Object) object.Object {
+			return writeString(ctx, p, printer.Display(objects[0]))
+		}))
+	global["write"] = object.NewWrappedFunctionObject(
+		i.makeOutput("write", 1, func(ctx context.Context, p *object.Port, objects []object.Object) object.Object {
+			return writeString(ctx, p, printer.Write(objects[0]))
+		}))
+	global["write-shared"] = object.NewWrappedFunctionObject(
+		i.makeOutput("write-shared", 1, func(ctx context.Context, p *object.Port, objects []object.Object) object.Object {
+			return writeString(ctx, p, printer.WriteShared(objects[0]))
+		}))
+	global["write-simple"] = object.NewWrappedFunctionObject(
+		i.makeOutput("write-simple", 1, func(ctx context.Context, p *object.Port, objects []object.Object) object.Object {
+			return writeString(ctx, p, printer.WriteSimple(objects[0]))
+		}))
+	global["newline"] = object.NewWrappedFunctionObject(
+		i.makeOutput("newline", 0, func(ctx context.Context, p *object.Port, _ []object.Object) object.Object {
+			return writeString(ctx, p, "\n")
+		}))
+	global["write-char"] = object.NewWrappedFunctionObject(
+		i.makeOutput("write-char", 1, func(ctx context.Context, p *object.Port, objects []object.Object) object.Object {
+			c := objects[0]
+			if c.Type() != object_type.Char {
+				return object.NewErrorObject(fmt.Sprintf("write-char: expected 1st argument to be char, but got %v", c))
+			}
+			return writeString(ctx, p, c.Str())
+		}))
+	global["write-string"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (write-string string [port [start [end]]])
+		if len(objects) == 0 || len(objects) > 4 {
+			return object.NewErrorObject(fmt.Sprintf("write-string: expected 1 to 4 arguments, but got %v", len(objects)))
+		}
+		s := objects[0]
+		if s.Type() != object_type.Str {
+			return object.NewErrorObject(fmt.Sprintf("write-string: expected 1st argument to be string, but got %v", s))
+		}
+		p, errObj := portArg(ctx, "write-string", objects, 1, i.curOut)
+		if errObj != nil {
+			return errObj
+		}
+		runes := []rune(s.Str())
+		start, end := 0, len(runes)
+		if len(objects) >= 3 {
+			if start, errObj = indexArg("write-string", 2, objects[2]); errObj != nil {
+				return errObj
+			}
+		}
+		if len(objects) == 4 {
+			if end, errObj = indexArg("write-string", 3, objects[3]); errObj != nil {
+				return errObj
+			}
+		}
+		if start > end || end > len(runes) {
+			return object.NewErrorObject(fmt.Sprintf("write-string: invalid range [%v, %v) for string of length %v", start, end, len(runes)))
+		}
+		return writeString(ctx, p, string(runes[start:end]))
+	})
+	global["flush-output-port"] = object.NewWrappedFunctionObject(
+		i.makeOutput("flush-output-port", 0, func(_ context.Context, _ *object.Port, _ []object.Object) object.Object {
+			return object.VoidObj
+		}))
+
+	global["read"] = object.NewWrappedFunctionObject(
+		i.makeInput("read", func(ctx context.Context, p *object.Port) object.Object {
+			n, err := p.ReadNode()
+			if err == node.EOF {
+				return object.EOFObj
+			}
+			if err != nil {
+				return object.NewErrorObject(fmt.Sprintf("an error occurred while reading from input: %v", err))
+			}
+			if errObj := allocate(ctx, 0, symbolBytes(n)); errObj != nil {
+				return errObj
+			}
+			return evalQuote(n)
+		}))
+	global["read-char"] = object.NewWrappedFunctionObject(
+		i.makeInput("read-char", func(_ context.Context, p *object.Port) object.Object {
+			c, err := p.ReadChar()
+			return charResult("read-char", c, err)
+		}))
+	global["peek-char"] = object.NewWrappedFunctionObject(
+		i.makeInput("peek-char", func(_ context.Context, p *object.Port) object.Object {
+			c, err := p.PeekChar()
+			return charResult("peek-char", c, err)
+		}))
+	global["read-line"] = object.NewWrappedFunctionObject(
+		i.makeInput("read-line", func(ctx context.Context, p *object.Port) object.Object {
+			line, err := p.ReadLine()
+			return stringResult(ctx, "read-line", line, err)
+		}))
+	global["read-string"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (read-string k [port])
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("read-string: expected 1 or 2 arguments, but got %v", len(objects)))
+		}
+		k, errObj := indexArg("read-string", 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		p, errObj := portArg(ctx, "read-string", objects, 1, i.curIn)
+		if errObj != nil {
+			return errObj
+		}
+		s, err := p.ReadString(k)
+		return stringResult(ctx, "read-string", s, err)
+	})
+
+	global["with-output-to-string"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			thunk := objects[0]
+			if thunk.Type() != object_type.Function {
+				return object.NewErrorObject(fmt.Sprintf("with-output-to-string: expected a function, but got %v", thunk))
+			}
+			p := object.NewStringOutputPortObject()
+			res := callWithTailOptimization(
+				object.WithParameterValues(ctx, []*object.Parameter{i.curOut}, []object.Object{p}), thunk.F, []object.Object{})
+			if res.Type() == object_type.Err {
+				return res
+			}
+			s, _ := p.(*object.Port).OutputString()
+			return allocateString(ctx, s)
+		}))
+}
+
+// makeOutput makes an output function taking n arguments and optionally a port, defaulting to the current output port.
+func (i *Interpreter) makeOutput(name string, n int, next func(ctx context.Context, p *object.Port, objects []object.Object) object.Object) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		if len(objects) != n && len(objects) != n+1 {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected %v or %v arguments, but got %v", name, n, n+1, len(objects)))
+		}
+		p, errObj := portArg(ctx, name, objects, n, i.curOut)
+		if errObj != nil {
+			return errObj
+		}
+		return next(ctx, p, objects[:n])
+	}
+}
+
+// makeInput makes an input function optionally taking a port, defaulting to the current input port.
+func (i *Interpreter) makeInput(name string, next func(ctx context.Context, p *object.Port) object.Object) generalFunc {
+	return func(ctx context.Context, objects []object.Object) object.Object {
+		if len(objects) > 1 {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected 0 or 1 arguments, but got %v", name, len(objects)))
+		}
+		p, errObj := portArg(ctx, name, objects, 0, i.curIn)
+		if errObj != nil {
+			return errObj
+		}
+		return next(ctx, p)
+	}
+}
+
+// portArg returns the optional port at objects[idx], or the current value of the given parameter if not given.
+func portArg(ctx context.Context, name string, objects []object.Object, idx int, def *object.Parameter) (*object.Port, object.Object) {
+	o := def.Value(ctx)
+	if idx < len(objects) {
+		o = objects[idx]
+	}
+	p, ok := o.(*object.Port)
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected port, but got %v", name, o))
+	}
+	return p, nil
+}
+
+func makePort(name string, next func(p *object.Port) object.Object) generalFunc {
+	return func(_ context.Context, objects []object.Object) object.Object {
+		p, ok := objects[0].(*object.Port)
+		if !ok {
+			return object.NewErrorObject(fmt.Sprintf("%v: expected port, but got %v", name, objects[0]))
+		}
+		return next(p)
+	}
+}
+
+func writeString(ctx context.Context, p *object.Port, s string) object.Object {
+	if errObj := output(ctx, len(s)); errObj != nil {
+		return errObj
+	}
+	if _, err := io.WriteString(p, s); err != nil {
+		return object.NewErrorObject(fmt.Sprintf("an error occurred while writing to output: %v", err))
+	}
+	return object.VoidObj
+}
+
+func charResult(name string, c rune, err error) object.Object {
+	if err == io.EOF {
+		return object.EOFObj
+	}
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("%v: %v", name, err))
+	}
+	return object.NewCharObject(c)
+}
+
+func stringResult(ctx context.Context, name string, s string, err error) object.Object {
+	if err == io.EOF {
+		return object.EOFObj
+	}
+	if err != nil {
+		return object.NewErrorObject(fmt.Sprintf("%v: %v", name, err))
+	}
+	return allocateString(ctx, s)
+}
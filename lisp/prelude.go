@@ -0,0 +1,73 @@
+package lisp
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"path"
+	"sync"
+)
+
+// preludeFS holds the standard library written in Lisp, loaded into the global environment by NewInterpreter.
+//
+//go:embed prelude/*.scm
+var preludeFS embed.FS
+
+var (
+	preludeOnce  sync.Once
+	preludeForms []*node.Node
+)
+
+// WithoutPrelude skips loading the prelude, the standard library written in Lisp, into the global environment.
+func WithoutPrelude() Option {
+	return func(i *Interpreter) {
+		i.noPrelude = true
+	}
+}
+
+// prelude returns the forms of the prelude files in the order of their names, parsed only once.
+func prelude() []*node.Node {
+	preludeOnce.Do(func() {
+		entries, err := preludeFS.ReadDir("prelude")
+		if err != nil {
+			panic(fmt.Sprintf("prelude: %v", err))
+		}
+		for _, entry := range entries {
+			name := path.Join("prelude", entry.Name())
+			src, err := preludeFS.ReadFile(name)
+			if err != nil {
+				panic(fmt.Sprintf("prelude: %v", err))
+			}
+			p := node.NewParser(token.NewTokenizer(bytes.NewReader(src)))
+			for {
+				n, err := p.Next()
+				if err == node.EOF {
+					break
+				}
+				if err != nil {
+					panic(fmt.Sprintf("prelude: %v:%v: %v", name, p.Pos(), err))
+				}
+				preludeForms = append(preludeForms, n)
+			}
+		}
+	})
+	return preludeForms
+}
+
+// loadPrelude evaluates the prelude in the global environment.
+func (i *Interpreter) loadPrelude() {
+	ctx := withEvalState(context.Background(), Limits{})
+	for _, form := range prelude() {
+		n, err := applyMacro(i.globalEnv, form)
+		if err != nil {
+			panic(fmt.Sprintf("prelude: %v: %v", form.Pos, err))
+		}
+		if res := i.eval(ctx, n, i.globalEnv); res.Type() == object_type.Err {
+			panic(fmt.Sprintf("prelude: %v: %v", form.Pos, res.Str()))
+		}
+	}
+}
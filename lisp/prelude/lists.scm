@@ -0,0 +1,110 @@
+; List utilities
+; Builtins and other functions are captured when loaded, so that redefining them globally does not change these functions.
+
+(define first
+  (let ((car car))
+    (lambda (x) (car x))))
+
+(define second
+  (let ((cadr cadr))
+    (lambda (x) (cadr x))))
+
+(define third
+  (let ((caddr caddr))
+    (lambda (x) (caddr x))))
+
+(define last
+  (let ((car car) (last-pair last-pair))
+    (lambda (x) (car (last-pair x)))))
+
+(define any
+  (let ((car car) (cdr cdr) (null? null?) (not not))
+    (lambda (pred lst)
+      (define (loop lst)
+        (and (not (null? lst))
+             (or (pred (car lst)) (loop (cdr lst)))))
+      (loop lst))))
+
+(define every
+  (let ((car car) (cdr cdr) (null? null?))
+    (lambda (pred lst)
+      (define (loop lst)
+        (cond ((null? lst) #t)
+              ((null? (cdr lst)) (pred (car lst)))
+              ((pred (car lst)) (loop (cdr lst)))
+              (else #f)))
+      (loop lst))))
+
+(define find
+  (let ((car car) (cdr cdr) (null? null?))
+    (lambda (pred lst)
+      (define (loop lst)
+        (cond ((null? lst) #f)
+              ((pred (car lst)) (car lst))
+              (else (loop (cdr lst)))))
+      (loop lst))))
+
+(define find-tail
+  (let ((car car) (cdr cdr) (null? null?))
+    (lambda (pred lst)
+      (define (loop lst)
+        (cond ((null? lst) #f)
+              ((pred (car lst)) lst)
+              (else (loop (cdr lst)))))
+      (loop lst))))
+
+(define list-index
+  (let ((car car) (cdr cdr) (null? null?) (+ +))
+    (lambda (pred lst)
+      (define (loop lst k)
+        (cond ((null? lst) #f)
+              ((pred (car lst)) k)
+              (else (loop (cdr lst) (+ k 1)))))
+      (loop lst 0))))
+
+(define count
+  (let ((fold-left fold-left) (+ +))
+    (lambda (pred lst)
+      (fold-left (lambda (acc x) (if (pred x) (+ acc 1) acc)) 0 lst))))
+
+(define remove
+  (let ((filter filter) (not not))
+    (lambda (pred lst)
+      (filter (lambda (x) (not (pred x))) lst))))
+
+(define partition
+  (let ((filter filter) (remove remove) (list list))
+    (lambda (pred lst)
+      (list (filter pred lst) (remove pred lst)))))
+
+(define filter-map
+  (let ((filter filter) (map map))
+    (lambda (f lst)
+      (filter (lambda (x) x) (map f lst)))))
+
+(define append-map
+  (let ((map map) (apply apply) (append append))
+    (lambda (f lst)
+      (apply append (map f lst)))))
+
+(define delete-duplicates
+  (let ((member member) (car car) (cdr cdr) (cons cons) (null? null?) (reverse reverse))
+    (lambda (lst)
+      (define (loop lst acc)
+        (cond ((null? lst) (reverse acc))
+              ((member (car lst) acc) (loop (cdr lst) acc))
+              (else (loop (cdr lst) (cons (car lst) acc)))))
+      (loop lst '()))))
+
+(define make-list
+  (let ((car car) (cons cons) (null? null?) (= =) (- -))
+    (lambda (n . fill)
+      (define x (if (null? fill) #f (car fill)))
+      (define (loop n acc)
+        (if (= n 0) acc (loop (- n 1) (cons x acc))))
+      (loop n '()))))
+
+(define list-tabulate
+  (let ((map map) (iota iota))
+    (lambda (n f)
+      (map f (iota n)))))
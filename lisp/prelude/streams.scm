@@ -0,0 +1,45 @@
+; Stream utilities
+; Builtins and other functions are captured when loaded, so that redefining them globally does not change these functions.
+
+(define stream-ref
+  (let ((stream-car stream-car) (stream-cdr stream-cdr) (= =) (- -))
+    (lambda (s n)
+      (define (loop s n)
+        (if (= n 0)
+            (stream-car s)
+            (loop (stream-cdr s) (- n 1))))
+      (loop s n))))
+
+(define stream-map
+  (let ((stream-car stream-car) (stream-cdr stream-cdr) (stream-null? stream-null?) (stream-null stream-null))
+    (lambda (f s)
+      (define (loop s)
+        (if (stream-null? s)
+            stream-null
+            (stream-cons (f (stream-car s)) (loop (stream-cdr s)))))
+      (loop s))))
+
+(define stream-for-each
+  (let ((stream-car stream-car) (stream-cdr stream-cdr) (stream-null? stream-null?))
+    (lambda (f s)
+      (define (loop s)
+        (if (stream-null? s)
+            (if #f #f)
+            (begin (f (stream-car s)) (loop (stream-cdr s)))))
+      (loop s))))
+
+(define stream-append
+  (let ((stream-car stream-car) (stream-cdr stream-cdr) (stream-null? stream-null?))
+    (lambda (s1 s2)
+      (define (loop s1)
+        (if (stream-null? s1)
+            s2
+            (stream-cons (stream-car s1) (loop (stream-cdr s1)))))
+      (loop s1))))
+
+(define stream-from
+  (let ((+ +))
+    (lambda (n)
+      (define (loop n)
+        (stream-cons n (loop (+ n 1))))
+      (loop n))))
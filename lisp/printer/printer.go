@@ -0,0 +1,183 @@
+// Package printer implements the external representations of objects, as written by write and display.
+package printer
+
+import (
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"strconv"
+	"strings"
+)
+
+// labelMode determines which pairs are given datum labels (#n= and #n#).
+type labelMode int
+
+const (
+	// labelNone gives no labels. Printing circular structure does not terminate.
+	labelNone labelMode = iota
+	// labelCycles gives labels only to pairs forming cycles.
+	labelCycles
+	// labelShared gives labels to all pairs appearing more than once.
+	labelShared
+)
+
+// Write returns the external representation of o which can be read back by read.
+// Datum labels are used only for circular structure.
+func Write(o object.Object) string {
+	return print(o, false, labelCycles)
+}
+
+// WriteShared is like Write, but uses datum labels for all shared structure.
+func WriteShared(o object.Object) string {
+	return print(o, false, labelShared)
+}
+
+// WriteSimple is like Write, but never uses datum labels.
+// It does not terminate if o contains circular structure.
+func WriteSimple(o object.Object) string {
+	return print(o, false, labelNone)
+}
+
+// Display returns the human-readable representation of o, which does not escape strings, characters and symbols.
+// Datum labels are used only for circular structure.
+func Display(o object.Object) string {
+	return print(o, true, labelCycles)
+}
+
+func print(o object.Object, display bool, mode labelMode) string {
+	p := &printer{display: display}
+	if mode != labelNone {
+		p.labels = findLabels(o, mode == labelShared)
+	}
+	p.print(o)
+	return p.sb.String()
+}
+
+// findLabels returns the set of pairs reachable from o which need datum labels.
+func findLabels(o object.Object, shared bool) map[*[2]object.Object]int {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	states := make(map[*[2]object.Object]int)
+	labels := make(map[*[2]object.Object]int)
+
+	// scan is a list being scanned, iterating on cdr and scanning car before the rest of the list.
+	// Pairs of the list are kept in visiting state until the whole list is scanned.
+	type scan struct {
+		o    object.Object
+		list []*[2]object.Object
+	}
+	// use an explicit stack instead of recursion on car, so that deeply nested structure does not overflow the Go stack
+	stack := []*scan{{o: o}}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		if s.o != nil && s.o.Type() == object_type.Cons {
+			pair := s.o.Pair()
+			if state, ok := states[pair]; ok {
+				if shared || state == visiting {
+					labels[pair] = -1
+				}
+				s.o = nil
+				continue
+			}
+			states[pair] = visiting
+			s.list = append(s.list, pair)
+			s.o = pair[1]
+			stack = append(stack, &scan{o: pair[0]})
+			continue
+		}
+		for _, pair := range s.list {
+			states[pair] = visited
+		}
+		stack = stack[:len(stack)-1]
+	}
+	return labels
+}
+
+type printer struct {
+	sb      strings.Builder
+	display bool
+	// labels is a map from labeled pairs to their label numbers, -1 if not printed yet
+	labels    map[*[2]object.Object]int
+	nextLabel int
+}
+
+// label writes the datum label of the pair if necessary, and returns true if the pair was already printed.
+func (p *printer) label(pair *[2]object.Object) bool {
+	n, ok := p.labels[pair]
+	if !ok {
+		return false
+	}
+	if n >= 0 {
+		p.sb.WriteString("#" + strconv.Itoa(n) + "#")
+		return true
+	}
+	p.labels[pair] = p.nextLabel
+	p.sb.WriteString("#" + strconv.Itoa(p.nextLabel) + "=")
+	p.nextLabel++
+	return false
+}
+
+// printTask is a part of the representation to be printed.
+type printTask struct {
+	kind printTaskKind
+	o    object.Object
+	s    string
+}
+
+type printTaskKind int
+
+const (
+	// printObject prints the object o.
+	printObject printTaskKind = iota
+	// printRest prints o, the rest of a list after an element, and the closing parenthesis.
+	printRest
+	// printString prints the string s as is.
+	printString
+)
+
+// print prints o, using an explicit stack instead of recursion on car,
+// so that deeply nested structure does not overflow the Go stack.
+func (p *printer) print(o object.Object) {
+	stack := []printTask{{kind: printObject, o: o}}
+	for len(stack) > 0 {
+		t := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch t.kind {
+		case printString:
+			p.sb.WriteString(t.s)
+		case printObject:
+			if t.o.Type() != object_type.Cons {
+				if p.display {
+					p.sb.WriteString(t.o.Display())
+				} else {
+					p.sb.WriteString(t.o.String())
+				}
+				continue
+			}
+			pair := t.o.Pair()
+			if p.label(pair) {
+				continue
+			}
+			p.sb.WriteByte('(')
+			stack = append(stack, printTask{kind: printRest, o: pair[1]}, printTask{kind: printObject, o: pair[0]})
+		case printRest:
+			next := t.o
+			if next.Type() == object_type.Null {
+				p.sb.WriteByte(')')
+				continue
+			}
+			if next.Type() == object_type.Cons {
+				pair := next.Pair()
+				if _, labeled := p.labels[pair]; !labeled {
+					p.sb.WriteByte(' ')
+					stack = append(stack, printTask{kind: printRest, o: pair[1]}, printTask{kind: printObject, o: pair[0]})
+					continue
+				}
+				// the rest of the list is shared, print it in dotted form
+			}
+			p.sb.WriteString(" . ")
+			stack = append(stack, printTask{kind: printString, s: ")"}, printTask{kind: printObject, o: next})
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package printer
+
+import (
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"math"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func list(objects ...object.Object) object.Object {
+	var ret object.Object = object.NullObj
+	for i := len(objects) - 1; i >= 0; i-- {
+		ret = object.NewConsObject(objects[i], ret)
+	}
+	return ret
+}
+
+func TestPrinter(t *testing.T) {
+	circular := list(object.NewNumberObject(1), object.NewNumberObject(2))
+	circular.Pair()[1].Pair()[1] = circular
+
+	shared := list(object.NewSymbolObject("a"))
+	sharing := list(shared, shared)
+
+	tests := []struct {
+		name        string
+		o           object.Object
+		write       string
+		writeShared string
+		display     string
+	}{
+		{
+			name:        "string",
+			o:           object.NewStringObject("po\n\"po\"\\"),
+			write:       `"po\n\"po\"\\"`,
+			writeShared: `"po\n\"po\"\\"`,
+			display:     "po\n\"po\"\\",
+		},
+		{
+			name:        "char",
+			o:           list(object.NewCharObject('a'), object.NewCharObject(' ')),
+			write:       `(#\a #\space)`,
+			writeShared: `(#\a #\space)`,
+			display:     "(a  )",
+		},
+		{
+			name:        "symbol",
+			o:           list(object.NewSymbolObject("po"), object.NewSymbolObject("po po"), object.NewSymbolObject("1"), object.NewSymbolObject("")),
+			write:       `(po |po po| |1| ||)`,
+			writeShared: `(po |po po| |1| ||)`,
+			display:     "(po po po 1 )",
+		},
+		{
+			name: "number",
+			o: list(object.NewNumberObject(1), object.NewInexactNumberObject(1), object.NewInexactNumberObject(math.Copysign(0, -1)),
+				object.NewNumberObject(math.Copysign(0, -1)), object.NewInexactNumberObject(1e21), object.NewInexactNumberObject(-1.5)),
+			write:       "(1 1.0 -0.0 0 1000000000000000000000.0 -1.5)",
+			writeShared: "(1 1.0 -0.0 0 1000000000000000000000.0 -1.5)",
+			display:     "(1 1.0 -0.0 0 1000000000000000000000.0 -1.5)",
+		},
+		{
+			name:        "infinity and nan",
+			o:           list(object.NewNumberObject(math.Inf(1)), object.NewNumberObject(math.Inf(-1)), object.NewNumberObject(math.NaN())),
+			write:       "(+inf.0 -inf.0 +nan.0)",
+			writeShared: "(+inf.0 -inf.0 +nan.0)",
+			display:     "(+inf.0 -inf.0 +nan.0)",
+		},
+		{
+			name:        "dotted",
+			o:           object.NewConsObject(object.NewStringObject("a"), object.NewNumberObject(1)),
+			write:       `("a" . 1)`,
+			writeShared: `("a" . 1)`,
+			display:     "(a . 1)",
+		},
+		{
+			name:        "circular",
+			o:           circular,
+			write:       "#0=(1 2 . #0#)",
+			writeShared: "#0=(1 2 . #0#)",
+			display:     "#0=(1 2 . #0#)",
+		},
+		{
+			name:        "circular car",
+			o:           list(circular, circular),
+			write:       "(#0=(1 2 . #0#) #0#)",
+			writeShared: "(#0=(1 2 . #0#) #0#)",
+			display:     "(#0=(1 2 . #0#) #0#)",
+		},
+		{
+			name:        "nested",
+			o:           list(list(list(), object.NewNumberObject(1)), object.NewConsObject(object.NewNumberObject(2), object.NewNumberObject(3))),
+			write:       "((() 1) (2 . 3))",
+			writeShared: "((() 1) (2 . 3))",
+			display:     "((() 1) (2 . 3))",
+		},
+		{
+			name:        "shared tail",
+			o:           object.NewConsObject(shared, shared),
+			write:       "((a) a)",
+			writeShared: "(#0=(a) . #0#)",
+			display:     "((a) a)",
+		},
+		{
+			name:        "shared",
+			o:           sharing,
+			write:       "((a) (a))",
+			writeShared: "(#0=(a) #0#)",
+			display:     "((a) (a))",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Write(tt.o); got != tt.write {
+				t.Errorf("Write() = %v, want %v", got, tt.write)
+			}
+			if got := WriteShared(tt.o); got != tt.writeShared {
+				t.Errorf("WriteShared() = %v, want %v", got, tt.writeShared)
+			}
+			if got := Display(tt.o); got != tt.display {
+				t.Errorf("Display() = %v, want %v", got, tt.display)
+			}
+		})
+	}
+}
+
+func TestPrinterDeep(t *testing.T) {
+	// make a Go stack overflow in recursion over the structure fail fast
+	defer debug.SetMaxStack(debug.SetMaxStack(16 << 20))
+
+	const depth = 200000
+	var o object.Object = object.NullObj
+	for i := 0; i < depth; i++ {
+		o = list(o)
+	}
+	want := strings.Repeat("(", depth+1) + strings.Repeat(")", depth+1)
+	if got := Write(o); got != want {
+		t.Errorf("Write() = %v..., want %v...", got[:10], want[:10])
+	}
+	if got := Display(o); got != want {
+		t.Errorf("Display() = %v..., want %v...", got[:10], want[:10])
+	}
+	if got := WriteShared(o); got != want {
+		t.Errorf("WriteShared() = %v..., want %v...", got[:10], want[:10])
+	}
+}
@@ -0,0 +1,238 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// profileInterval is the interval of sampling the functions being evaluated by profile.
+const profileInterval = time.Millisecond
+
+// otherFunctions is the name in profile reports for evaluation outside of any named function.
+const otherFunctions = "(other)"
+
+// profilers is the number of running profilers, so that the functions being evaluated are tracked only while profiling.
+var profilers int32
+
+// profiling returns true if any profiler is running.
+func profiling() bool {
+	return atomic.LoadInt32(&profilers) != 0
+}
+
+type profileTrackKey struct{}
+
+type profileOutputKey struct{}
+
+// profiler samples the named functions being evaluated by the threads of an evaluation of profile,
+// and counts the allocations made by them.
+type profiler struct {
+	mu      sync.Mutex
+	tracks  []*profileTrack
+	entries map[string]*profileEntry
+	// samples is the number of times the threads were sampled
+	samples int64
+}
+
+// profileEntry is the resources used by a named function, excluding the named functions called by it.
+type profileEntry struct {
+	samples, conses, stringBytes int64
+}
+
+// profileTrack tracks the named function being evaluated by a thread.
+type profileTrack struct {
+	p       *profiler
+	current atomic.Value
+}
+
+func newProfiler() *profiler {
+	return &profiler{entries: make(map[string]*profileEntry)}
+}
+
+// entry returns the entry of the named function, where mu must be held.
+func (p *profiler) entry(name string) *profileEntry {
+	if name == "" {
+		name = otherFunctions
+	}
+	e, ok := p.entries[name]
+	if !ok {
+		e = &profileEntry{}
+		p.entries[name] = e
+	}
+	return e
+}
+
+// track returns a new context tracking the functions evaluated by a thread, starting with the given function.
+func (p *profiler) track(ctx context.Context, name string) context.Context {
+	t := &profileTrack{p: p}
+	t.current.Store(name)
+	p.mu.Lock()
+	p.tracks = append(p.tracks, t)
+	p.mu.Unlock()
+	return context.WithValue(ctx, profileTrackKey{}, t)
+}
+
+// sample records the functions being evaluated by all threads.
+func (p *profiler) sample() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.samples++
+	for _, t := range p.tracks {
+		p.entry(t.get()).samples++
+	}
+}
+
+// run samples the threads until stop is closed.
+func (p *profiler) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(profileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.sample()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// report formats the resources used by the named functions, estimating the time from the samples.
+func (p *profiler) report(elapsed time.Duration) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.entries))
+	for name := range p.entries {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.entries[names[i]], p.entries[names[j]]
+		if a.samples != b.samples {
+			return a.samples > b.samples
+		}
+		return names[i] < names[j]
+	})
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "profile: %v elapsed, %v samples\n", elapsed.Round(time.Microsecond), p.samples)
+	fmt.Fprintf(&sb, "%12v %7v %10v %12v  %v\n", "time", "%", "conses", "string-bytes", "function")
+	for _, name := range names {
+		e := p.entries[name]
+		var ratio float64
+		if p.samples > 0 {
+			ratio = float64(e.samples) / float64(p.samples)
+		}
+		t := time.Duration(ratio * float64(elapsed)).Round(time.Microsecond)
+		fmt.Fprintf(&sb, "%12v %7.1f %10v %12v  %v\n", t, ratio*100, e.conses, e.stringBytes, name)
+	}
+	return sb.String()
+}
+
+// profileTrackOf returns the profile track of the context, or nil if not profiled.
+func profileTrackOf(ctx context.Context) *profileTrack {
+	t, _ := ctx.Value(profileTrackKey{}).(*profileTrack)
+	return t
+}
+
+// get returns the named function being evaluated.
+func (t *profileTrack) get() string {
+	if t == nil {
+		return ""
+	}
+	return t.current.Load().(string)
+}
+
+// set sets the named function being evaluated, to restore the one returned by call.
+func (t *profileTrack) set(name string) {
+	if t != nil {
+		t.current.Store(name)
+	}
+}
+
+// call records calling the function of the name, and returns the function previously being evaluated.
+// Calls to anonymous functions are attributed to the caller.
+func (t *profileTrack) call(name string) string {
+	if t == nil {
+		return ""
+	}
+	prev := t.get()
+	if name != "" {
+		t.current.Store(name)
+	}
+	return prev
+}
+
+// allocate records the allocations made by the function being evaluated.
+func (t *profileTrack) allocate(conses int, stringBytes int) {
+	if t == nil || conses == 0 && stringBytes == 0 {
+		return
+	}
+	name := t.get()
+	t.p.mu.Lock()
+	defer t.p.mu.Unlock()
+	e := t.p.entry(name)
+	e.conses += int64(conses)
+	e.stringBytes += int64(stringBytes)
+}
+
+// forkProfileTrack returns a new context for evaluation on another thread, tracked by the same profiler as ctx.
+func forkProfileTrack(ctx context.Context) context.Context {
+	t := profileTrackOf(ctx)
+	if t == nil {
+		return ctx
+	}
+	return t.p.track(ctx, t.get())
+}
+
+// withProfileOutput returns a new context writing profile reports to the port of the parameter.
+func withProfileOutput(ctx context.Context, port *object.Parameter) context.Context {
+	if port == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, profileOutputKey{}, port)
+}
+
+// compileProfile compiles (profile expr), which evaluates expr and writes a report of the time and allocations
+// attributed to the named functions evaluated by it to the current error port.
+// The time is estimated by sampling the function being evaluated by each thread at profileInterval.
+func compileProfile(n *node.Node, s *scope) code {
+	if len(n.Children) != 2 {
+		return errorCode("profile needs exactly 1 argument, but got %v", len(n.Children)-1)
+	}
+	return profileCode(compile(n.Children[1], s))
+}
+
+// profileCode returns the code evaluating the code with a new profiler, and writing the report.
+func profileCode(expr code) code {
+	return func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		res, report := profile(ctx, expr, env)
+		if port, ok := ctx.Value(profileOutputKey{}).(*object.Parameter); ok {
+			if out, ok := port.Value(ctx).(*object.Port); ok {
+				if errObj := writeString(ctx, out, report); errObj.Type() == object_type.Err {
+					return errObj, nil, nil
+				}
+			}
+		}
+		return res, nil, nil
+	}
+}
+
+// profile evaluates the code with a new profiler, and returns the result and the report.
+func profile(ctx context.Context, c code, env *object.Env) (object.Object, string) {
+	p := newProfiler()
+	stop := make(chan struct{})
+	atomic.AddInt32(&profilers, 1)
+	defer atomic.AddInt32(&profilers, -1)
+	go p.run(stop)
+	defer close(stop)
+
+	start := time.Now()
+	res := evalCode(p.track(ctx, ""), c, env)
+	return res, p.report(time.Since(start))
+}
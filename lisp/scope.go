@@ -0,0 +1,143 @@
+package lisp
+
+import (
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+// scope is the compile-time counterpart of a local environment, resolving variables to slots.
+// A nil scope is the global environment, where variables are looked up by name.
+type scope struct {
+	names []string
+	// mutable is true for the slots assigned after the environment is made, by set! or internal define,
+	// which are accessed with locking as the environment might be shared with other threads
+	mutable []bool
+	// assigned are the names assigned by set! in the body of the scope, including nested scopes
+	assigned map[string]bool
+	upper    *scope
+}
+
+// newScope returns a new scope of the body, binding the given names followed by the internal defines of the body.
+func newScope(names []string, body []*node.Node, upper *scope) *scope {
+	s := &scope{assigned: make(map[string]bool), upper: upper}
+	for _, n := range body {
+		assignedNames(n, s.assigned)
+	}
+	for _, name := range names {
+		s.names = append(s.names, name)
+		s.mutable = append(s.mutable, s.assigned[name])
+	}
+	s.defineAll(body)
+	return s
+}
+
+// add adds a slot for the name if not in this scope yet, and returns its index.
+func (s *scope) add(name string, mutable bool) int {
+	if i := s.index(name); i >= 0 {
+		s.mutable[i] = true
+		return i
+	}
+	s.names = append(s.names, name)
+	s.mutable = append(s.mutable, mutable || s.assigned[name])
+	return len(s.names) - 1
+}
+
+// defineAll adds slots for the internal defines of the body.
+func (s *scope) defineAll(body []*node.Node) {
+	var defined []string
+	for _, n := range body {
+		defined = definedNames(n, defined)
+	}
+	for _, name := range defined {
+		s.add(name, true)
+	}
+}
+
+// index returns the index of the slot of the name in this scope, or -1 if not found.
+func (s *scope) index(name string) int {
+	for i := len(s.names) - 1; i >= 0; i-- {
+		if s.names[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolve resolves the variable to the depth and index of its slot.
+// Returns false if not found in any scope, i.e. the variable is global.
+func (s *scope) resolve(name string) (depth int, index int, mutable bool, ok bool) {
+	for cur := s; cur != nil; cur = cur.upper {
+		if i := cur.index(name); i >= 0 {
+			return depth, i, cur.mutable[i], true
+		}
+		depth++
+	}
+	return 0, 0, false, false
+}
+
+// slots returns new slots for an environment of this scope.
+func (s *scope) slots() []object.Object {
+	return make([]object.Object, len(s.names))
+}
+
+// scopeOf returns the scope of the environment not known at compile time, such as the environments of code
+// evaluated dynamically. All slots are treated as mutable.
+func scopeOf(env *object.Env) *scope {
+	if env.IsGlobal() {
+		return nil
+	}
+	s := &scope{names: env.Names(), assigned: make(map[string]bool), upper: scopeOf(env.Upper())}
+	s.mutable = make([]bool, len(s.names))
+	for i := range s.mutable {
+		s.mutable[i] = true
+	}
+	return s
+}
+
+// definedNames appends the names defined by internal defines in n, which are evaluated in the same environment.
+// Forms making new environments are not searched, as they define the names in their own environments.
+func definedNames(n *node.Node, names []string) []string {
+	if n.Type != node.Branch || len(n.Children) == 0 {
+		return names
+	}
+	if head := n.Children[0]; head.Type == node.Keyword {
+		switch head.Str {
+		case "lambda", "let", "let*", "quote", "define-syntax", "select":
+			return names
+		case "define":
+			if len(n.Children) == 1 {
+				return names
+			}
+			if target := n.Children[1]; target.Type == node.Identifier {
+				names = append(names, target.Str)
+			} else if target.Type == node.Branch && len(target.Children) > 0 && target.Children[0].Type == node.Identifier {
+				// function definition, whose body is a lambda
+				return append(names, target.Children[0].Str)
+			}
+		}
+	}
+	for _, child := range n.Children {
+		names = definedNames(child, names)
+	}
+	return names
+}
+
+// assignedNames adds the names assigned by set! anywhere in n to names.
+func assignedNames(n *node.Node, names map[string]bool) {
+	if n.Type != node.Branch || len(n.Children) == 0 {
+		return
+	}
+	if head := n.Children[0]; head.Type == node.Keyword {
+		switch head.Str {
+		case "quote", "define-syntax":
+			return
+		case "set!":
+			if len(n.Children) > 1 && n.Children[1].Type == node.Identifier {
+				names[n.Children[1].Str] = true
+			}
+		}
+	}
+	for _, child := range n.Children {
+		assignedNames(child, names)
+	}
+}
@@ -0,0 +1,592 @@
+package lisp
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/macro"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"io"
+	"sort"
+)
+
+const (
+	imageMagic   = "lisp-interpreter image"
+	imageVersion = 1
+	// noRef is the reference to no object, i.e. unbound slots, or to the global environment
+	noRef = -1
+)
+
+// image is the serialized state of the global environment of an interpreter.
+// Objects and environments are stored in tables and referred to by index, so that sharing and cycles are preserved.
+type image struct {
+	Magic   string
+	Version int
+	Objects []imageObject
+	Envs    []imageEnv
+	Globals []imageGlobal
+	// Macros are the define-syntax forms of the macros, in the order of definition
+	Macros []*imageNode
+}
+
+type imageObject struct {
+	Type    object_type.T
+	Num     float64
+	Inexact bool
+	Bool    bool
+	// Str is the string of strings, symbols, chars and errors
+	Str      string
+	Interned bool
+	// Refs are the car and cdr of pairs, or the value of forced promises
+	Refs []int
+	// Builtin is the name of the builtin, if the object is a builtin of the interpreter
+	Builtin string
+	// Lambda is the lambda form of closures, and Name is the name of the function
+	Lambda *imageNode
+	Name   string
+	// Env is the environment of closures
+	Env int
+}
+
+// imageEnv is a local environment. The global environment is noRef.
+type imageEnv struct {
+	Names []string
+	Slots []int
+	Upper int
+}
+
+type imageGlobal struct {
+	Name  string
+	Value int
+}
+
+type imageNode struct {
+	Type     node.Type
+	Children []*imageNode
+	Str      string
+	Num      float64
+	Inexact  bool
+	B        bool
+	Ch       rune
+	Pos      token.Pos
+}
+
+func toImageNode(n *node.Node) *imageNode {
+	in := &imageNode{Type: n.Type, Str: n.Str, Num: n.Num, Inexact: n.Inexact, B: n.B, Ch: n.Ch, Pos: n.Pos}
+	for _, child := range n.Children {
+		in.Children = append(in.Children, toImageNode(child))
+	}
+	return in
+}
+
+func fromImageNode(in *imageNode) *node.Node {
+	n := &node.Node{Type: in.Type, Str: in.Str, Num: in.Num, Inexact: in.Inexact, B: in.B, Ch: in.Ch, Pos: in.Pos}
+	if n.Type == node.Identifier {
+		n.Sym = node.Intern(n.Str)
+	}
+	for _, child := range in.Children {
+		n.Children = append(n.Children, fromImageNode(child))
+	}
+	return n
+}
+
+// errUnforcedPromise is the error writing a promise not forced yet, e.g. the rest of a stream.
+var errUnforcedPromise = errors.New("cannot write unforced promise")
+
+// Snapshot writes the image of the global environment to w, to be restored by Restore.
+// The image includes global variables other than the builtins, functions defined by lambda with their environments,
+// macros and data, preserving sharing and cycles.
+// Builtins, including the functions of the prelude, are written by name, and globals still holding their initial
+// values are left out, since they are made again by the interpreter restoring the image.
+// Globals holding unforced promises, such as streams made by stream-cons, are skipped, and their names are returned.
+// Other objects tied to the host such as ports and threads cannot be written.
+// Libraries defined by define-library are not included.
+func (i *Interpreter) Snapshot(w io.Writer) (skipped []string, err error) {
+	sw := &snapshotWriter{
+		i:        i,
+		builtins: make(map[object.Object]string),
+		objects:  make(map[object.Object]int),
+		envs:     make(map[*object.Env]int),
+		img:      &image{Magic: imageMagic, Version: imageVersion},
+	}
+	for name, v := range i.builtins {
+		sw.builtins[v] = name
+	}
+
+	globals := i.globalEnv.Globals()
+	names := make([]string, 0, len(globals))
+	for name, v := range globals {
+		if builtin, ok := i.builtins[name]; ok && builtin == v {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := sw.mark()
+		ref, err := sw.value(globals[name])
+		if errors.Is(err, errUnforcedPromise) {
+			sw.rollback(m)
+			skipped = append(skipped, name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("snapshot: %v: %w", name, err)
+		}
+		sw.img.Globals = append(sw.img.Globals, imageGlobal{Name: name, Value: ref})
+	}
+	for _, m := range i.globalEnv.Macros() {
+		sw.img.Macros = append(sw.img.Macros, toImageNode(m.Node()))
+	}
+
+	if err := gob.NewEncoder(w).Encode(sw.img); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	return skipped, nil
+}
+
+type snapshotWriter struct {
+	i *Interpreter
+	// builtins are the names of builtins other than closures
+	builtins map[object.Object]string
+	// objects are the indices of objects with identity
+	objects map[object.Object]int
+	envs    map[*object.Env]int
+	img     *image
+	// pending are the references to objects to be added, so that deep structures are added without recursion
+	pending []pendingRef
+}
+
+// pendingRef is a reference in the table to be set to the index of the object, once the object is added.
+type pendingRef struct {
+	o   object.Object
+	ref *int
+}
+
+// snapshotMark is the size of the tables at a point, to remove the objects and environments added after it.
+type snapshotMark struct {
+	objects, envs int
+}
+
+// mark returns the current size of the tables.
+func (w *snapshotWriter) mark() snapshotMark {
+	return snapshotMark{objects: len(w.img.Objects), envs: len(w.img.Envs)}
+}
+
+// rollback removes the objects and environments added after m, which may refer to objects not written.
+func (w *snapshotWriter) rollback(m snapshotMark) {
+	w.img.Objects = w.img.Objects[:m.objects]
+	w.img.Envs = w.img.Envs[:m.envs]
+	for o, ref := range w.objects {
+		if ref >= m.objects {
+			delete(w.objects, o)
+		}
+	}
+	for e, ref := range w.envs {
+		if ref >= m.envs {
+			delete(w.envs, e)
+		}
+	}
+}
+
+// add appends the object to the table, and returns its index.
+func (w *snapshotWriter) add(o imageObject) int {
+	w.img.Objects = append(w.img.Objects, o)
+	return len(w.img.Objects) - 1
+}
+
+// later adds o to the table after the current object, setting ref to its index.
+func (w *snapshotWriter) later(o object.Object, ref *int) {
+	w.pending = append(w.pending, pendingRef{o: o, ref: ref})
+}
+
+// value adds o and the objects reachable from it to the table, and returns the index of o.
+func (w *snapshotWriter) value(o object.Object) (int, error) {
+	ref, err := w.object(o)
+	if err != nil {
+		return noRef, err
+	}
+	for len(w.pending) > 0 {
+		p := w.pending[len(w.pending)-1]
+		w.pending = w.pending[:len(w.pending)-1]
+		if *p.ref, err = w.object(p.o); err != nil {
+			w.pending = w.pending[:0]
+			return noRef, err
+		}
+	}
+	return ref, nil
+}
+
+// object adds o to the table if not added yet, and returns its index.
+// The objects referred to by o are added later by value.
+func (w *snapshotWriter) object(o object.Object) (int, error) {
+	if o == nil {
+		return noRef, nil
+	}
+	if name, ok := w.builtins[o]; ok {
+		return w.add(imageObject{Type: o.Type(), Builtin: name}), nil
+	}
+	if ref, ok := w.objects[o]; ok {
+		return ref, nil
+	}
+
+	switch o.Type() {
+	case object_type.Number:
+		return w.add(imageObject{Type: o.Type(), Num: o.Number(), Inexact: !object.IsExact(o)}), nil
+	case object_type.Boolean:
+		return w.add(imageObject{Type: o.Type(), Bool: o.Bool()}), nil
+	case object_type.Null, object_type.Void, object_type.EOF:
+		return w.add(imageObject{Type: o.Type()}), nil
+	case object_type.Char, object_type.Err:
+		return w.add(imageObject{Type: o.Type(), Str: o.Str()}), nil
+	case object_type.Symbol:
+		sym := object.SymbolOf(o)
+		if sym.IsInterned() {
+			return w.add(imageObject{Type: o.Type(), Str: sym.Name(), Interned: true}), nil
+		}
+		ref := w.add(imageObject{Type: o.Type(), Str: sym.Name()})
+		w.objects[o] = ref
+		return ref, nil
+	case object_type.Str:
+		ref := w.add(imageObject{Type: o.Type(), Str: o.Str()})
+		w.objects[o] = ref
+		return ref, nil
+	case object_type.Cons:
+		// walk the cdr chain in a loop, so that long lists are added without recursion
+		ref := w.add(imageObject{Type: o.Type(), Refs: make([]int, 2)})
+		w.objects[o] = ref
+		for cur := ref; ; {
+			pair := o.Pair()
+			refs := w.img.Objects[cur].Refs
+			w.later(pair[0], &refs[0])
+			o = pair[1]
+			if o == nil || o.Type() != object_type.Cons {
+				w.later(o, &refs[1])
+				return ref, nil
+			}
+			if _, ok := w.builtins[o]; ok {
+				w.later(o, &refs[1])
+				return ref, nil
+			}
+			if next, ok := w.objects[o]; ok {
+				refs[1] = next
+				return ref, nil
+			}
+			cur = w.add(imageObject{Type: o.Type(), Refs: make([]int, 2)})
+			w.objects[o] = cur
+			refs[1] = cur
+		}
+	case object_type.StreamPair:
+		ref := w.add(imageObject{Type: o.Type(), Refs: make([]int, 2)})
+		w.objects[o] = ref
+		refs := w.img.Objects[ref].Refs
+		for k, p := range o.(*object.StreamPair).Promises() {
+			w.later(p, &refs[k])
+		}
+		return ref, nil
+	case object_type.Promise:
+		value, ok := object.ForcedValue(o)
+		if !ok {
+			return noRef, errUnforcedPromise
+		}
+		ref := w.add(imageObject{Type: o.Type(), Refs: make([]int, 1)})
+		w.objects[o] = ref
+		w.later(value, &w.img.Objects[ref].Refs[0])
+		return ref, nil
+	case object_type.Function:
+		var sig signature
+		var env *object.Env
+		switch c := o.(type) {
+		case *closure:
+			sig, env = c.signature, c.env
+		case *vmClosure:
+			sig, env = c.p.signature, c.env
+		default:
+			return noRef, errors.New("cannot write function which is not a builtin nor made by lambda")
+		}
+		ref := w.add(imageObject{Type: o.Type(), Lambda: toImageNode(sig.lambda), Name: sig.name})
+		w.objects[o] = ref
+		envRef, err := w.env(env)
+		if err != nil {
+			return noRef, err
+		}
+		w.img.Objects[ref].Env = envRef
+		return ref, nil
+	}
+	return noRef, fmt.Errorf("cannot write %v", o.Type())
+}
+
+// env adds the local environment to the table if not added yet, and returns its index.
+// Upper environments are added before the environment, and the values of its slots are added later by value.
+func (w *snapshotWriter) env(e *object.Env) (int, error) {
+	if e.IsGlobal() {
+		if e != w.i.globalEnv {
+			return noRef, errors.New("cannot write function defined in a library")
+		}
+		return noRef, nil
+	}
+	if ref, ok := w.envs[e]; ok {
+		return ref, nil
+	}
+	upper, err := w.env(e.Upper())
+	if err != nil {
+		return noRef, err
+	}
+	names := e.Names()
+	w.img.Envs = append(w.img.Envs, imageEnv{Names: names, Upper: upper})
+	ref := len(w.img.Envs) - 1
+	w.envs[e] = ref
+	slots := make([]int, len(names))
+	for idx := range names {
+		w.later(e.LoadSlot(0, idx), &slots[idx])
+	}
+	w.img.Envs[ref].Slots = slots
+	return ref, nil
+}
+
+// Restore reads the image written by Snapshot from r, and defines its global variables and macros
+// in the global environment of this interpreter.
+// Functions made by lambda are compiled again for the engine of this interpreter, and builtins are looked up by name.
+func (i *Interpreter) Restore(r io.Reader) error {
+	var img image
+	if err := gob.NewDecoder(r).Decode(&img); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	if img.Magic != imageMagic {
+		return errors.New("restore: not an image")
+	}
+	if img.Version != imageVersion {
+		return fmt.Errorf("restore: unsupported image version %v", img.Version)
+	}
+
+	rr := &restorer{i: i, img: &img, objects: make([]object.Object, len(img.Objects)), envs: make([]*object.Env, len(img.Envs))}
+	if err := rr.restore(); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	values := make([]object.Object, len(img.Globals))
+	for idx, g := range img.Globals {
+		v, err := rr.ref(g.Value)
+		if err != nil {
+			return fmt.Errorf("restore: %v: %w", g.Name, err)
+		}
+		if v == nil {
+			return fmt.Errorf("restore: %v: invalid object reference %v", g.Name, g.Value)
+		}
+		values[idx] = v
+	}
+	for idx, g := range img.Globals {
+		i.globalEnv.Define(g.Name, values[idx])
+	}
+	for _, in := range img.Macros {
+		m, err := macro.NewMacro(fromImageNode(in))
+		if err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+		i.globalEnv.DefineGlobalMacro(m)
+	}
+	return nil
+}
+
+type restorer struct {
+	i       *Interpreter
+	img     *image
+	objects []object.Object
+	envs    []*object.Env
+}
+
+// ref returns the object of the index, which must have been made.
+func (rr *restorer) ref(ref int) (object.Object, error) {
+	if ref == noRef {
+		return nil, nil
+	}
+	if ref < 0 || ref >= len(rr.objects) || rr.objects[ref] == nil {
+		return nil, fmt.Errorf("invalid object reference %v", ref)
+	}
+	return rr.objects[ref], nil
+}
+
+// restore makes the objects and environments of the image.
+// Pairs and environments are made empty first and filled at last, so that cycles through them can be restored.
+func (rr *restorer) restore() error {
+	for idx, o := range rr.img.Objects {
+		if o.Builtin != "" {
+			v, ok := rr.i.builtins[o.Builtin]
+			if !ok {
+				return fmt.Errorf("no builtin named %v", o.Builtin)
+			}
+			rr.objects[idx] = v
+			continue
+		}
+		switch o.Type {
+		case object_type.Number:
+			if o.Inexact {
+				rr.objects[idx] = object.NewInexactNumberObject(o.Num)
+			} else {
+				rr.objects[idx] = object.NewNumberObject(o.Num)
+			}
+		case object_type.Boolean:
+			rr.objects[idx] = object.NewBooleanObject(o.Bool)
+		case object_type.Null:
+			rr.objects[idx] = object.NullObj
+		case object_type.Void:
+			rr.objects[idx] = object.VoidObj
+		case object_type.EOF:
+			rr.objects[idx] = object.EOFObj
+		case object_type.Char:
+			r := []rune(o.Str)
+			if len(r) != 1 {
+				return fmt.Errorf("invalid char %q", o.Str)
+			}
+			rr.objects[idx] = object.NewCharObject(r[0])
+		case object_type.Err:
+			rr.objects[idx] = object.NewErrorObject(o.Str)
+		case object_type.Symbol:
+			if o.Interned {
+				rr.objects[idx] = object.NewSymbolObject(o.Str)
+			} else {
+				rr.objects[idx] = object.NewUninternedSymbolObject(o.Str)
+			}
+		case object_type.Str:
+			rr.objects[idx] = object.NewStringObject(o.Str)
+		case object_type.Cons:
+			if len(o.Refs) != 2 {
+				return errors.New("invalid pair")
+			}
+			rr.objects[idx] = object.NewConsObject(nil, nil)
+		case object_type.StreamPair:
+			if len(o.Refs) != 2 {
+				return errors.New("invalid stream pair")
+			}
+			rr.objects[idx] = object.NewStreamPairObject(nil, nil)
+		case object_type.Promise, object_type.Function:
+			// made after environments
+		default:
+			return fmt.Errorf("cannot restore %v", o.Type)
+		}
+	}
+
+	for idx, e := range rr.img.Envs {
+		upper := rr.i.globalEnv
+		if e.Upper != noRef {
+			if e.Upper < 0 || e.Upper >= idx {
+				return fmt.Errorf("invalid environment reference %v", e.Upper)
+			}
+			upper = rr.envs[e.Upper]
+		}
+		if len(e.Slots) != len(e.Names) {
+			return errors.New("invalid environment")
+		}
+		rr.envs[idx] = upper.NewEnv(e.Names, make([]object.Object, len(e.Names)))
+	}
+
+	for idx, o := range rr.img.Objects {
+		if o.Builtin == "" && o.Type == object_type.Function {
+			c, err := rr.closure(o)
+			if err != nil {
+				return err
+			}
+			rr.objects[idx] = c
+		}
+	}
+	for idx, o := range rr.img.Objects {
+		if o.Builtin == "" && o.Type == object_type.Promise {
+			if _, err := rr.promise(idx, make(map[int]bool)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for idx, o := range rr.img.Objects {
+		if o.Builtin == "" && o.Type == object_type.Cons {
+			pair := rr.objects[idx].Pair()
+			for k, ref := range o.Refs {
+				v, err := rr.ref(ref)
+				if err != nil {
+					return err
+				}
+				if v == nil {
+					return errors.New("invalid pair")
+				}
+				pair[k] = v
+			}
+		}
+		if o.Builtin == "" && o.Type == object_type.StreamPair {
+			promises := rr.objects[idx].(*object.StreamPair).Promises()
+			for k, ref := range o.Refs {
+				v, err := rr.ref(ref)
+				if err != nil {
+					return err
+				}
+				if v == nil || v.Type() != object_type.Promise {
+					return errors.New("invalid stream pair")
+				}
+				promises[k] = v
+			}
+		}
+	}
+	for idx, e := range rr.img.Envs {
+		for k, ref := range e.Slots {
+			v, err := rr.ref(ref)
+			if err != nil {
+				return err
+			}
+			if v != nil {
+				rr.envs[idx].StoreSlot(0, k, v)
+			}
+		}
+	}
+	return nil
+}
+
+// promise makes the forced promise of the index, making the promises it is forced to first.
+func (rr *restorer) promise(idx int, visiting map[int]bool) (object.Object, error) {
+	if rr.objects[idx] != nil {
+		return rr.objects[idx], nil
+	}
+	if visiting[idx] {
+		return nil, errors.New("promise forced to itself")
+	}
+	visiting[idx] = true
+	o := rr.img.Objects[idx]
+	if len(o.Refs) != 1 || o.Refs[0] < 0 || o.Refs[0] >= len(rr.objects) {
+		return nil, errors.New("invalid promise")
+	}
+	ref := o.Refs[0]
+	if next := rr.img.Objects[ref]; next.Builtin == "" && next.Type == object_type.Promise {
+		if _, err := rr.promise(ref, visiting); err != nil {
+			return nil, err
+		}
+	}
+	value, err := rr.ref(ref)
+	if err != nil {
+		return nil, err
+	}
+	rr.objects[idx] = object.NewForcedPromiseObject(value)
+	return rr.objects[idx], nil
+}
+
+// closure compiles the lambda of the closure again in the scope of its environment, and makes the function.
+func (rr *restorer) closure(o imageObject) (object.Object, error) {
+	if o.Lambda == nil {
+		return nil, errors.New("invalid function")
+	}
+	env := rr.i.globalEnv
+	if o.Env != noRef {
+		if o.Env < 0 || o.Env >= len(rr.envs) {
+			return nil, fmt.Errorf("invalid environment reference %v", o.Env)
+		}
+		env = rr.envs[o.Env]
+	}
+	lambda := fromImageNode(o.Lambda)
+	sig, err := newSignature(lambda, o.Name, scopeOf(env))
+	if err != nil {
+		return nil, err
+	}
+	if rr.i.engine == EngineVM {
+		return newVMClosure(compileProto(lambda.Children[2:], sig), env), nil
+	}
+	return newClosure(sig, compileBody(lambda.Children[2:], sig.s), env), nil
+}
@@ -0,0 +1,221 @@
+package lisp
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/lisp/printer"
+	"github.com/motoki317/lisp-interpreter/node"
+	"github.com/motoki317/lisp-interpreter/token"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	src := `(define (make-counter)
+  (let ((n 0))
+    (list (lambda () (set! n (+ n 1)) n) (lambda () n))))
+(define counter (make-counter))
+((car counter))
+(define shared (list 1 2))
+(define pair (cons shared shared))
+(define circular (list 'a 'b))
+(set-cdr! (cdr circular) circular)
+(define str "hello")
+(define strs (list str str))
+(define sym (gensym "tmp"))
+(define syms (list sym sym))
+(define p (delay (+ 1 2)))
+(force p)
+(define s (list->stream '(1 2)))
+(define first-of car)
+(define last-of last)
+(define (fact n) (if (= n 0) 1 (* n (fact (- n 1)))))
+(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))`
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"(list ((car counter)) ((cadr counter)))", "(2 2)"},
+		{"(list (eq? (car pair) (cdr pair)) (car pair))", "(#t (1 2))"},
+		{"circular", "#0=(a b . #0#)"},
+		{"(list (eq? (car strs) (cadr strs)) str)", `(#t "hello")`},
+		{"(list (eq? (car syms) (cadr syms)) (eq? sym (string->symbol (symbol->string sym))))", "(#t #f)"},
+		{"(force p)", "3"},
+		{"(list (stream-pair? s) (stream->list s))", "(#t (1 2))"},
+		{"(list (eq? first-of car) (first-of '(1 2)))", "(#t 1)"},
+		{"(fact 10)", "3628800"},
+		{"(let ((x 1) (y 2)) (swap! x y) (list x y))", "(2 1)"},
+		{"(list (eq? last-of last) (last-of '(1 2 3)))", "(#t 3)"},
+	}
+
+	engines := []Engine{EngineClosure, EngineVM}
+	for _, from := range engines {
+		i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0, WithEngine(from))
+		if _, err := i.EvalString(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		var img bytes.Buffer
+		skipped, err := i.Snapshot(&img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(skipped) != 0 {
+			t.Errorf("skipped %v, want none", skipped)
+		}
+		for _, to := range engines {
+			t.Run(from.String()+"/"+to.String(), func(t *testing.T) {
+				restored := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0,
+					WithEngine(to))
+				if err := restored.Restore(bytes.NewReader(img.Bytes())); err != nil {
+					t.Fatal(err)
+				}
+				for _, tt := range tests {
+					res, err := restored.EvalString(context.Background(), tt.expr)
+					if err != nil {
+						t.Errorf("%v: error = %v", tt.expr, err)
+						continue
+					}
+					if got := printer.Write(res); got != tt.want {
+						t.Errorf("%v = %v, want %v", tt.expr, got, tt.want)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSnapshotLargeList(t *testing.T) {
+	// make a Go stack overflow in recursion over the lists fail fast
+	defer debug.SetMaxStack(debug.SetMaxStack(16 << 20))
+
+	src := `(define xs (iota 200000))
+(define (nest n x) (if (= n 0) x (nest (- n 1) (list x))))
+(define (depth x n) (if (null? x) n (depth (car x) (+ n 1))))
+(define nested (nest 200000 '()))`
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if _, err := i.EvalString(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	if _, err := i.Snapshot(&img); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if err := restored.Restore(&img); err != nil {
+		t.Fatal(err)
+	}
+	res, err := restored.EvalString(context.Background(), "(list (length xs) (list-ref xs 199999) (depth nested 0))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := printer.Write(res), "(200000 199999 200000)"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSnapshotSkipped(t *testing.T) {
+	src := `(define p (delay 1))
+(define nat (stream-from 0))
+(define holder (list nat))
+(define shared holder)
+(define forced (list->stream '(1 2)))
+(define (next) (stream-car nat))`
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if _, err := i.EvalString(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	var img bytes.Buffer
+	skipped, err := i.Snapshot(&img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(skipped, " "), "holder nat p shared"; got != want {
+		t.Errorf("skipped %v, want %v", got, want)
+	}
+
+	restored := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if err := restored.Restore(&img); err != nil {
+		t.Fatal(err)
+	}
+	res, err := restored.EvalString(context.Background(), "(define nat (stream-from 5)) (list (stream->list forced) (next))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := printer.Write(res), "((1 2) 5)"; got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSnapshotPrelude(t *testing.T) {
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	var buf bytes.Buffer
+	if _, err := i.Snapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var img image
+	if err := gob.NewDecoder(&buf).Decode(&img); err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Globals) != 0 || len(img.Objects) != 0 {
+		t.Errorf("image has %v globals and %v objects, want none", len(img.Globals), len(img.Objects))
+	}
+}
+
+func TestSnapshotErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "port",
+			src:     "(define port (open-input-string \"\"))",
+			wantErr: "snapshot: port: cannot write port",
+		},
+		{
+			name:    "library function",
+			src:     "(define-library (lib) (export f) (begin (define (f) 1)))\n(import (lib))",
+			wantErr: "snapshot: f: cannot write function defined in a library",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+			if _, err := i.EvalString(context.Background(), tt.src); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := i.Snapshot(&bytes.Buffer{}); err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Snapshot() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	i := NewInterpreter(node.NewParser(token.NewTokenizer(strings.NewReader(""))), &bytes.Buffer{}, false, 0)
+	if err := i.Restore(strings.NewReader("not an image")); err == nil {
+		t.Error("Restore() error = nil, want error")
+	}
+
+	for _, ref := range []int{1, -2, noRef} {
+		var img bytes.Buffer
+		corrupted := image{
+			Magic:   imageMagic,
+			Version: imageVersion,
+			Objects: []imageObject{{Type: object_type.Number, Num: 1}},
+			Globals: []imageGlobal{{Name: "x", Value: 0}, {Name: "y", Value: ref}},
+		}
+		if err := gob.NewEncoder(&img).Encode(corrupted); err != nil {
+			t.Fatal(err)
+		}
+		wantErr := fmt.Sprintf("restore: y: invalid object reference %v", ref)
+		if err := i.Restore(&img); err == nil || err.Error() != wantErr {
+			t.Errorf("Restore() error = %v, want %v", err, wantErr)
+		}
+		if _, ok := i.globalEnv.Globals()["x"]; ok {
+			t.Error("Restore() defined globals of a corrupted image")
+		}
+	}
+}
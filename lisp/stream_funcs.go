@@ -0,0 +1,166 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"math"
+)
+
+// Streams are represented as either an empty list (stream-null),
+// or a stream pair of a promise of the value and a promise of the rest of the stream (created by stream-cons).
+
+func init() {
+	defaultEnv["stream-null"] = object.NullObj
+	defaultEnv["stream-null?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Null)
+		}))
+	defaultEnv["stream-pair?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(isStreamPair(objects[0]))
+		}))
+	defaultEnv["stream-car"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			s := objects[0]
+			if !isStreamPair(s) {
+				return object.NewErrorObject(fmt.Sprintf("stream-car: expected stream pair, but got %v", s))
+			}
+			return force(ctx, streamPromises(s)[0])
+		}))
+	defaultEnv["stream-cdr"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			s := objects[0]
+			if !isStreamPair(s) {
+				return object.NewErrorObject(fmt.Sprintf("stream-cdr: expected stream pair, but got %v", s))
+			}
+			return force(ctx, streamPromises(s)[1])
+		}))
+	defaultEnv["stream-take"] = object.NewWrappedFunctionObject(
+		makeBinary(func(_ context.Context, objects []object.Object) object.Object {
+			n, s := objects[0], objects[1]
+			if n.Type() != object_type.Number || n.Number() < 0 || n.Number() != math.Trunc(n.Number()) {
+				return object.NewErrorObject(fmt.Sprintf("stream-take: expected 1st argument to be non-negative integer, but got %v", n))
+			}
+			return streamTake(int(n.Number()), s)
+		}))
+	defaultEnv["stream-filter"] = object.NewWrappedFunctionObject(
+		makeBinary(func(ctx context.Context, objects []object.Object) object.Object {
+			pred, s := objects[0], objects[1]
+			if pred.Type() != object_type.Function {
+				return object.NewErrorObject(fmt.Sprintf("stream-filter: expected 1st argument to be a function, but got %v", pred))
+			}
+			return streamFilter(ctx, pred, s)
+		}))
+	defaultEnv["stream->list"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (stream->list [n] stream)
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("stream->list: expected 1 or 2 arguments, but got %v", len(objects)))
+		}
+		limit := -1
+		if len(objects) == 2 {
+			n := objects[0]
+			if n.Type() != object_type.Number || n.Number() < 0 || n.Number() != math.Trunc(n.Number()) {
+				return object.NewErrorObject(fmt.Sprintf("stream->list: expected 1st argument to be non-negative integer, but got %v", n))
+			}
+			limit = int(n.Number())
+		}
+		s := objects[len(objects)-1]
+
+		elements := make([]object.Object, 0)
+		for limit != 0 && s.Type() != object_type.Null {
+			if !isStreamPair(s) {
+				return object.NewErrorObject(fmt.Sprintf("stream->list: expected stream, but got %v", s))
+			}
+			car := force(ctx, streamPromises(s)[0])
+			if car.Type() == object_type.Err {
+				return car
+			}
+			elements = append(elements, car)
+			s = force(ctx, streamPromises(s)[1])
+			if s.Type() == object_type.Err {
+				return s
+			}
+			limit--
+		}
+		return allocateList(ctx, elements)
+	})
+	defaultEnv["list->stream"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			lst := objects[0]
+			if !lst.IsList() {
+				return object.NewErrorObject(fmt.Sprintf("list->stream: expected list, but got %v", lst))
+			}
+			elements := lst.ListElements()
+			var s object.Object = object.NullObj
+			for i := len(elements) - 1; i >= 0; i-- {
+				s = object.NewStreamPairObject(object.NewForcedPromiseObject(elements[i]), object.NewForcedPromiseObject(s))
+			}
+			return s
+		}))
+}
+
+// isStreamPair returns true if the given object is a stream pair created by stream-cons.
+func isStreamPair(s object.Object) bool {
+	return s.Type() == object_type.StreamPair
+}
+
+// streamPromises returns the promises of the value and the rest of the given stream pair.
+func streamPromises(s object.Object) *[2]object.Object {
+	return s.(*object.StreamPair).Promises()
+}
+
+// streamTake lazily returns a stream of the first n elements of the given stream.
+func streamTake(n int, s object.Object) object.Object {
+	if n == 0 || s.Type() == object_type.Null {
+		return object.NullObj
+	}
+	if !isStreamPair(s) {
+		return object.NewErrorObject(fmt.Sprintf("stream-take: expected stream, but got %v", s))
+	}
+	return object.NewStreamPairObject(streamPromises(s)[0], object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+		rest := force(ctx, streamPromises(s)[1])
+		if rest.Type() == object_type.Err {
+			return rest
+		}
+		return streamTake(n-1, rest)
+	}))
+}
+
+// streamFilter lazily returns a stream of elements satisfying pred in the given stream.
+func streamFilter(ctx context.Context, pred object.Object, s object.Object) object.Object {
+	for {
+		if s.Type() == object_type.Null {
+			return object.NullObj
+		}
+		if !isStreamPair(s) {
+			return object.NewErrorObject(fmt.Sprintf("stream-filter: expected stream, but got %v", s))
+		}
+
+		car := streamPromises(s)[0]
+		value := force(ctx, car)
+		if value.Type() == object_type.Err {
+			return value
+		}
+		res := callWithTailOptimization(ctx, pred.F, []object.Object{value})
+		if res.Type() == object_type.Err {
+			return res
+		}
+		if res.IsTruthy() {
+			rest := streamPromises(s)[1]
+			return object.NewStreamPairObject(car, object.NewGoPromiseObject(func(ctx context.Context) object.Object {
+				rest := force(ctx, rest)
+				if rest.Type() == object_type.Err {
+					return rest
+				}
+				return streamFilter(ctx, pred, rest)
+			}))
+		}
+
+		s = force(ctx, streamPromises(s)[1])
+		if s.Type() == object_type.Err {
+			return s
+		}
+	}
+}
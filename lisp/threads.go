@@ -0,0 +1,399 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"runtime"
+	"time"
+)
+
+type threadKey struct{}
+
+// formBaseKey is the key of the context of ReadLoop before the timeout of each form is applied.
+type formBaseKey struct{}
+
+// withFormTimeout returns a new context of the evaluation of a form read by ReadLoop, timing out after timeout.
+func withFormTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithValue(ctx, formBaseKey{}, ctx), timeout)
+}
+
+// detachedContext has the values of the embedded context, but is done only when base is done.
+type detachedContext struct {
+	context.Context
+	base context.Context
+}
+
+func (c detachedContext) Deadline() (time.Time, bool) {
+	return c.base.Deadline()
+}
+
+func (c detachedContext) Done() <-chan struct{} {
+	return c.base.Done()
+}
+
+func (c detachedContext) Err() error {
+	return c.base.Err()
+}
+
+// withoutFormTimeout returns a context with the values of ctx, such as the resource limits, detached from the timeout
+// of the form being evaluated by ReadLoop if any, so that threads started by the form outlive its evaluation
+// until ReadLoop returns.
+func withoutFormTimeout(ctx context.Context) context.Context {
+	if base, ok := ctx.Value(formBaseKey{}).(context.Context); ok {
+		return detachedContext{Context: ctx, base: base}
+	}
+	return ctx
+}
+
+// defineThreadFuncs defines the thread, mutex and condition variable functions of SRFI-18,
+// and the channel functions and go to the given frame.
+// Threads are started with the context of the evaluation starting them, so they are terminated when
+// the evaluation is cancelled, and share its resource limits. Threads started by a form read by ReadLoop are not
+// terminated by the timeout of the form, so that later forms can join them, but are terminated when ReadLoop returns.
+// Timeouts are given in seconds relative to the time of the call, or #f for no timeout.
+func (i *Interpreter) defineThreadFuncs(global object.Frame) {
+	for k, v := range channelEnv {
+		global[k] = v
+	}
+	primordial := object.NewPrimordialThreadObject()
+	current := func(ctx context.Context) *object.Thread {
+		if t, ok := ctx.Value(threadKey{}).(*object.Thread); ok {
+			return t
+		}
+		return primordial
+	}
+
+	global["current-thread"] = object.NewWrappedFunctionObject(
+		makeNullary(func(ctx context.Context, _ []object.Object) object.Object {
+			return current(ctx)
+		}))
+	global["thread?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Thread)
+		}))
+	global["make-thread"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (make-thread thunk [name])
+		if len(objects) != 1 && len(objects) != 2 {
+			return object.NewErrorObject(fmt.Sprintf("make-thread: expected 1 or 2 arguments, but got %v", len(objects)))
+		}
+		if objects[0].Type() != object_type.Function {
+			return object.NewErrorObject(fmt.Sprintf("make-thread: expected a function, but got %v", objects[0]))
+		}
+		return object.NewThreadObject(objects[0], nameArg(objects, 1))
+	})
+	global["thread-name"] = object.NewWrappedFunctionObject(
+		makeUnary(makeThread("thread-name", func(t *object.Thread, _ []object.Object) object.Object {
+			return nameOf(t.Name())
+		})))
+	global["thread-specific"] = object.NewWrappedFunctionObject(
+		makeUnary(makeThread("thread-specific", func(t *object.Thread, _ []object.Object) object.Object {
+			return t.Specific()
+		})))
+	global["thread-specific-set!"] = object.NewWrappedFunctionObject(
+		makeBinary(makeThread("thread-specific-set!", func(t *object.Thread, objects []object.Object) object.Object {
+			t.SetSpecific(objects[1])
+			return object.VoidObj
+		})))
+	global["thread-start!"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			t, errObj := threadArg("thread-start!", 0, objects[0])
+			if errObj != nil {
+				return errObj
+			}
+			if err := startThread(ctx, t, []object.Object{}); err != nil {
+				return object.NewErrorObject(fmt.Sprintf("thread-start!: %v", err))
+			}
+			return t
+		}))
+	global["go"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (go f arg ...)
+		if len(objects) == 0 {
+			return object.NewErrorObject("go: expected at least 1 argument, but got 0")
+		}
+		if objects[0].Type() != object_type.Function {
+			return object.NewErrorObject(fmt.Sprintf("go: expected a function, but got %v", objects[0]))
+		}
+		t := object.NewThreadObject(objects[0], nil)
+		if err := startThread(ctx, t, objects[1:]); err != nil {
+			return object.NewErrorObject(fmt.Sprintf("go: %v", err))
+		}
+		return t
+	})
+	global["thread-yield!"] = object.NewWrappedFunctionObject(
+		makeNullary(func(_ context.Context, _ []object.Object) object.Object {
+			runtime.Gosched()
+			return object.VoidObj
+		}))
+	global["thread-sleep!"] = object.NewWrappedFunctionObject(
+		makeUnary(func(ctx context.Context, objects []object.Object) object.Object {
+			timeout, errObj := timeoutArg("thread-sleep!", objects, 0)
+			if errObj != nil {
+				return errObj
+			}
+			if timeout < 0 {
+				return object.NewErrorObject("thread-sleep!: expected timeout, but got #f")
+			}
+			select {
+			case <-time.After(timeout):
+				return object.VoidObj
+			case <-ctx.Done():
+				return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", ctx.Err()))
+			}
+		}))
+	global["thread-terminate!"] = object.NewWrappedFunctionObject(
+		makeUnary(makeThread("thread-terminate!", func(t *object.Thread, _ []object.Object) object.Object {
+			if t.IsPrimordial() {
+				return object.NewErrorObject("thread-terminate!: cannot terminate the primordial thread")
+			}
+			t.Terminate()
+			return object.VoidObj
+		})))
+	global["thread-join!"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (thread-join! thread [timeout [timeout-val]])
+		if len(objects) == 0 || len(objects) > 3 {
+			return object.NewErrorObject(fmt.Sprintf("thread-join!: expected 1 to 3 arguments, but got %v", len(objects)))
+		}
+		t, errObj := threadArg("thread-join!", 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		if t == current(ctx) {
+			return object.NewErrorObject("thread-join!: cannot join the current thread")
+		}
+		timeout, errObj := timeoutArg("thread-join!", objects, 1)
+		if errObj != nil {
+			return errObj
+		}
+		res, err := t.Join(ctx, timeout)
+		if err == object.ErrTimeout && len(objects) == 3 {
+			return objects[2]
+		}
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("thread-join!: %v", err))
+		}
+		if res.Type() == object_type.Err {
+			return object.NewErrorObject(fmt.Sprintf("thread-join!: %v terminated with error: %v", t, res.Str()))
+		}
+		return res
+	})
+
+	global["mutex?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.Mutex)
+		}))
+	global["make-mutex"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (make-mutex [name])
+		if len(objects) > 1 {
+			return object.NewErrorObject(fmt.Sprintf("make-mutex: expected 0 or 1 arguments, but got %v", len(objects)))
+		}
+		return object.NewMutexObject(nameArg(objects, 0))
+	})
+	global["mutex-name"] = object.NewWrappedFunctionObject(
+		makeUnary(makeMutex("mutex-name", func(m *object.Mutex, _ []object.Object) object.Object {
+			return nameOf(m.Name())
+		})))
+	global["mutex-specific"] = object.NewWrappedFunctionObject(
+		makeUnary(makeMutex("mutex-specific", func(m *object.Mutex, _ []object.Object) object.Object {
+			return m.Specific()
+		})))
+	global["mutex-specific-set!"] = object.NewWrappedFunctionObject(
+		makeBinary(makeMutex("mutex-specific-set!", func(m *object.Mutex, objects []object.Object) object.Object {
+			m.SetSpecific(objects[1])
+			return object.VoidObj
+		})))
+	global["mutex-state"] = object.NewWrappedFunctionObject(
+		makeUnary(makeMutex("mutex-state", func(m *object.Mutex, _ []object.Object) object.Object {
+			return m.State()
+		})))
+	global["mutex-lock!"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (mutex-lock! mutex [timeout [thread]])
+		if len(objects) == 0 || len(objects) > 3 {
+			return object.NewErrorObject(fmt.Sprintf("mutex-lock!: expected 1 to 3 arguments, but got %v", len(objects)))
+		}
+		m, errObj := mutexArg("mutex-lock!", 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		timeout, errObj := timeoutArg("mutex-lock!", objects, 1)
+		if errObj != nil {
+			return errObj
+		}
+		var owner object.Object = current(ctx)
+		if len(objects) == 3 {
+			if !objects[2].IsTruthy() {
+				// not owned by any thread
+				owner = nil
+			} else if owner, errObj = threadArg("mutex-lock!", 2, objects[2]); errObj != nil {
+				return errObj
+			}
+		}
+		ok, err := m.Lock(ctx, timeout, owner)
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", err))
+		}
+		return object.NewBooleanObject(ok)
+	})
+	global["mutex-unlock!"] = object.NewWrappedFunctionObject(func(ctx context.Context, objects []object.Object) object.Object {
+		// (mutex-unlock! mutex [condition-variable [timeout]])
+		if len(objects) == 0 || len(objects) > 3 {
+			return object.NewErrorObject(fmt.Sprintf("mutex-unlock!: expected 1 to 3 arguments, but got %v", len(objects)))
+		}
+		m, errObj := mutexArg("mutex-unlock!", 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		if len(objects) == 1 {
+			if err := m.Unlock(); err != nil {
+				return object.NewErrorObject(fmt.Sprintf("mutex-unlock!: %v", err))
+			}
+			return object.NewBooleanObject(true)
+		}
+		c, errObj := conditionVariableArg("mutex-unlock!", 1, objects[1])
+		if errObj != nil {
+			return errObj
+		}
+		timeout, errObj := timeoutArg("mutex-unlock!", objects, 2)
+		if errObj != nil {
+			return errObj
+		}
+		ok, err := c.Wait(ctx, m, timeout)
+		if err == object.ErrMutexNotLocked {
+			return object.NewErrorObject(fmt.Sprintf("mutex-unlock!: %v", err))
+		}
+		if err != nil {
+			return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", err))
+		}
+		return object.NewBooleanObject(ok)
+	})
+
+	global["condition-variable?"] = object.NewWrappedFunctionObject(
+		makeUnary(func(_ context.Context, objects []object.Object) object.Object {
+			return object.NewBooleanObject(objects[0].Type() == object_type.ConditionVariable)
+		}))
+	global["make-condition-variable"] = object.NewWrappedFunctionObject(func(_ context.Context, objects []object.Object) object.Object {
+		// (make-condition-variable [name])
+		if len(objects) > 1 {
+			return object.NewErrorObject(fmt.Sprintf("make-condition-variable: expected 0 or 1 arguments, but got %v", len(objects)))
+		}
+		return object.NewConditionVariableObject(nameArg(objects, 0))
+	})
+	global["condition-variable-name"] = object.NewWrappedFunctionObject(
+		makeUnary(makeConditionVariable("condition-variable-name", func(c *object.ConditionVariable, _ []object.Object) object.Object {
+			return nameOf(c.Name())
+		})))
+	global["condition-variable-specific"] = object.NewWrappedFunctionObject(
+		makeUnary(makeConditionVariable("condition-variable-specific", func(c *object.ConditionVariable, _ []object.Object) object.Object {
+			return c.Specific()
+		})))
+	global["condition-variable-specific-set!"] = object.NewWrappedFunctionObject(
+		makeBinary(makeConditionVariable("condition-variable-specific-set!", func(c *object.ConditionVariable, objects []object.Object) object.Object {
+			c.SetSpecific(objects[1])
+			return object.VoidObj
+		})))
+	global["condition-variable-signal!"] = object.NewWrappedFunctionObject(
+		makeUnary(makeConditionVariable("condition-variable-signal!", func(c *object.ConditionVariable, _ []object.Object) object.Object {
+			c.Signal()
+			return object.VoidObj
+		})))
+	global["condition-variable-broadcast!"] = object.NewWrappedFunctionObject(
+		makeUnary(makeConditionVariable("condition-variable-broadcast!", func(c *object.ConditionVariable, _ []object.Object) object.Object {
+			c.Broadcast()
+			return object.VoidObj
+		})))
+}
+
+func threadArg(name string, i int, o object.Object) (*object.Thread, object.Object) {
+	t, ok := o.(*object.Thread)
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be thread, but got %v", name, i, o))
+	}
+	return t, nil
+}
+
+func mutexArg(name string, i int, o object.Object) (*object.Mutex, object.Object) {
+	m, ok := o.(*object.Mutex)
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be mutex, but got %v", name, i, o))
+	}
+	return m, nil
+}
+
+func conditionVariableArg(name string, i int, o object.Object) (*object.ConditionVariable, object.Object) {
+	c, ok := o.(*object.ConditionVariable)
+	if !ok {
+		return nil, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be condition variable, but got %v", name, i, o))
+	}
+	return c, nil
+}
+
+func makeThread(name string, next func(t *object.Thread, objects []object.Object) object.Object) generalFunc {
+	return func(_ context.Context, objects []object.Object) object.Object {
+		t, errObj := threadArg(name, 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		return next(t, objects)
+	}
+}
+
+func makeMutex(name string, next func(m *object.Mutex, objects []object.Object) object.Object) generalFunc {
+	return func(_ context.Context, objects []object.Object) object.Object {
+		m, errObj := mutexArg(name, 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		return next(m, objects)
+	}
+}
+
+func makeConditionVariable(name string, next func(c *object.ConditionVariable, objects []object.Object) object.Object) generalFunc {
+	return func(_ context.Context, objects []object.Object) object.Object {
+		c, errObj := conditionVariableArg(name, 0, objects[0])
+		if errObj != nil {
+			return errObj
+		}
+		return next(c, objects)
+	}
+}
+
+// startThread starts the thread calling its function with the arguments, in the context of the given evaluation.
+func startThread(ctx context.Context, t *object.Thread, args []object.Object) error {
+	return t.Start(forkProfileTrack(forkEvalState(withoutFormTimeout(ctx))), func(ctx context.Context, f object.Object) (ret object.Object) {
+		defer func() {
+			if r := recover(); r != nil {
+				ret = object.NewErrorObject(fmt.Sprintf("internal error in %v: %v", t, r))
+			}
+		}()
+		return callWithTailOptimization(context.WithValue(ctx, threadKey{}, t), f.F, args)
+	})
+}
+
+// timeoutArg returns the optional timeout in seconds at objects[i], or a negative duration if not given or #f.
+func timeoutArg(name string, objects []object.Object, i int) (time.Duration, object.Object) {
+	if i >= len(objects) || !objects[i].IsTruthy() {
+		return -1, nil
+	}
+	o := objects[i]
+	if o.Type() != object_type.Number || o.Number() < 0 {
+		return 0, object.NewErrorObject(fmt.Sprintf("%v: expected %v-th argument to be non-negative number of seconds, but got %v", name, i, o))
+	}
+	return time.Duration(o.Number() * float64(time.Second)), nil
+}
+
+// nameArg returns the optional name at objects[i], or nil if not given.
+func nameArg(objects []object.Object, i int) object.Object {
+	if i < len(objects) {
+		return objects[i]
+	}
+	return nil
+}
+
+// nameOf returns the name, or the void object if not named.
+func nameOf(name object.Object) object.Object {
+	if name == nil {
+		return object.VoidObj
+	}
+	return name
+}
@@ -0,0 +1,736 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"github.com/motoki317/lisp-interpreter/lisp/object"
+	"github.com/motoki317/lisp-interpreter/lisp/object/object_type"
+	"github.com/motoki317/lisp-interpreter/node"
+)
+
+// op is the operation of an instruction.
+type op uint8
+
+const (
+	// opConst pushes the constant at the operand.
+	opConst op = iota
+	// opGlobal pushes the global variable of the name at the operand.
+	opGlobal
+	// opLocal pushes the local variable of the slot at the operand, never assigned after the environment is made.
+	opLocal
+	// opLoadLocal pushes the local variable of the slot at the operand, assigned after the environment is made.
+	opLoadLocal
+	// opDefineGlobal pops a value, defines the global variable of the name at the operand, and pushes void.
+	opDefineGlobal
+	// opDefineLocal pops a value, stores it to the slot at the operand, and pushes void.
+	opDefineLocal
+	// opSetGlobal pops a value, sets the global variable of the name at the operand, and pushes void or an error.
+	opSetGlobal
+	// opSetLocal pops a value, sets the slot at the operand, and pushes void or an error.
+	opSetLocal
+	// opPop pops a value.
+	opPop
+	// opJump jumps to the operand.
+	opJump
+	// opJumpIfFalse pops a value, and jumps to the operand if the value is false.
+	opJumpIfFalse
+	// opJumpIfFalseOrPop jumps to the operand if the top value is false, and pops it otherwise.
+	opJumpIfFalseOrPop
+	// opJumpIfTrueOrPop jumps to the operand if the top value is true, and pops it otherwise.
+	opJumpIfTrueOrPop
+	// opClosure pushes a new closure of the prototype at the operand.
+	opClosure
+	// opEnter makes a new environment of the scope at the operand, binding the values popped.
+	opEnter
+	// opLeave restores the environment before opEnter.
+	opLeave
+	// opCall calls a function with the number of arguments at the operand, and pushes the result.
+	// The function and the arguments are popped, and the next word is the index of the node of the call.
+	opCall
+	// opTailCall is like opCall, but returns the result of the call, reusing the frame for closures.
+	opTailCall
+	// opReturn pops a value and returns it from the current frame.
+	opReturn
+	// opCode evaluates the code at the operand compiled by compile, and pushes the result.
+	// Forms not compiled into bytecode, such as parameterize and select, are evaluated this way.
+	opCode
+)
+
+// instr is an instruction of the bytecode, with the operation in the lowest 8 bits and the operand in the rest.
+// Slots are encoded as the depth in the upper 8 bits and the index in the lower 16 bits of the operand.
+type instr uint32
+
+const (
+	maxOperand = 1<<24 - 1
+	maxDepth   = 1<<8 - 1
+	maxIndex   = 1<<16 - 1
+)
+
+func (i instr) op() op {
+	return op(i & 0xff)
+}
+
+func (i instr) operand() int {
+	return int(i >> 8)
+}
+
+func slotOperand(depth, index int) int {
+	return depth<<16 | index
+}
+
+func fromSlotOperand(operand int) (depth, index int) {
+	return operand >> 16, operand & maxIndex
+}
+
+// enterInfo is the operand of opEnter.
+type enterInfo struct {
+	s *scope
+	// bind is the number of the values to bind to the first slots
+	bind int
+}
+
+// proto is the bytecode of a top-level form or a lambda, and the operands referred to by its instructions.
+type proto struct {
+	// signature is the arguments of the lambda, unused for a top-level form
+	signature
+	code    []instr
+	consts  []object.Object
+	names   []*node.Symbol
+	protos  []*proto
+	enters  []enterInfo
+	codes   []code
+	nodes   []*node.Node
+	overrun bool
+}
+
+// vmCompiler compiles nodes into the bytecode of a proto.
+type vmCompiler struct {
+	p *proto
+}
+
+// compileVM compiles the top-level node into bytecode.
+func compileVM(n *node.Node) *proto {
+	c := &vmCompiler{p: &proto{}}
+	c.expr(n, nil, true)
+	return c.p
+}
+
+// compileProto compiles the body of a lambda into bytecode.
+func compileProto(body []*node.Node, sig signature) *proto {
+	c := &vmCompiler{p: &proto{signature: sig}}
+	c.sequence(body, sig.s, true)
+	return c.p
+}
+
+// emit appends an instruction, and returns its position.
+func (c *vmCompiler) emit(o op, operand int) int {
+	if operand < 0 || operand > maxOperand {
+		// never happens in practice, as it needs more than 16M instructions or operands
+		c.p.overrun = true
+		operand = 0
+	}
+	c.p.code = append(c.p.code, instr(operand)<<8|instr(o))
+	return len(c.p.code) - 1
+}
+
+// patch sets the operand of the jump at pos to the current position.
+func (c *vmCompiler) patch(pos int) {
+	c.p.code[pos] = instr(len(c.p.code))<<8 | instr(c.p.code[pos].op())
+}
+
+// ret returns the value on the stack if in tail position.
+func (c *vmCompiler) ret(tail bool) {
+	if tail {
+		c.emit(opReturn, 0)
+	}
+}
+
+func (c *vmCompiler) constant(obj object.Object, tail bool) {
+	c.p.consts = append(c.p.consts, obj)
+	c.emit(opConst, len(c.p.consts)-1)
+	c.ret(tail)
+}
+
+// fallback compiles the node with compile, to be evaluated by opCode.
+func (c *vmCompiler) fallback(n *node.Node, s *scope, tail bool) {
+	c.p.codes = append(c.p.codes, compile(n, s))
+	c.emit(opCode, len(c.p.codes)-1)
+	c.ret(tail)
+}
+
+// slot returns the operand of the slot, or false if it cannot be encoded.
+func slot(depth, index int) (int, bool) {
+	if depth > maxDepth || index > maxIndex {
+		return 0, false
+	}
+	return slotOperand(depth, index), true
+}
+
+func (c *vmCompiler) name(name string) int {
+	c.p.names = append(c.p.names, node.Intern(name))
+	return len(c.p.names) - 1
+}
+
+// expr compiles the node, leaving its value on the stack, or returning it if in tail position.
+func (c *vmCompiler) expr(n *node.Node, s *scope, tail bool) {
+	if obj, ok := constant(n); ok {
+		c.constant(obj, tail)
+		return
+	}
+	if n.Type == node.Identifier {
+		depth, index, mutable, ok := s.resolve(n.Str)
+		if !ok {
+			c.emit(opGlobal, c.name(n.Str))
+			c.ret(tail)
+			return
+		}
+		operand, ok := slot(depth, index)
+		if !ok {
+			c.fallback(n, s, tail)
+			return
+		}
+		if mutable {
+			c.emit(opLoadLocal, operand)
+		} else {
+			c.emit(opLocal, operand)
+		}
+		c.ret(tail)
+		return
+	}
+	if n.Type != node.Branch || len(n.Children) == 0 {
+		// errors
+		c.fallback(n, s, tail)
+		return
+	}
+
+	// Special forms, where forms with bad syntax are compiled by compile to make the errors
+	if head := n.Children[0]; head.Type == node.Keyword {
+		switch head.Str {
+		case "and":
+			c.andOr(n, s, tail, opJumpIfFalseOrPop, object.NewBooleanObject(true))
+		case "or":
+			c.andOr(n, s, tail, opJumpIfTrueOrPop, object.NewBooleanObject(false))
+		case "if":
+			c.ifExpr(n, s, tail)
+		case "let":
+			c.let(n, s, tail)
+		case "let*":
+			c.letSeq(n, s, tail)
+		case "cond":
+			c.cond(n, s, tail)
+		case "set!":
+			c.set(n, s, tail)
+		case "quote":
+			if len(n.Children) != 2 {
+				c.fallback(n, s, tail)
+				return
+			}
+			c.constant(evalQuote(n.Children[1]), tail)
+		case "define":
+			c.define(n, s, tail)
+		case "lambda":
+			c.lambda(n, "", s, tail)
+		case "begin":
+			if len(n.Children) <= 1 {
+				c.fallback(n, s, tail)
+				return
+			}
+			c.sequence(n.Children[1:], s, tail)
+		case "profile":
+			c.profile(n, s, tail)
+		default:
+			c.fallback(n, s, tail)
+		}
+		return
+	}
+
+	// Function application
+	for _, child := range n.Children {
+		c.expr(child, s, false)
+	}
+	c.p.nodes = append(c.p.nodes, n)
+	if tail {
+		c.emit(opTailCall, len(n.Children)-1)
+	} else {
+		c.emit(opCall, len(n.Children)-1)
+	}
+	c.p.code = append(c.p.code, instr(len(c.p.nodes)-1))
+}
+
+// sequence compiles the sentences evaluated in order, leaving the value of the last one.
+func (c *vmCompiler) sequence(sentences []*node.Node, s *scope, tail bool) {
+	for _, sentence := range sentences[:len(sentences)-1] {
+		c.expr(sentence, s, false)
+		c.emit(opPop, 0)
+	}
+	c.expr(sentences[len(sentences)-1], s, tail)
+}
+
+func (c *vmCompiler) andOr(n *node.Node, s *scope, tail bool, jump op, empty object.Object) {
+	if len(n.Children) == 1 {
+		c.constant(empty, tail)
+		return
+	}
+	// Short circuit evaluation
+	var jumps []int
+	for _, child := range n.Children[1 : len(n.Children)-1] {
+		c.expr(child, s, false)
+		jumps = append(jumps, c.emit(jump, 0))
+	}
+	c.expr(n.Children[len(n.Children)-1], s, false)
+	for _, pos := range jumps {
+		c.patch(pos)
+	}
+	c.ret(tail)
+}
+
+func (c *vmCompiler) ifExpr(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) != 3 && len(n.Children) != 4 {
+		c.fallback(n, s, tail)
+		return
+	}
+	c.expr(n.Children[1], s, false)
+	els := c.emit(opJumpIfFalse, 0)
+	c.expr(n.Children[2], s, tail)
+	end := -1
+	if !tail {
+		end = c.emit(opJump, 0)
+	}
+	c.patch(els)
+	if len(n.Children) == 4 {
+		c.expr(n.Children[3], s, tail)
+	} else {
+		c.constant(object.VoidObj, tail)
+	}
+	if end >= 0 {
+		c.patch(end)
+	}
+}
+
+// bindPairs returns the names and the values of the bind pairs of let and let*, or false if bad syntax.
+func bindPairs(pairs *node.Node) ([]string, []*node.Node, bool) {
+	keys := make([]string, len(pairs.Children))
+	values := make([]*node.Node, len(pairs.Children))
+	for i, pair := range pairs.Children {
+		if len(pair.Children) != 2 || pair.Children[0].Type != node.Identifier {
+			return nil, nil, false
+		}
+		keys[i], values[i] = pair.Children[0].Str, pair.Children[1]
+	}
+	return keys, values, true
+}
+
+// enter makes a new environment of the scope, binding the values on the stack.
+func (c *vmCompiler) enter(s *scope, bind int) {
+	c.p.enters = append(c.p.enters, enterInfo{s: s, bind: bind})
+	c.emit(opEnter, len(c.p.enters)-1)
+}
+
+// body compiles the body evaluated in the environment made by opEnter.
+func (c *vmCompiler) body(sentences []*node.Node, s *scope, tail bool) {
+	c.sequence(sentences, s, tail)
+	if !tail {
+		c.emit(opLeave, 0)
+	}
+}
+
+func (c *vmCompiler) let(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) <= 2 {
+		c.fallback(n, s, tail)
+		return
+	}
+	keys, values, ok := bindPairs(n.Children[1])
+	if !ok {
+		c.fallback(n, s, tail)
+		return
+	}
+	for _, v := range values {
+		c.expr(v, s, false)
+	}
+	bodyScope := newScope(keys, n.Children[2:], s)
+	c.enter(bodyScope, len(keys))
+	c.body(n.Children[2:], bodyScope, tail)
+}
+
+func (c *vmCompiler) letSeq(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) <= 2 {
+		c.fallback(n, s, tail)
+		return
+	}
+	keys, values, ok := bindPairs(n.Children[1])
+	if !ok {
+		c.fallback(n, s, tail)
+		return
+	}
+	// all bindings are in the same environment, where each value can refer to the previous bindings
+	bodyScope := newScope(nil, n.Children[1:], s)
+	c.enter(bodyScope, 0)
+	for i, v := range values {
+		c.expr(v, bodyScope, false)
+		c.emit(opDefineLocal, slotOperand(0, bodyScope.add(keys[i], false)))
+		c.emit(opPop, 0)
+	}
+	c.body(n.Children[2:], bodyScope, tail)
+}
+
+func (c *vmCompiler) cond(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) == 1 {
+		c.fallback(n, s, tail)
+		return
+	}
+	for _, branch := range n.Children[1:] {
+		if branch.Type != node.Branch || len(branch.Children) == 0 ||
+			branch.Children[0].Type == node.Keyword && branch.Children[0].Str == "else" && len(branch.Children) == 1 {
+			c.fallback(n, s, tail)
+			return
+		}
+	}
+
+	// jumps to the end, where the value is on the stack
+	var ends []int
+	hasElse := false
+	for _, branch := range n.Children[1:] {
+		test := branch.Children[0]
+		if test.Type == node.Keyword && test.Str == "else" {
+			c.sequence(branch.Children[1:], s, tail)
+			if !tail {
+				ends = append(ends, c.emit(opJump, 0))
+			}
+			hasElse = true
+			break
+		}
+		c.expr(test, s, false)
+		if len(branch.Children) == 1 {
+			// (cond (test)) returns the value of test
+			ends = append(ends, c.emit(opJumpIfTrueOrPop, 0))
+			continue
+		}
+		next := c.emit(opJumpIfFalse, 0)
+		c.sequence(branch.Children[1:], s, tail)
+		if !tail {
+			ends = append(ends, c.emit(opJump, 0))
+		}
+		c.patch(next)
+	}
+	if !hasElse {
+		// no cond match
+		c.p.consts = append(c.p.consts, object.VoidObj)
+		c.emit(opConst, len(c.p.consts)-1)
+	}
+	for _, pos := range ends {
+		c.patch(pos)
+	}
+	c.ret(tail)
+}
+
+func (c *vmCompiler) set(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) != 3 || n.Children[1].Type != node.Identifier {
+		c.fallback(n, s, tail)
+		return
+	}
+	key := n.Children[1].Str
+	depth, index, _, ok := s.resolve(key)
+	if !ok {
+		c.expr(n.Children[2], s, false)
+		c.emit(opSetGlobal, c.name(key))
+		c.ret(tail)
+		return
+	}
+	operand, ok := slot(depth, index)
+	if !ok {
+		c.fallback(n, s, tail)
+		return
+	}
+	c.expr(n.Children[2], s, false)
+	c.emit(opSetLocal, operand)
+	c.ret(tail)
+}
+
+func (c *vmCompiler) define(n *node.Node, s *scope, tail bool) {
+	d, err := desugarDefine(n)
+	if err != nil || len(d.Children) != 3 || d.Children[1].Type != node.Identifier {
+		c.fallback(n, s, tail)
+		return
+	}
+	key := d.Children[1].Str
+	if s == nil {
+		c.value(d.Children[2], key, s)
+		c.emit(opDefineGlobal, c.name(key))
+		c.ret(tail)
+		return
+	}
+	// internal defines have their slots in the scope of the body
+	operand, ok := slot(0, s.index(key))
+	if s.index(key) < 0 || !ok {
+		c.fallback(n, s, tail)
+		return
+	}
+	c.value(d.Children[2], key, s)
+	c.emit(opDefineLocal, operand)
+	c.ret(tail)
+}
+
+// value compiles the value of the define of name, naming the function if a lambda.
+func (c *vmCompiler) value(n *node.Node, name string, s *scope) {
+	if isLambda(n) {
+		c.lambda(n, name, s, false)
+		return
+	}
+	c.expr(n, s, false)
+}
+
+func (c *vmCompiler) lambda(n *node.Node, name string, s *scope, tail bool) {
+	sig, err := newSignature(n, name, s)
+	if err != nil {
+		c.fallback(n, s, tail)
+		return
+	}
+	c.p.protos = append(c.p.protos, compileProto(n.Children[2:], sig))
+	c.emit(opClosure, len(c.p.protos)-1)
+	c.ret(tail)
+}
+
+func (c *vmCompiler) profile(n *node.Node, s *scope, tail bool) {
+	if len(n.Children) != 2 {
+		c.fallback(n, s, tail)
+		return
+	}
+	expr := &vmCompiler{p: &proto{}}
+	expr.expr(n.Children[1], s, true)
+	c.p.codes = append(c.p.codes, profileCode(func(ctx context.Context, env *object.Env) (object.Object, code, *object.Env) {
+		return runVM(ctx, expr.p, env), nil, nil
+	}))
+	c.emit(opCode, len(c.p.codes)-1)
+	c.ret(tail)
+}
+
+// vmClosure is a function made by lambda compiled into bytecode.
+// Calls to vmClosures from bytecode, including those through apply, are evaluated in the same virtual machine,
+// while other builtins call them through F.
+type vmClosure struct {
+	object.Object
+	p   *proto
+	env *object.Env
+}
+
+func newVMClosure(p *proto, env *object.Env) *vmClosure {
+	c := &vmClosure{p: p, env: env}
+	c.Object = object.NewFunctionObject(func(ctx context.Context, objects []object.Object) (object.Object, *node.Node, *object.Env) {
+		newEnv, errObj := c.p.bind(ctx, c.env, objects, false)
+		if errObj != nil {
+			return errObj, nil, nil
+		}
+		if profiling() {
+			t := profileTrackOf(ctx)
+			defer t.set(t.call(c.p.name))
+		}
+		return runVM(ctx, c.p, newEnv), nil, nil
+	})
+	return c
+}
+
+// frame is a frame of a call in the virtual machine.
+type frame struct {
+	p   *proto
+	pc  int
+	env *object.Env
+	// caller is the named function being evaluated by the caller, restored on return while profiling
+	caller string
+}
+
+// runVM evaluates the bytecode in the environment with a stack-based virtual machine, and returns the result.
+// Frames of calls are kept in a slice instead of the Go stack, so that only entering runVM counts towards Limits.MaxDepth,
+// while the number of frames is limited by Limits.MaxFrames.
+func runVM(ctx context.Context, p *proto, env *object.Env) (ret object.Object) {
+	if p.overrun {
+		return object.NewErrorObject("internal error: form too large to compile into bytecode")
+	}
+	state, errObj := enter(ctx)
+	if errObj != nil {
+		return errObj
+	}
+	defer state.leave()
+	frames := []frame{{p: p, env: env}}
+	limit := maxFrames(ctx)
+	var t *profileTrack
+	if profiling() {
+		t = profileTrackOf(ctx)
+		defer t.set(t.get())
+	}
+	stack := make([]object.Object, 0, 16)
+	push := func(obj object.Object) {
+		stack = append(stack, obj)
+	}
+	pop := func() object.Object {
+		obj := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return obj
+	}
+
+	f := &frames[0]
+	for {
+		in := f.p.code[f.pc]
+		f.pc++
+		switch in.op() {
+		case opConst:
+			push(f.p.consts[in.operand()])
+		case opGlobal:
+			name := f.p.names[in.operand()]
+			if obj, ok := f.env.LookupGlobal(name); ok {
+				push(obj)
+			} else {
+				push(object.NewErrorObject(fmt.Sprintf("unbound identifier: %v", name.Name())))
+			}
+		case opLocal, opLoadLocal:
+			depth, index := fromSlotOperand(in.operand())
+			var obj object.Object
+			if in.op() == opLocal {
+				obj = f.env.Slot(depth, index)
+			} else {
+				obj = f.env.LoadSlot(depth, index)
+			}
+			if obj == nil {
+				obj = object.NewErrorObject(fmt.Sprintf("unbound identifier: %v", slotName(f.env, depth, index)))
+			}
+			push(obj)
+		case opDefineGlobal:
+			f.env.Define(f.p.names[in.operand()].Name(), pop())
+			push(object.VoidObj)
+		case opDefineLocal:
+			depth, index := fromSlotOperand(in.operand())
+			f.env.StoreSlot(depth, index, pop())
+			push(object.VoidObj)
+		case opSetGlobal:
+			name := f.p.names[in.operand()]
+			if ok := f.env.SetGlobal(name, pop()); ok {
+				push(object.VoidObj)
+			} else {
+				push(object.NewErrorObject(fmt.Sprintf("set!: %v is not defined yet", name.Name())))
+			}
+		case opSetLocal:
+			depth, index := fromSlotOperand(in.operand())
+			obj := pop()
+			if f.env.LoadSlot(depth, index) == nil {
+				push(object.NewErrorObject(fmt.Sprintf("set!: %v is not defined yet", slotName(f.env, depth, index))))
+			} else {
+				f.env.StoreSlot(depth, index, obj)
+				push(object.VoidObj)
+			}
+		case opPop:
+			pop()
+		case opJump:
+			f.pc = in.operand()
+		case opJumpIfFalse:
+			if !pop().IsTruthy() {
+				f.pc = in.operand()
+			}
+		case opJumpIfFalseOrPop:
+			if !stack[len(stack)-1].IsTruthy() {
+				f.pc = in.operand()
+			} else {
+				pop()
+			}
+		case opJumpIfTrueOrPop:
+			if stack[len(stack)-1].IsTruthy() {
+				f.pc = in.operand()
+			} else {
+				pop()
+			}
+		case opClosure:
+			push(newVMClosure(f.p.protos[in.operand()], f.env))
+		case opEnter:
+			info := f.p.enters[in.operand()]
+			slots := info.s.slots()
+			copy(slots, stack[len(stack)-info.bind:])
+			stack = stack[:len(stack)-info.bind]
+			f.env = f.env.NewEnv(info.s.names, slots)
+		case opLeave:
+			f.env = f.env.Upper()
+		case opCall, opTailCall:
+			tail := in.op() == opTailCall
+			n := f.p.nodes[f.p.code[f.pc]]
+			f.pc++
+			argc := in.operand()
+			fn, args := stack[len(stack)-argc-1], stack[len(stack)-argc:]
+			for _, arg := range args {
+				if arg.Type() == object_type.Err {
+					// stop at once, rather than wrapping the error at each level of deep recursion
+					if errObj := limitError(ctx); errObj != nil {
+						return errObj
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return object.NewErrorObject(fmt.Sprintf("evaluation stopped: %v", ctx.Err()))
+			default:
+			}
+			if errObj := step(ctx); errObj != nil {
+				return errObj
+			}
+
+			var res object.Object
+			fn, args, errObj := unwrapApply(fn, args)
+			if errObj != nil {
+				res = errObj
+				stack = stack[:len(stack)-argc-1]
+			} else if c, ok := fn.(*vmClosure); ok {
+				newEnv, errObj := c.p.bind(ctx, c.env, args, false)
+				stack = stack[:len(stack)-argc-1]
+				if errObj != nil {
+					res = errObj
+				} else if tail {
+					t.call(c.p.name)
+					f.p, f.pc, f.env = c.p, 0, newEnv
+					continue
+				} else {
+					if limit > 0 && len(frames) >= limit {
+						return state.fail(object.NewErrorObject(fmt.Sprintf("recursion depth limit exceeded: nested more than %v frames", limit)))
+					}
+					frames = append(frames, frame{p: c.p, env: newEnv, caller: t.call(c.p.name)})
+					f = &frames[len(frames)-1]
+					continue
+				}
+			} else if fn.Type() != object_type.Function {
+				res = object.NewErrorObject(fmt.Sprintf("expected function in 0-th argument, but got %v", fn))
+				stack = stack[:len(stack)-argc-1]
+			} else {
+				objects := make([]object.Object, len(args))
+				copy(objects, args)
+				stack = stack[:len(stack)-argc-1]
+				var next *node.Node
+				var nextEnv *object.Env
+				if res, next, nextEnv = apply(ctx, n, fn, objects); res == nil {
+					res = evalWithTailOptimization(ctx, next, nextEnv)
+				}
+			}
+			push(res)
+			if !tail {
+				continue
+			}
+			fallthrough
+		case opReturn:
+			res := pop()
+			caller := f.caller
+			frames = frames[:len(frames)-1]
+			if len(frames) == 0 {
+				return res
+			}
+			t.set(caller)
+			f = &frames[len(frames)-1]
+			push(res)
+		case opCode:
+			push(evalCode(ctx, f.p.codes[in.operand()], f.env))
+		default:
+			panic(fmt.Sprintf("unknown op %v", in.op()))
+		}
+	}
+}
+
+// slotName returns the name of the slot, for error messages.
+func slotName(env *object.Env, depth, index int) string {
+	for ; depth > 0; depth-- {
+		env = env.Upper()
+	}
+	return env.Names()[index]
+}
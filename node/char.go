@@ -0,0 +1,49 @@
+package node
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// charNames is a map from character names to characters, used in #\name syntax.
+var charNames = map[string]rune{
+	"null":      0,
+	"alarm":     7,
+	"backspace": 8,
+	"tab":       '\t',
+	"newline":   '\n',
+	"return":    '\r',
+	"escape":    27,
+	"space":     ' ',
+	"delete":    127,
+}
+
+// ParseChar parses the character syntax following #\, e.g. "a", "space" or "x41".
+func ParseChar(s string) (rune, bool) {
+	if r, size := utf8.DecodeRuneInString(s); size == len(s) && r != utf8.RuneError {
+		return r, true
+	}
+	if r, ok := charNames[s]; ok {
+		return r, true
+	}
+	if len(s) > 1 && s[0] == 'x' {
+		if n, err := strconv.ParseUint(s[1:], 16, 32); err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), true
+		}
+	}
+	return 0, false
+}
+
+// CharString returns the external representation of the given character, e.g. #\a or #\space.
+func CharString(r rune) string {
+	for name, c := range charNames {
+		if c == r {
+			return `#\` + name
+		}
+	}
+	if r < ' ' {
+		return fmt.Sprintf(`#\x%x`, r)
+	}
+	return `#\` + string(r)
+}
@@ -0,0 +1,100 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// stringEscapes is a map from escaped characters in string and |symbol| literals to characters.
+var stringEscapes = map[byte]rune{
+	'a':  7,
+	'b':  8,
+	't':  '\t',
+	'n':  '\n',
+	'r':  '\r',
+	'"':  '"',
+	'\\': '\\',
+	'|':  '|',
+}
+
+// Unescape unescapes the contents of string and |symbol| literals, e.g. \n, \" and \x41;.
+func Unescape(s string) (string, error) {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s, nil
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			sb.WriteByte(s[i])
+			continue
+		}
+		i++
+		if i == len(s) {
+			return "", errors.New("unexpected end of escape sequence")
+		}
+		if r, ok := stringEscapes[s[i]]; ok {
+			sb.WriteRune(r)
+			continue
+		}
+		if s[i] == 'x' {
+			end := strings.IndexByte(s[i:], ';')
+			if end == -1 {
+				return "", fmt.Errorf("unterminated hex escape: %v", s[i-1:])
+			}
+			n, err := strconv.ParseUint(s[i+1:i+end], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid hex escape: %v", s[i-1:i+end+1])
+			}
+			sb.WriteRune(rune(n))
+			i += end
+			continue
+		}
+		return "", fmt.Errorf("unknown escape sequence: \\%c", s[i])
+	}
+	return sb.String(), nil
+}
+
+// Escape escapes s to be enclosed by the given delimiter (" or |).
+func Escape(s string, delim rune) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch {
+		case r == delim || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r == '\n':
+			sb.WriteString(`\n`)
+		case r == '\t':
+			sb.WriteString(`\t`)
+		case r == '\r':
+			sb.WriteString(`\r`)
+		case r < ' ' || r == 127:
+			sb.WriteString(fmt.Sprintf(`\x%x;`, r))
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// QuoteString returns the string literal of s.
+func QuoteString(s string) string {
+	return `"` + Escape(s, '"') + `"`
+}
+
+// QuoteSymbol returns the external representation of the symbol of the given name,
+// enclosed by | if the name cannot be read as an identifier as it is.
+func QuoteSymbol(name string) string {
+	if name == "" || name == "." || numRegexp.MatchString(name) ||
+		strings.HasPrefix(name, "#") || strings.IndexFunc(name, needsBars) != -1 {
+		return "|" + Escape(name, '|') + "|"
+	}
+	return name
+}
+
+func needsBars(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r) || strings.ContainsRune("()\";'|\\", r)
+}
@@ -2,6 +2,7 @@ package node
 
 import (
 	"fmt"
+	"github.com/motoki317/lisp-interpreter/token"
 	"strconv"
 	"strings"
 )
@@ -11,7 +12,22 @@ type Node struct {
 	Children []*Node
 	Str      string
 	Num      float64
-	B        bool
+	// Inexact is true if Number is written with a decimal point
+	Inexact bool
+	B       bool
+	Ch      rune
+	// Sym is the interned symbol of Identifier, set by Parser
+	Sym *Symbol
+	// Pos is the position of the node in the source, if parsed by Parser
+	Pos token.Pos
+}
+
+// Symbol returns the interned symbol of Identifier, interning Str if Sym is not set.
+func (n *Node) Symbol() *Symbol {
+	if n.Sym != nil {
+		return n.Sym
+	}
+	return Intern(n.Str)
 }
 
 type Type int
@@ -29,6 +45,12 @@ const (
 	Boolean
 	// String String constant
 	String
+	// Char Character constant
+	Char
+	// DatumLabel Datum labelled by #n=, Str is n and the only child is the datum
+	DatumLabel
+	// DatumReference Reference #n# to the datum labelled by #n=, Str is n
+	DatumReference
 )
 
 func (t Type) String() string {
@@ -43,6 +65,12 @@ func (t Type) String() string {
 		return "number"
 	case String:
 		return "string"
+	case Char:
+		return "char"
+	case DatumLabel:
+		return "datum_label"
+	case DatumReference:
+		return "datum_reference"
 	}
 	return strconv.Itoa(int(t))
 }
@@ -58,7 +86,7 @@ func (n Node) String() string {
 	case Keyword:
 		return n.Str
 	case Identifier:
-		return n.Str
+		return QuoteSymbol(n.Str)
 	case Number:
 		return fmt.Sprintf("%v", n.Num)
 	case Boolean:
@@ -68,7 +96,13 @@ func (n Node) String() string {
 			return "#f"
 		}
 	case String:
-		return fmt.Sprintf("\"%v\"", n.Str)
+		return QuoteString(n.Str)
+	case Char:
+		return CharString(n.Ch)
+	case DatumLabel:
+		return "#" + n.Str + "=" + n.Children[0].String()
+	case DatumReference:
+		return "#" + n.Str + "#"
 	}
 	return fmt.Sprintf("unknown_type: %v", n.Type)
 }
@@ -0,0 +1,16 @@
+package node
+
+import "math"
+
+// ParseInfNaN parses the syntax of infinities and NaN, i.e. "+inf.0", "-inf.0", "+nan.0" and "-nan.0".
+func ParseInfNaN(s string) (float64, bool) {
+	switch s {
+	case "+inf.0":
+		return math.Inf(1), true
+	case "-inf.0":
+		return math.Inf(-1), true
+	case "+nan.0", "-nan.0":
+		return math.NaN(), true
+	}
+	return 0, false
+}
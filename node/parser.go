@@ -6,12 +6,15 @@ import (
 	"github.com/motoki317/lisp-interpreter/token"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 var (
 	EOF       = errors.New("end of input")
 	keywords  map[string]bool
 	numRegexp = regexp.MustCompile("^-?[0-9]+?(\\.[0-9]*)?$")
+	// labelRegexp matches datum labels #n= and references #n#
+	labelRegexp = regexp.MustCompile("^#([0-9]+)([=#])$")
 )
 
 func init() {
@@ -34,6 +37,14 @@ func init() {
 		"syntax-rules",
 		"...",
 		"delay",
+		"delay-force",
+		"stream-cons",
+		"parameterize",
+		"select",
+		"profile",
+		"define-library",
+		"import",
+		"include",
 	}
 	keywords = make(map[string]bool, len(keywordsList))
 	for _, keyword := range keywordsList {
@@ -44,6 +55,15 @@ func init() {
 type Parser struct {
 	t   *token.Tokenizer
 	buf *token.Token
+	// bufPos is the position of buf
+	bufPos token.Pos
+	// pos is the position of the last consumed token
+	pos token.Pos
+	// depth is the nesting depth of the node being parsed
+	depth int
+	// labels is the set of datum labels defined in the top-level node being parsed,
+	// mapped to true if the labelled datum has been parsed
+	labels map[string]bool
 }
 
 func NewParser(t *token.Tokenizer) *Parser {
@@ -55,6 +75,11 @@ func (p *Parser) SetTokenizer(t *token.Tokenizer) {
 	p.t = t
 }
 
+// Tokenizer returns the internal tokenizer used by this parser.
+func (p *Parser) Tokenizer() *token.Tokenizer {
+	return p.t
+}
+
 func (p *Parser) read() error {
 	if p.buf != nil {
 		return nil
@@ -67,9 +92,16 @@ func (p *Parser) read() error {
 		return EOF
 	}
 	p.buf = t
+	p.bufPos = p.t.Pos()
 	return nil
 }
 
+// Pos returns the position of the last token consumed by this parser.
+// After Next returned an error, this is the position where the error occurred.
+func (p *Parser) Pos() token.Pos {
+	return p.pos
+}
+
 func (p *Parser) consume(tokenType token.Type) (*token.Token, bool, error) {
 	err := p.read()
 	if err != nil {
@@ -78,6 +110,7 @@ func (p *Parser) consume(tokenType token.Type) (*token.Token, bool, error) {
 	if p.buf.Type == tokenType {
 		t := p.buf
 		p.buf = nil
+		p.pos = p.bufPos
 		return t, true, nil
 	}
 	return nil, false, nil
@@ -86,13 +119,31 @@ func (p *Parser) consume(tokenType token.Type) (*token.Token, bool, error) {
 // Next parses tokens from the tokenizer, and returns the next node.
 // Returns nil and EOF error on end of input.
 func (p *Parser) Next() (*Node, error) {
+	if p.depth == 0 {
+		// datum labels are local to the top-level node
+		p.labels = nil
+	}
+	p.depth++
+	defer func() { p.depth-- }()
+
 	err := p.read()
 	if err != nil {
 		return nil, err
 	}
 
-	t := p.buf
+	t, pos := p.buf, p.bufPos
 	p.buf = nil
+	p.pos = pos
+	n, err := p.parse(t)
+	if err != nil {
+		return nil, err
+	}
+	n.Pos = pos
+	return n, nil
+}
+
+// parse parses the node starting from the given token.
+func (p *Parser) parse(t *token.Token) (*Node, error) {
 	switch t.Type {
 	case token.RightPar:
 		return nil, errors.New("unexpected right parenthesis")
@@ -114,6 +165,11 @@ func (p *Parser) Next() (*Node, error) {
 			}, nil
 		}
 
+		// Datum label or reference
+		if m := labelRegexp.FindStringSubmatch(s); m != nil {
+			return p.parseLabel(m[1], m[2] == "=")
+		}
+
 		// Boolean
 		if s == "#t" || s == "#f" {
 			return &Node{
@@ -122,15 +178,35 @@ func (p *Parser) Next() (*Node, error) {
 			}, nil
 		}
 
+		// Char
+		if strings.HasPrefix(s, `#\`) {
+			ch, ok := ParseChar(s[2:])
+			if !ok {
+				return nil, fmt.Errorf("unknown character: %v", s)
+			}
+			return &Node{
+				Type: Char,
+				Ch:   ch,
+			}, nil
+		}
+
 		// Number
+		if num, ok := ParseInfNaN(s); ok {
+			return &Node{
+				Type:    Number,
+				Num:     num,
+				Inexact: true,
+			}, nil
+		}
 		if numRegexp.MatchString(s) {
 			num, err := strconv.ParseFloat(t.String, 64)
 			if err != nil {
 				return nil, fmt.Errorf("error while parsing number: %w", err)
 			}
 			return &Node{
-				Type: Number,
-				Num:  num,
+				Type:    Number,
+				Num:     num,
+				Inexact: strings.Contains(s, "."),
 			}, nil
 		}
 
@@ -143,10 +219,27 @@ func (p *Parser) Next() (*Node, error) {
 		}
 
 		// String
-		if s[0] == '"' && s[len(s)-1] == '"' {
+		if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+			str, err := Unescape(s[1 : len(s)-1])
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing string: %w", err)
+			}
 			return &Node{
 				Type: String,
-				Str:  s[1 : len(s)-1],
+				Str:  str,
+			}, nil
+		}
+
+		// Identifier enclosed by |
+		if len(s) >= 2 && s[0] == '|' && s[len(s)-1] == '|' {
+			str, err := Unescape(s[1 : len(s)-1])
+			if err != nil {
+				return nil, fmt.Errorf("error while parsing identifier: %w", err)
+			}
+			return &Node{
+				Type: Identifier,
+				Str:  str,
+				Sym:  Intern(str),
 			}, nil
 		}
 
@@ -154,6 +247,7 @@ func (p *Parser) Next() (*Node, error) {
 		return &Node{
 			Type: Identifier,
 			Str:  s,
+			Sym:  Intern(s),
 		}, nil
 	case token.LeftPar:
 		node := &Node{
@@ -179,3 +273,36 @@ func (p *Parser) Next() (*Node, error) {
 
 	return nil, errors.New(fmt.Sprintf("parser internal error: unexpected token: %v", t.Type))
 }
+
+// parseLabel parses the datum labelled by #label=, or the reference #label#.
+func (p *Parser) parseLabel(label string, define bool) (*Node, error) {
+	if !define {
+		if _, ok := p.labels[label]; !ok {
+			return nil, fmt.Errorf("undefined datum label: #%v#", label)
+		}
+		return &Node{
+			Type: DatumReference,
+			Str:  label,
+		}, nil
+	}
+	if _, ok := p.labels[label]; ok {
+		return nil, fmt.Errorf("duplicate datum label: #%v=", label)
+	}
+	if p.labels == nil {
+		p.labels = make(map[string]bool)
+	}
+	p.labels[label] = false
+	next, err := p.Next()
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred while parsing datum label: %v", err)
+	}
+	if next.Type == DatumReference && !p.labels[next.Str] {
+		return nil, fmt.Errorf("datum label refers to itself: #%v=%v", label, next)
+	}
+	p.labels[label] = true
+	return &Node{
+		Type:     DatumLabel,
+		Str:      label,
+		Children: []*Node{next},
+	}, nil
+}
@@ -3,6 +3,7 @@ package node
 import (
 	"fmt"
 	"github.com/motoki317/lisp-interpreter/token"
+	"math"
 	"strings"
 	"testing"
 )
@@ -14,7 +15,7 @@ func (n *Node) equals(other *Node) bool {
 	}
 	switch n.Type {
 	case Identifier:
-		return n.Str == other.Str
+		return n.Str == other.Str && n.Symbol() == other.Symbol()
 	case Keyword:
 		return n.Str == other.Str
 	case Number:
@@ -23,6 +24,12 @@ func (n *Node) equals(other *Node) bool {
 		return n.B == other.B
 	case String:
 		return n.Str == other.Str
+	case Char:
+		return n.Ch == other.Ch
+	case DatumReference:
+		return n.Str == other.Str
+	case DatumLabel:
+		fallthrough
 	case Branch:
 		if len(n.Children) != len(other.Children) {
 			return false
@@ -93,6 +100,17 @@ func TestParser(t *testing.T) {
 				}},
 			},
 		},
+		{
+			name:   "infinities",
+			string: "(+inf.0 -inf.0 inf)",
+			want: []*Node{
+				{Type: Branch, Children: []*Node{
+					{Type: Number, Num: math.Inf(1)},
+					{Type: Number, Num: math.Inf(-1)},
+					{Type: Identifier, Str: "inf"},
+				}},
+			},
+		},
 		{
 			name:   "nesting node",
 			string: "(define (my-mult arg1 arg2) (* arg1 arg2))",
@@ -137,6 +155,55 @@ func TestParser(t *testing.T) {
 				{Type: String, Str: "po po"},
 			},
 		},
+		{
+			name:   "string with escapes",
+			string: `"po\n\"po\"\\\x41;"`,
+			want: []*Node{
+				{Type: String, Str: "po\n\"po\"\\A"},
+			},
+		},
+		{
+			name:   "identifier with bars",
+			string: `|po po| |1|`,
+			want: []*Node{
+				{Type: Identifier, Str: "po po"},
+				{Type: Identifier, Str: "1"},
+			},
+		},
+		{
+			name:   "char",
+			string: `#\a #\( #\space #\x41`,
+			want: []*Node{
+				{Type: Char, Ch: 'a'},
+				{Type: Char, Ch: '('},
+				{Type: Char, Ch: ' '},
+				{Type: Char, Ch: 'A'},
+			},
+		},
+		{
+			name:   "datum labels",
+			string: "'#0=(a . #0#) (#1=b #2=#1#)",
+			want: []*Node{
+				{Type: Branch, Children: []*Node{
+					{Type: Keyword, Str: "quote"},
+					{Type: DatumLabel, Str: "0", Children: []*Node{
+						{Type: Branch, Children: []*Node{
+							{Type: Identifier, Str: "a"},
+							{Type: Keyword, Str: "."},
+							{Type: DatumReference, Str: "0"},
+						}},
+					}},
+				}},
+				{Type: Branch, Children: []*Node{
+					{Type: DatumLabel, Str: "1", Children: []*Node{
+						{Type: Identifier, Str: "b"},
+					}},
+					{Type: DatumLabel, Str: "2", Children: []*Node{
+						{Type: DatumReference, Str: "1"},
+					}},
+				}},
+			},
+		},
 	}
 	for _, tt := range tests {
 		tt := tt
@@ -151,3 +218,69 @@ func TestParser(t *testing.T) {
 		})
 	}
 }
+
+func TestParserErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		string string
+		want   string
+	}{
+		{
+			name:   "undefined datum label",
+			string: "(#0#)",
+			want:   "an error occurred while parsing node: undefined datum label: #0#",
+		},
+		{
+			name:   "datum label of another node",
+			string: "#0=a #0#",
+			want:   "undefined datum label: #0#",
+		},
+		{
+			name:   "duplicate datum label",
+			string: "(#0=a #0=b)",
+			want:   "an error occurred while parsing node: duplicate datum label: #0=",
+		},
+		{
+			name:   "datum label referring to itself",
+			string: "#0=#1=#0#",
+			want:   "an error occurred while parsing datum label: datum label refers to itself: #1=#0#",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			parser := NewParser(token.NewTokenizer(strings.NewReader(tt.string)))
+			var err error
+			for err == nil {
+				_, err = parser.Next()
+			}
+			if err == EOF || err.Error() != tt.want {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestIntern(t *testing.T) {
+	if Intern("po") != Intern("po") {
+		t.Errorf("Intern returned different symbols of the same name")
+	}
+	if Intern("po") == Intern("pi") {
+		t.Errorf("Intern returned the same symbol of different names")
+	}
+	u := NewUninternedSymbol("po")
+	if u == Intern("po") || u == NewUninternedSymbol("po") || u.IsInterned() {
+		t.Errorf("uninterned symbol is the same as another symbol")
+	}
+	if u.Name() != "po" || !Intern("po").IsInterned() {
+		t.Errorf("unexpected symbol %v", u.Name())
+	}
+	if s, ok := Interned("po"); !ok || s != Intern("po") {
+		t.Errorf("Interned did not return the interned symbol")
+	}
+	if _, ok := Interned("never interned"); ok {
+		t.Errorf("Interned returned a symbol of a name never interned")
+	}
+}
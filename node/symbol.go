@@ -0,0 +1,50 @@
+package node
+
+import (
+	"sync"
+)
+
+// Symbol is a symbol interned by Intern, where symbols of the same name are the same pointer,
+// or an uninterned symbol made by NewUninternedSymbol, different from any other symbol.
+type Symbol struct {
+	name     string
+	interned bool
+}
+
+// symbols is the global table of interned symbols, mapping names to *Symbol.
+// Interned symbols are never freed, so that programs making symbols from strings should be limited by the caller.
+var symbols sync.Map
+
+// Intern returns the canonical symbol of the name.
+func Intern(name string) *Symbol {
+	if s, ok := symbols.Load(name); ok {
+		return s.(*Symbol)
+	}
+	s, _ := symbols.LoadOrStore(name, &Symbol{name: name, interned: true})
+	return s.(*Symbol)
+}
+
+// Interned returns the interned symbol of the name, or false if the name has not been interned,
+// without adding the name to the table.
+func Interned(name string) (*Symbol, bool) {
+	s, ok := symbols.Load(name)
+	if !ok {
+		return nil, false
+	}
+	return s.(*Symbol), true
+}
+
+// NewUninternedSymbol returns a new symbol of the name, not the same as any other symbol even if of the same name.
+func NewUninternedSymbol(name string) *Symbol {
+	return &Symbol{name: name}
+}
+
+// Name returns the name of the symbol.
+func (s *Symbol) Name() string {
+	return s.name
+}
+
+// IsInterned returns true if the symbol is interned.
+func (s *Symbol) IsInterned() bool {
+	return s.interned
+}
@@ -31,3 +31,20 @@ func (t Type) String() string {
 	}
 	return strconv.Itoa(int(t))
 }
+
+// Pos is a position in the input.
+type Pos struct {
+	// Line is the 1-based line number, or 0 if the position is unknown
+	Line int
+	// Column is the 1-based column number in bytes
+	Column int
+}
+
+func (p Pos) String() string {
+	return strconv.Itoa(p.Line) + ":" + strconv.Itoa(p.Column)
+}
+
+// IsValid returns true if the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
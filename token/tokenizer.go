@@ -5,10 +5,34 @@ import (
 	"bytes"
 	"io"
 	"unicode"
+	"unicode/utf8"
 )
 
+// Tokenizer reads tokens from the input. It also reads characters from the same input,
+// so that input ports can mix reading data and characters.
 type Tokenizer struct {
-	sc *bufio.Scanner
+	r io.Reader
+	// buf[start:end] is the input read but not consumed yet
+	buf        []byte
+	start, end int
+	// err is the error returned by the last read, io.EOF at the end of the input
+	err error
+	// pos is the position of the next unread byte
+	pos Pos
+	// tokenPos is the position of the last token
+	tokenPos Pos
+	// lastRuneSize is the size of the rune last read by ReadRune, -1 if it cannot be unread
+	lastRuneSize int
+	// lastRunePos is the position of the rune last read by ReadRune
+	lastRunePos Pos
+}
+
+// advance returns the position after reading the given bytes.
+func (p Pos) advance(data []byte) Pos {
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		return Pos{Line: p.Line + bytes.Count(data, []byte{'\n'}), Column: len(data) - i}
+	}
+	return Pos{Line: p.Line, Column: p.Column + len(data)}
 }
 
 // isSpaceParCommentQuote returns true if r is one of: space, (, ), ;, ', or "
@@ -53,11 +77,46 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		}
 	case '\'':
 		return 1, data[0:1], nil
-	case '"':
-		// string: read till next double quote
-		if i := bytes.IndexByte(data[1:], '"'); i >= 0 {
-			return i + 2, data[0 : i+2], nil
-		} else {
+	case '"', '|':
+		// string or symbol enclosed by |: read till next unescaped double quote or |
+		for i := 1; i < len(data); i++ {
+			switch data[i] {
+			case '\\':
+				i++
+			case data[0]:
+				return i + 1, data[0 : i+1], nil
+			}
+		}
+		return 0, nil, nil
+	}
+	// character: #\ followed by any character (including delimiters), e.g. #\( or #\space
+	if bytes.HasPrefix(data, []byte(`#\`)) {
+		if len(data) < 3 || !utf8.FullRune(data[2:]) {
+			if atEOF {
+				return len(data), data, nil
+			}
+			return 0, nil, nil
+		}
+		_, size := utf8.DecodeRune(data[2:])
+		if i := bytes.IndexFunc(data[2+size:], isSpaceParCommentQuote); i >= 0 {
+			return 2 + size + i, data[0 : 2+size+i], nil
+		}
+		if atEOF {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+	// datum label: #n= followed by the labelled datum, e.g. #0=(a . #0#)
+	if data[0] == '#' {
+		i := 1
+		for i < len(data) && '0' <= data[i] && data[i] <= '9' {
+			i++
+		}
+		if i > 1 && i < len(data) && data[i] == '=' {
+			return i + 1, data[0 : i+1], nil
+		}
+		if i == len(data) && !atEOF {
+			// may be a datum label, request more data.
 			return 0, nil, nil
 		}
 	}
@@ -76,36 +135,127 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 
 // NewTokenizer creates a new tokenizer with the given io.Reader.
 func NewTokenizer(r io.Reader) *Tokenizer {
-	sc := bufio.NewScanner(r)
-	sc.Split(splitFunc)
 	return &Tokenizer{
-		sc: sc,
+		r:            r,
+		pos:          Pos{Line: 1, Column: 1},
+		lastRuneSize: -1,
 	}
 }
 
+// fill reads more input into the buffer, returning false if no more input can be read.
+func (t *Tokenizer) fill() bool {
+	if t.err != nil {
+		return false
+	}
+	if t.start > 0 {
+		copy(t.buf, t.buf[t.start:t.end])
+		t.end -= t.start
+		t.start = 0
+	}
+	if t.end == len(t.buf) {
+		if len(t.buf) >= bufio.MaxScanTokenSize {
+			t.err = bufio.ErrTooLong
+			return false
+		}
+		size := 2 * len(t.buf)
+		if size == 0 {
+			size = 4096
+		}
+		buf := make([]byte, size)
+		copy(buf, t.buf[:t.end])
+		t.buf = buf
+	}
+	n, err := t.r.Read(t.buf[t.end:])
+	t.end += n
+	if err != nil {
+		t.err = err
+	}
+	return true
+}
+
+// consume consumes n bytes of the buffer, keeping track of the position.
+func (t *Tokenizer) consume(n int) {
+	t.pos = t.pos.advance(t.buf[t.start : t.start+n])
+	t.start += n
+}
+
+// Pos returns the position of the token last returned by Next.
+func (t *Tokenizer) Pos() Pos {
+	return t.tokenPos
+}
+
 // Next returns the next token, or if any, errors.
 // Returns nil, nil on end of the input.
 func (t *Tokenizer) Next() (*Token, error) {
-	if !t.sc.Scan() {
-		return nil, t.sc.Err()
+	t.lastRuneSize = -1
+	for {
+		data := t.buf[t.start:t.end]
+		advance, tok, err := splitFunc(data, t.err != nil)
+		if err != nil {
+			return nil, err
+		}
+		if advance > 0 {
+			// tokens always end at the advanced position
+			t.tokenPos = t.pos.advance(data[:advance-len(tok)])
+			t.consume(advance)
+		}
+		if tok != nil {
+			return newToken(string(tok)), nil
+		}
+		if advance > 0 {
+			continue
+		}
+		if !t.fill() {
+			if t.err == io.EOF {
+				return nil, nil
+			}
+			return nil, t.err
+		}
 	}
+}
 
-	str := t.sc.Text()
+func newToken(str string) *Token {
 	switch str {
 	case "(":
 		return &Token{
 			Type:   LeftPar,
 			String: "",
-		}, nil
+		}
 	case ")":
 		return &Token{
 			Type:   RightPar,
 			String: "",
-		}, nil
+		}
 	}
 
 	return &Token{
 		Type:   Word,
 		String: str,
-	}, nil
+	}
+}
+
+// ReadRune reads the next character of the input, after the last token returned by Next.
+// Returns io.EOF at the end of the input.
+func (t *Tokenizer) ReadRune() (r rune, size int, err error) {
+	t.lastRuneSize = -1
+	for !utf8.FullRune(t.buf[t.start:t.end]) && t.fill() {
+	}
+	if t.start == t.end {
+		return 0, 0, t.err
+	}
+	r, size = utf8.DecodeRune(t.buf[t.start:t.end])
+	t.lastRuneSize, t.lastRunePos = size, t.pos
+	t.consume(size)
+	return r, size, nil
+}
+
+// UnreadRune unreads the character last read by ReadRune.
+func (t *Tokenizer) UnreadRune() error {
+	if t.lastRuneSize < 0 {
+		return bufio.ErrInvalidUnreadRune
+	}
+	t.start -= t.lastRuneSize
+	t.pos = t.lastRunePos
+	t.lastRuneSize = -1
+	return nil
 }
@@ -90,6 +90,53 @@ func TestTokenizer(t *testing.T) {
 				{Type: Word, String: "po"},
 			},
 		},
+		{
+			name:   "char",
+			string: `(#\a #\( #\) #\space #\;)`,
+			want: []Token{
+				{Type: LeftPar},
+				{Type: Word, String: `#\a`},
+				{Type: Word, String: `#\(`},
+				{Type: Word, String: `#\)`},
+				{Type: Word, String: `#\space`},
+				{Type: Word, String: `#\;`},
+				{Type: RightPar},
+			},
+		},
+		{
+			name:   "datum labels",
+			string: "#0=(a . #0#) #1=po #12=#1# #2 #",
+			want: []Token{
+				{Type: Word, String: "#0="},
+				{Type: LeftPar},
+				{Type: Word, String: "a"},
+				{Type: Word, String: "."},
+				{Type: Word, String: "#0#"},
+				{Type: RightPar},
+				{Type: Word, String: "#1="},
+				{Type: Word, String: "po"},
+				{Type: Word, String: "#12="},
+				{Type: Word, String: "#1#"},
+				{Type: Word, String: "#2"},
+				{Type: Word, String: "#"},
+			},
+		},
+		{
+			name:   "string with escapes",
+			string: `"po \"po\" \\" po`,
+			want: []Token{
+				{Type: Word, String: `"po \"po\" \\"`},
+				{Type: Word, String: "po"},
+			},
+		},
+		{
+			name:   "symbol with bars",
+			string: `|po po| |\|po|`,
+			want: []Token{
+				{Type: Word, String: "|po po|"},
+				{Type: Word, String: `|\|po|`},
+			},
+		},
 		{
 			name:   "string unexpected EOF",
 			string: "po \"po",
@@ -110,3 +157,63 @@ func TestTokenizer(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizerPos(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader("(po ; comment\n  \"a\nb\" 'c)\n#\\x"))
+	want := []Pos{
+		{Line: 1, Column: 1},
+		{Line: 1, Column: 2},
+		{Line: 2, Column: 3},
+		{Line: 3, Column: 4},
+		{Line: 3, Column: 5},
+		{Line: 3, Column: 6},
+		{Line: 4, Column: 1},
+	}
+	var got []Pos
+	for {
+		token, err := tokenizer.Next()
+		if err != nil {
+			t.Fatalf("error while reading tokens: %v", err)
+		}
+		if token == nil {
+			break
+		}
+		got = append(got, tokenizer.Pos())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTokenizerReadRune(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader("(a)bc d"))
+	var got []string
+	for _, read := range []string{"token", "token", "token", "rune", "unread", "rune", "rune", "token", "rune"} {
+		switch read {
+		case "token":
+			token, err := tokenizer.Next()
+			if err != nil {
+				t.Fatalf("error while reading tokens: %v", err)
+			}
+			got = append(got, token.Type.String()+" "+token.String)
+		case "rune":
+			r, _, err := tokenizer.ReadRune()
+			if err != nil {
+				got = append(got, err.Error())
+			} else {
+				got = append(got, string(r))
+			}
+		case "unread":
+			if err := tokenizer.UnreadRune(); err != nil {
+				t.Fatalf("error while unreading rune: %v", err)
+			}
+		}
+	}
+	want := []string{"left_par ", "word a", "right_par ", "b", "b", "c", "word d", "EOF"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if err := tokenizer.UnreadRune(); err == nil {
+		t.Errorf("UnreadRune() after EOF succeeded, want error")
+	}
+}